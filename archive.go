@@ -0,0 +1,331 @@
+package uniquefile
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"io"
+	"sort"
+
+	"github.com/skillian/errors"
+)
+
+const (
+	archiveIndicatorKey = "archive"
+	membersKey          = "members"
+	manifestKey         = "manifest"
+
+	// defaultArchiveLimit is the default limit on the size of a ZIP
+	// archive that is read into memory.
+	defaultArchiveLimit = 256 << 20
+)
+
+// ArchiveIndicator reads the members of ZIP archives (including
+// formats such as JAR and Office Open XML documents that are ZIP
+// archives) and of tar archives, optionally compressed with gzip.  It
+// writes two keys:
+//
+//	members:	The length and SHA-256 of each member file's
+//			contents (see ParseArchiveMembers) sorted so that
+//			the order of the members doesn't matter.
+//	manifest:	The SHA-256 of the members' names, lengths and
+//			hashes sorted by name.
+//
+// Archives with the same members have the same manifest even if they
+// were packed in a different order, with different compression or
+// with different timestamps.  Because the member hashes are plain
+// SHA-256 hashes, they can also be matched against the sha256 of
+// files outside of archives.
+//
+// If the data is not an archive in one of those formats or if the
+// archive is corrupt (e.g. truncated or with a member whose checksum
+// doesn't match), nothing is written.  ZIP archives are read with
+// random access, so if r is not an io.ReaderAt and io.Seeker (like
+// *os.File), the whole archive is read into memory first and nothing
+// is written for archives larger than 256MiB (see NewArchiveIndicator).
+var ArchiveIndicator Indicator = archiveIndicator{limit: defaultArchiveLimit}
+
+// NewArchiveIndicator creates an Indicator like ArchiveIndicator that
+// reads ZIP archives of up to limit bytes into memory when they can't
+// be read with random access.  Larger archives are skipped without
+// writing anything.
+func NewArchiveIndicator(limit int64) (Indicator, error) {
+	if limit <= 0 {
+		return nil, errors.Errorf(
+			"archive size limit must be positive, not %d",
+			limit,
+		)
+	}
+	return archiveIndicator{limit: limit}, nil
+}
+
+func newArchiveIndicatorFromSpec(spec IndicatorSpec) (Indicator, error) {
+	if err := spec.CheckParams("limit"); err != nil {
+		return nil, err
+	}
+	limit, err := spec.Size("limit", defaultArchiveLimit)
+	if err != nil {
+		return nil, err
+	}
+	return NewArchiveIndicator(limit)
+}
+
+type archiveIndicator struct {
+	limit int64
+}
+
+func (ir archiveIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	br := bufio.NewReaderSize(readerContext{ctx, r}, mimeTypeSniffLen)
+	head, err := br.Peek(mimeTypeSniffLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var members []archiveMember
+	switch mimeType := DetectContentType(head); {
+	case isZIPMIMEType(mimeType):
+		var zr *zip.Reader
+		if zr, err = newZIPReader(ctx, r, br, ir.limit); zr == nil || err != nil {
+			if err != nil && isCorruptArchive(err) {
+				return nil
+			}
+			return err
+		}
+		members, err = zipMembers(ctx, zr)
+	case mimeType == "application/x-tar":
+		members, err = tarMembers(ctx, br)
+	case mimeType == "application/x-gzip" || mimeType == "application/gzip":
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(br); err != nil {
+			return nil
+		}
+		gzbr := bufio.NewReaderSize(gz, mimeTypeSniffLen)
+		if head, err = gzbr.Peek(mimeTypeSniffLen); err != nil && err != io.EOF {
+			// corrupt gzip data:
+			return nil
+		}
+		if DetectContentType(head) != "application/x-tar" {
+			return nil
+		}
+		members, err = tarMembers(ctx, gzbr)
+	default:
+		return nil
+	}
+	if err != nil {
+		if isCorruptArchive(err) {
+			return nil
+		}
+		return err
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].name != members[j].name {
+			return members[i].name < members[j].name
+		}
+		return bytes.Compare(members[i].Hash[:], members[j].Hash[:]) < 0
+	})
+	manifest := sha256.New()
+	records := make([][]byte, len(members))
+	for i, m := range members {
+		records[i] = m.appendRecord(nil)
+		manifest.Write([]byte(m.name))
+		manifest.Write([]byte{0})
+		manifest.Write(records[i])
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return bytes.Compare(records[i], records[j]) < 0
+	})
+	ind.Write([]byte(membersKey), bytes.Join(records, nil))
+	ind.Write([]byte(manifestKey), manifest.Sum(nil))
+	return nil
+}
+
+// zipMIMETypes are the media types detected by DetectContentType that
+// are ZIP archives.
+var zipMIMETypes = func() map[string]struct{} {
+	m := map[string]struct{}{zipMIMEType: {}}
+	for _, zm := range zipMagics {
+		m[zm.mimeType] = struct{}{}
+	}
+	return m
+}()
+
+func isZIPMIMEType(mimeType string) bool {
+	_, ok := zipMIMETypes[mimeType]
+	return ok
+}
+
+func zipMembers(ctx context.Context, zr *zip.Reader) ([]archiveMember, error) {
+	members := make([]archiveMember, 0, len(zr.File))
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			if isCorruptArchive(err) {
+				return nil, err
+			}
+			return nil, errors.ErrorfWithCause(
+				err, "failed to open ZIP member %q", f.Name,
+			)
+		}
+		m, err := newArchiveMember(ctx, f.Name, rc)
+		if closeErr := rc.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			if isCorruptArchive(err) {
+				return nil, err
+			}
+			return nil, errors.ErrorfWithCause(
+				err, "failed to read ZIP member %q", f.Name,
+			)
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+// newZIPReader opens the ZIP archive that r and br read.  br must
+// have been created over r and not read from yet except for peeks.
+// If r is an io.ReaderAt and io.Seeker, the archive is read with
+// random access.  Otherwise, the rest of br is read into memory.  If
+// the data is not a ZIP archive or if it has to be read into memory
+// and is larger than limit, nil is returned without an error.
+func newZIPReader(ctx context.Context, r io.Reader, br *bufio.Reader, limit int64) (*zip.Reader, error) {
+	type readerAtSeeker interface {
+		io.ReaderAt
+		io.Seeker
+	}
+	var ra io.ReaderAt
+	var size int64
+	if rs, ok := r.(readerAtSeeker); ok {
+		// ReadAt doesn't depend on the current offset, so it
+		// doesn't matter what br already read.
+		end, err := rs.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		ra, size = rs, end
+	} else {
+		var buf bytes.Buffer
+		n, err := copyContext(ctx, &buf, io.LimitReader(br, limit+1), nil)
+		if err != nil {
+			return nil, err
+		}
+		if n > limit {
+			return nil, nil
+		}
+		ra, size = bytes.NewReader(buf.Bytes()), int64(buf.Len())
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		if err == zip.ErrFormat {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return zr, nil
+}
+
+func tarMembers(ctx context.Context, r io.Reader) ([]archiveMember, error) {
+	tr := tar.NewReader(r)
+	var members []archiveMember
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				return members, nil
+			}
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		m, err := newArchiveMember(ctx, hdr.Name, tr)
+		if err != nil {
+			if isCorruptArchive(err) {
+				return nil, err
+			}
+			return nil, errors.ErrorfWithCause(
+				err, "failed to read tar member %q", hdr.Name,
+			)
+		}
+		members = append(members, m)
+	}
+}
+
+// isCorruptArchive checks if err means that an archive was corrupt
+// instead of that it couldn't be read.  The errors are checked before
+// they are wrapped.
+func isCorruptArchive(err error) bool {
+	switch err {
+	case tar.ErrHeader, zip.ErrFormat, zip.ErrChecksum, zip.ErrAlgorithm:
+		return true
+	}
+	return isCorruptCompression(err)
+}
+
+type archiveMember struct {
+	name string
+	ArchiveMember
+}
+
+func newArchiveMember(ctx context.Context, name string, r io.Reader) (m archiveMember, err error) {
+	h := sha256.New()
+	bp := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(bp)
+	n, err := copyContext(ctx, h, r, *bp)
+	if err != nil {
+		return
+	}
+	m.name = name
+	m.Length = uint64(n)
+	h.Sum(m.Hash[:0])
+	return
+}
+
+// archiveMemberSize is the size of an encoded member:  A 64-bit length
+// followed by the hash.
+const archiveMemberSize = 8 + sha256.Size
+
+// ArchiveMember is a member of an archive indicated by the
+// ArchiveIndicator.
+type ArchiveMember struct {
+	// Length of the member's contents in bytes.
+	Length uint64
+
+	// Hash is the SHA-256 of the member's contents.
+	Hash [sha256.Size]byte
+}
+
+func (m ArchiveMember) appendRecord(bs []byte) []byte {
+	var buf [archiveMemberSize]byte
+	byteOrder.PutUint64(buf[:8], m.Length)
+	copy(buf[8:], m.Hash[:])
+	return append(bs, buf[:]...)
+}
+
+// ParseArchiveMembers parses the value written under the "members" key
+// by the ArchiveIndicator.  The value is a sequence of members, each of
+// which is the member's length as a big endian 64-bit integer followed
+// by its hash.
+func ParseArchiveMembers(value []byte) ([]ArchiveMember, error) {
+	if len(value)%archiveMemberSize != 0 {
+		return nil, errors.Errorf(
+			"archive members value length %d is not a "+
+				"multiple of %d",
+			len(value), archiveMemberSize,
+		)
+	}
+	members := make([]ArchiveMember, len(value)/archiveMemberSize)
+	for i := range members {
+		bs := value[i*archiveMemberSize:]
+		members[i].Length = byteOrder.Uint64(bs[:8])
+		copy(members[i].Hash[:], bs[8:archiveMemberSize])
+	}
+	return members, nil
+}
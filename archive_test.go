@@ -0,0 +1,220 @@
+package uniquefile_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/skillian/uniquefile"
+)
+
+type archiveFile struct {
+	name, contents string
+}
+
+func zipArchive(t *testing.T, files ...archiveFile) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, f.contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarArchive(t *testing.T, gz bool, files ...archiveFile) []byte {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gw *gzip.Writer
+	if gz {
+		gw = gzip.NewWriter(&buf)
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir, Name: "dir/", Mode: 0755,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Name: f.name, Mode: 0644, Size: int64(len(f.contents)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, f.contents); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func indicateArchive(t *testing.T, r io.Reader) uniquefile.IndicationLookup {
+	ind := uniquefile.NewIndication()
+	if err := uniquefile.ArchiveIndicator.Indicate(context.Background(), r, ind); err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := ind.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lookup
+}
+
+func TestArchiveIndicator(t *testing.T) {
+	a := archiveFile{"dir/a.txt", "hello"}
+	b := archiveFile{"dir/b.txt", "world!"}
+	expect := indicateArchive(t, bytes.NewReader(zipArchive(t, a, b)))
+	members, err := uniquefile.ParseArchiveMembers(expect["members"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("expected 2 members, got %v", members)
+	}
+	for _, f := range []archiveFile{a, b} {
+		sum := sha256.Sum256([]byte(f.contents))
+		found := false
+		for _, m := range members {
+			found = found || (m.Hash == sum && m.Length == uint64(len(f.contents)))
+		}
+		if !found {
+			t.Fatalf("member %v not found in %v", f.name, members)
+		}
+	}
+	for _, tc := range []struct {
+		name string
+		r    io.Reader
+		same bool
+	}{
+		{"zipReordered", bytes.NewReader(zipArchive(t, b, a)), true},
+		{"zipNotSeekable", iotest.OneByteReader(bytes.NewReader(zipArchive(t, a, b))), true},
+		{"tar", bytes.NewReader(tarArchive(t, false, b, a)), true},
+		{"tarGzip", iotest.HalfReader(bytes.NewReader(tarArchive(t, true, a, b))), true},
+		{"renamed", bytes.NewReader(zipArchive(t, a, archiveFile{"dir/c.txt", b.contents})), false},
+		{"changed", bytes.NewReader(tarArchive(t, false, a, archiveFile{b.name, "world?"})), false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			actual := indicateArchive(t, tc.r)
+			if len(actual) != 2 {
+				t.Fatalf("expected members and manifest, got %v", actual)
+			}
+			if same := bytes.Equal(actual["manifest"], expect["manifest"]); same != tc.same {
+				t.Fatalf("expected same manifest: %v, actual: %v", tc.same, same)
+			}
+			if tc.name == "renamed" && !bytes.Equal(actual["members"], expect["members"]) {
+				t.Fatal("expected renamed members to have the same hashes")
+			}
+		})
+	}
+	t.Run("docx", func(t *testing.T) {
+		docx := zipArchive(t, archiveFile{"[Content_Types].xml", "<Types/>"}, archiveFile{"word/document.xml", "<document/>"})
+		if actual := indicateArchive(t, bytes.NewReader(docx)); len(actual) != 2 {
+			t.Fatalf("expected members and manifest, got %v", actual)
+		}
+	})
+	t.Run("corrupt", func(t *testing.T) {
+		tarData := tarArchive(t, false, a, b)
+		tgzData := tarArchive(t, true, a, b)
+		// The first member is stored, so flipping a byte of its
+		// contents only breaks its CRC-32.
+		badCRC := storedZipOf(t, a.name, a.contents, b.name, b.contents)
+		i := bytes.Index(badCRC, []byte(a.contents))
+		badCRC[i] ^= 0xff
+		for _, tc := range []struct {
+			name string
+			data []byte
+		}{
+			{"truncatedTar", tarData[:512+2]},
+			{"truncatedTarMember", tarData[:512+512+2]},
+			{"truncatedTarGzip", tgzData[:len(tgzData)/2]},
+			{"zipBadCRC", badCRC},
+		} {
+			for _, r := range []io.Reader{
+				bytes.NewReader(tc.data),
+				iotest.OneByteReader(bytes.NewReader(tc.data)),
+			} {
+				if actual := indicateArchive(t, r); len(actual) != 0 {
+					t.Fatalf("%s: expected nothing, got %v", tc.name, actual)
+				}
+			}
+		}
+	})
+	t.Run("notArchive", func(t *testing.T) {
+		var gz bytes.Buffer
+		gw := gzip.NewWriter(&gz)
+		io.WriteString(gw, "not a tar file")
+		gw.Close()
+		for _, data := range [][]byte{[]byte("hello, world!"), gz.Bytes()} {
+			if actual := indicateArchive(t, bytes.NewReader(data)); len(actual) != 0 {
+				t.Fatalf("expected nothing, got %v", actual)
+			}
+		}
+	})
+}
+
+func TestArchiveIndicatorLimit(t *testing.T) {
+	data := zipArchive(t, archiveFile{"a.txt", strings.Repeat("hello ", 1000)})
+	spec, err := uniquefile.ParseIndicatorSpec("archive:limit=" + strconv.Itoa(len(data)-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ir, err := uniquefile.NewIndicator(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		r      io.Reader
+		expect int
+	}{
+		// The limit is only on archives that are read into
+		// memory.
+		{"seekable", bytes.NewReader(data), 2},
+		{"notSeekable", iotest.OneByteReader(bytes.NewReader(data)), 0},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ind := uniquefile.NewIndication()
+			defer uniquefile.PutIndication(&ind)
+			if err := ir.Indicate(context.Background(), tc.r, ind); err != nil {
+				t.Fatal(err)
+			}
+			lookup, err := ind.Lookup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lookup) != tc.expect {
+				t.Fatalf("expected %d keys, got %v", tc.expect, lookup)
+			}
+		})
+	}
+	if _, err := uniquefile.NewArchiveIndicator(0); err == nil {
+		t.Fatal("expected error from a zero limit")
+	}
+}
@@ -0,0 +1,496 @@
+package uniquefile
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/bits"
+	"math/cmplx"
+
+	"github.com/skillian/errors"
+)
+
+const (
+	audioIndicatorKey = "audio"
+
+	// audioSampleRate is the rate that audio is resampled to before
+	// it is fingerprinted.  It keeps frequencies up to about 5.5kHz
+	// which is plenty for the notes and bands that are compared.
+	audioSampleRate = 11025
+
+	// audioFrameSize is the number of samples in each frame that is
+	// transformed (about 0.37 seconds) and audioHopSize is how far
+	// apart the frames start.
+	audioFrameSize = 4096
+	audioHopSize   = audioFrameSize / 2
+
+	// audioMaxDuration is how many seconds from the beginning of the
+	// audio are fingerprinted.
+	audioMaxDuration = 120
+
+	// audioMaxOffset is how many frames (about three seconds) two
+	// fingerprints are shifted against each other when they're
+	// compared, in case one of the recordings has more silence at
+	// the beginning.
+	audioMaxOffset = 16
+
+	// audioMinFreq and audioMaxFreq limit the frequencies that make
+	// up the chroma to the notes from A1 to A7.
+	audioMinFreq = 55
+	audioMaxFreq = 3520
+)
+
+// AudioIndicator decodes WAV and FLAC audio and writes a fingerprint
+// of the first two minutes of it under the "audio" key.  Only the
+// samples are fingerprinted, so tags and other metadata in the file
+// don't affect it, and because the samples are mixed down to one
+// channel and resampled, copies of a recording with different bit
+// depths, sample rates or numbers of channels have fingerprints that
+// are almost the same.
+//
+// The audio is split into overlapping frames of about 0.37 seconds.
+// The fingerprint has a 32-bit big endian code for each frame:  Its
+// bits are whether the energy of each of the 12 notes of the scale
+// (the chroma) is greater than the next note's and than its own in the
+// previous frame, and whether the differences between the energy of 9
+// frequency bands increased since the previous frame.  The
+// Indicator's Similarity method compares the codes (see its
+// documentation).
+//
+// Both integer and floating point WAV files are supported, but not
+// compressed formats like ADPCM.  If the data is not audio in one of
+// the supported formats, or if it is corrupt, nothing is written.
+var AudioIndicator interface {
+	Indicator
+	IndicatorSimilarer
+} = audioIndicator{}
+
+type audioIndicator struct{}
+
+func (audioIndicator) Keys() []Bytes { return []Bytes{audioIndicatorKey} }
+
+// Similarity compares the codes of two fingerprints at the offset
+// where they match the best.  The score is 1 when the codes are all the
+// same and 0 when half of their bits differ, which is what happens
+// with unrelated audio.  It is scaled down when the fingerprints
+// don't completely overlap.
+func (audioIndicator) Similarity(ctx context.Context, key, a, b []byte) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if string(key) != audioIndicatorKey {
+		return 0, ErrCannotCmp
+	}
+	if err := checkAudioFingerprint(a); err != nil {
+		return 0, err
+	}
+	if err := checkAudioFingerprint(b); err != nil {
+		return 0, err
+	}
+	na, nb := len(a)/4, len(b)/4
+	longest := na
+	if nb > longest {
+		longest = nb
+	}
+	best := 0.0
+	for off := -audioMaxOffset; off <= audioMaxOffset; off++ {
+		// compare a[i] with b[i+off]:
+		lo, hi := 0, na
+		if off < 0 {
+			lo = -off
+		}
+		if nb-off < hi {
+			hi = nb - off
+		}
+		if hi <= lo {
+			continue
+		}
+		diff := 0
+		for i := lo; i < hi; i++ {
+			diff += bits.OnesCount32(
+				byteOrder.Uint32(a[i*4:]) ^ byteOrder.Uint32(b[(i+off)*4:]),
+			)
+		}
+		n := hi - lo
+		s := 1 - 2*float64(diff)/float64(32*n)
+		if s <= 0 {
+			continue
+		}
+		if s *= float64(n) / float64(longest); s > best {
+			best = s
+		}
+	}
+	return best, nil
+}
+
+// Threshold considers recordings whose codes differ in a quarter of
+// their bits or less to be near-duplicates.
+func (audioIndicator) Threshold(key []byte) float64 { return 0.5 }
+
+func (audioIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	br := bufio.NewReader(readerContext{ctx, r})
+	head, err := br.Peek(12)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var fp *audioFingerprinter
+	switch {
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		fp, err = decodeWAV(br)
+	case len(head) >= 4 && string(head[:4]) == "fLaC":
+		fp, err = decodeFLAC(br)
+	default:
+		return nil
+	}
+	if err != nil {
+		if _, ok := err.(audioFormatError); ok {
+			return nil
+		}
+		return err
+	}
+	if sig := fp.finish(); len(sig) > 0 {
+		ind.Write([]byte(audioIndicatorKey), sig)
+	}
+	return nil
+}
+
+func checkAudioFingerprint(sig []byte) error {
+	if len(sig) == 0 || len(sig)%4 != 0 {
+		return errors.Errorf(
+			"audio fingerprint length must be a positive "+
+				"multiple of 4, not %d",
+			len(sig),
+		)
+	}
+	return nil
+}
+
+// audioFormatError means that audio data is corrupt or uses a format
+// that isn't supported.
+type audioFormatError string
+
+func (e audioFormatError) Error() string { return string(e) }
+
+// errAudioLimit is returned by audioFingerprinter.add after
+// audioMaxDuration seconds were fingerprinted to stop decoding.
+var errAudioLimit = errors.New("audio fingerprint is complete")
+
+// decodeWAV decodes a RIFF WAVE file.  A data chunk that is cut short
+// is fingerprinted up to where it ends.
+func decodeWAV(r io.Reader) (*audioFingerprinter, error) {
+	var buf [40]byte
+	if _, err := io.ReadFull(r, buf[:12]); err != nil {
+		return nil, err
+	}
+	var format []byte
+	for {
+		if _, err := io.ReadFull(r, buf[:8]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, audioFormatError("WAV file has no data chunk")
+			}
+			return nil, err
+		}
+		id, size := string(buf[:4]), int64(binary.LittleEndian.Uint32(buf[4:8]))
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, audioFormatError("WAV format chunk is too short")
+			}
+			n := size
+			if n > int64(len(buf)) {
+				n = int64(len(buf))
+			}
+			if _, err := io.ReadFull(r, buf[:n]); err != nil {
+				return nil, wavEOF(err)
+			}
+			format = append([]byte(nil), buf[:n]...)
+			size -= n
+		case "data":
+			if format == nil {
+				return nil, audioFormatError("WAV data chunk before format chunk")
+			}
+			var dr io.Reader = r
+			// streaming writers leave the size at its maximum
+			// (or zero) because they don't know it yet.
+			if size != 0 && size != math.MaxUint32 {
+				dr = io.LimitReader(r, size)
+			}
+			return decodeWAVData(format, dr)
+		}
+		// chunks are padded to an even size:
+		if _, err := io.CopyN(io.Discard, r, size+size&1); err != nil {
+			return nil, wavEOF(err)
+		}
+	}
+}
+
+func wavEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return audioFormatError("WAV file is truncated")
+	}
+	return err
+}
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xfffe
+)
+
+func decodeWAVData(format []byte, r io.Reader) (*audioFingerprinter, error) {
+	tag := binary.LittleEndian.Uint16(format[0:])
+	channels := int(binary.LittleEndian.Uint16(format[2:]))
+	rate := int64(binary.LittleEndian.Uint32(format[4:]))
+	blockAlign := int(binary.LittleEndian.Uint16(format[12:]))
+	if tag == wavFormatExtensible {
+		if len(format) < 40 {
+			return nil, audioFormatError("WAV extensible format chunk is too short")
+		}
+		// the sub-format GUID starts with the format tag:
+		tag = binary.LittleEndian.Uint16(format[24:])
+	}
+	if channels == 0 || rate == 0 || blockAlign == 0 || blockAlign%channels != 0 {
+		return nil, audioFormatError("invalid WAV format")
+	}
+	// Samples are read by the size of their containers instead of
+	// their bits per sample:  Samples with fewer bits are padded
+	// with zeros in the low bits.
+	width := blockAlign / channels
+	var sample func(bs []byte) float64
+	switch {
+	case tag == wavFormatPCM && width == 1:
+		sample = func(bs []byte) float64 { return (float64(bs[0]) - 128) / 128 }
+	case tag == wavFormatPCM && width <= 4:
+		shift := uint(64 - 8*width)
+		scale := math.Ldexp(1, 8*width-1)
+		sample = func(bs []byte) float64 {
+			var v uint64
+			for i := len(bs) - 1; i >= 0; i-- {
+				v = v<<8 | uint64(bs[i])
+			}
+			return float64(int64(v<<shift)>>shift) / scale
+		}
+	case tag == wavFormatFloat && width == 4:
+		sample = func(bs []byte) float64 {
+			return float64(math.Float32frombits(binary.LittleEndian.Uint32(bs)))
+		}
+	case tag == wavFormatFloat && width == 8:
+		sample = func(bs []byte) float64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(bs))
+		}
+	default:
+		return nil, audioFormatError("unsupported WAV format")
+	}
+	fp := newAudioFingerprinter(rate)
+	bp := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(bp)
+	buf := (*bp)[:len(*bp)/blockAlign*blockAlign]
+	mono := make([]float64, len(buf)/blockAlign)
+	for {
+		n, err := io.ReadFull(r, buf)
+		n /= blockAlign
+		for i := 0; i < n; i++ {
+			block := buf[i*blockAlign : (i+1)*blockAlign]
+			var sum float64
+			for c := 0; c < channels; c++ {
+				sum += sample(block[c*width : (c+1)*width])
+			}
+			mono[i] = sum / float64(channels)
+		}
+		if addErr := fp.add(mono[:n]); addErr != nil {
+			if addErr == errAudioLimit {
+				return fp, nil
+			}
+			return nil, addErr
+		}
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return fp, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// audioFingerprinter resamples mono audio to audioSampleRate and
+// computes the code of each frame.
+type audioFingerprinter struct {
+	rate int64
+
+	// resampling:  Input samples are averaged into the output
+	// sample that they fall into.
+	in, out int64
+	acc     float64
+	accN    int
+
+	samples []float64
+	fft     []complex128
+	chroma  [12]float64
+	bands   [audioBands]float64
+	codes   []byte
+}
+
+func newAudioFingerprinter(rate int64) *audioFingerprinter {
+	return &audioFingerprinter{
+		rate:    rate,
+		samples: make([]float64, 0, audioFrameSize),
+		fft:     make([]complex128, audioFrameSize),
+	}
+}
+
+// add adds the next samples.  It returns errAudioLimit when no more
+// samples are needed.
+func (fp *audioFingerprinter) add(samples []float64) error {
+	for _, x := range samples {
+		o := fp.in * audioSampleRate / fp.rate
+		fp.in++
+		if o > fp.out && fp.accN > 0 {
+			v := fp.acc / float64(fp.accN)
+			// when upsampling, some output samples have no
+			// input samples, so repeat the last value:
+			for ; fp.out < o; fp.out++ {
+				fp.emit(v)
+			}
+			fp.acc, fp.accN = 0, 0
+			if fp.out >= audioMaxDuration*audioSampleRate {
+				return errAudioLimit
+			}
+		}
+		fp.acc += x
+		fp.accN++
+	}
+	return nil
+}
+
+func (fp *audioFingerprinter) emit(v float64) {
+	fp.samples = append(fp.samples, v)
+	if len(fp.samples) < audioFrameSize {
+		return
+	}
+	fp.frame()
+	n := copy(fp.samples, fp.samples[audioHopSize:])
+	fp.samples = fp.samples[:n]
+}
+
+// finish returns the fingerprint.  Audio that is too short for a
+// whole frame is padded with silence.
+func (fp *audioFingerprinter) finish() []byte {
+	if fp.accN > 0 && fp.out < audioMaxDuration*audioSampleRate {
+		fp.emit(fp.acc / float64(fp.accN))
+		fp.acc, fp.accN = 0, 0
+	}
+	if len(fp.codes) == 0 && len(fp.samples) > 0 {
+		for len(fp.samples) < audioFrameSize {
+			fp.samples = append(fp.samples, 0)
+		}
+		fp.frame()
+	}
+	return fp.codes
+}
+
+const audioBands = 9
+
+// audioBins maps the frequency bins of a frame to the note of the scale
+// (0 is A) and the band that they belong to, or -1 if they're out of
+// range.
+var audioBins = func() (bins [audioFrameSize / 2]struct{ note, band int8 }) {
+	// the bands are spaced logarithmically between 300Hz and
+	// 3kHz:
+	const lo, hi = 300.0, 3000.0
+	for i := range bins {
+		f := float64(i) * audioSampleRate / audioFrameSize
+		bins[i].note, bins[i].band = -1, -1
+		if i > 0 && f >= audioMinFreq && f < audioMaxFreq {
+			semitones := math.Round(12 * math.Log2(f/audioMinFreq))
+			bins[i].note = int8(int(semitones) % 12)
+		}
+		if f >= lo && f < hi {
+			bins[i].band = int8(math.Log(f/lo) / math.Log(hi/lo) * audioBands)
+		}
+	}
+	return
+}()
+
+var audioWindow = func() (w [audioFrameSize]float64) {
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/(audioFrameSize-1))
+	}
+	return
+}()
+
+func (fp *audioFingerprinter) frame() {
+	for i, v := range fp.samples {
+		fp.fft[i] = complex(v*audioWindow[i], 0)
+	}
+	fft(fp.fft)
+	var chroma [12]float64
+	var bands [audioBands]float64
+	var total float64
+	for i, b := range audioBins {
+		if b.note < 0 && b.band < 0 {
+			continue
+		}
+		p := cmplx.Abs(fp.fft[i])
+		p *= p
+		if b.note >= 0 {
+			chroma[b.note] += p
+			total += p
+		}
+		if b.band >= 0 {
+			bands[b.band] += p
+		}
+	}
+	if total > 0 {
+		for i := range chroma {
+			chroma[i] /= total
+		}
+	}
+	var code uint32
+	for i, c := range chroma {
+		if c > chroma[(i+1)%len(chroma)] {
+			code |= 1 << uint(i)
+		}
+		if c > fp.chroma[i] {
+			code |= 1 << uint(12+i)
+		}
+	}
+	for i := 0; i+1 < len(bands); i++ {
+		if bands[i]-bands[i+1] > fp.bands[i]-fp.bands[i+1] {
+			code |= 1 << uint(24+i)
+		}
+	}
+	fp.chroma, fp.bands = chroma, bands
+	var bs [4]byte
+	byteOrder.PutUint32(bs[:], code)
+	fp.codes = append(fp.codes, bs[:]...)
+}
+
+// fft computes the discrete Fourier transform of x in place.  The
+// length of x must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			wk := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*wk
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				wk *= w
+			}
+		}
+	}
+}
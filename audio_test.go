@@ -0,0 +1,332 @@
+package uniquefile_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/skillian/uniquefile"
+)
+
+// tune synthesizes seconds of a melody of random notes with a few
+// harmonics each.  Samples are between -1 and 1.
+func tune(seed int64, rate, seconds int) []float64 {
+	rnd := rand.New(rand.NewSource(seed))
+	samples := make([]float64, rate*seconds)
+	noteLen := rate / 4
+	for start := 0; start < len(samples); start += noteLen {
+		f := 220 * math.Pow(2, float64(rnd.Intn(24))/12)
+		for i := start; i < start+noteLen && i < len(samples); i++ {
+			t := float64(i) / float64(rate)
+			for h := 1; h <= 3; h++ {
+				samples[i] += 0.25 / float64(h) * math.Sin(2*math.Pi*f*float64(h)*t)
+			}
+		}
+	}
+	return samples
+}
+
+// quantize converts samples to integers of the given bits.
+func quantize(samples []float64, bits int) []int64 {
+	max := float64(int64(1)<<uint(bits-1) - 1)
+	qs := make([]int64, len(samples))
+	for i, s := range samples {
+		qs[i] = int64(math.Round(s * max))
+	}
+	return qs
+}
+
+// wavFile creates a WAV file of integer samples.  Every channel gets
+// the same samples.  The samples are preceded by a LIST chunk of tags
+// if tags is set.
+func wavFile(samples []int64, rate, channels, bits int, tags string) []byte {
+	width := bits / 8
+	var data bytes.Buffer
+	for _, s := range samples {
+		for c := 0; c < channels; c++ {
+			for i := 0; i < width; i++ {
+				v := byte(s >> uint(8*i))
+				if bits == 8 {
+					v = byte(s + 128)
+				}
+				data.WriteByte(v)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	binary.Write(&buf, binary.LittleEndian, []uint32{16})
+	binary.Write(&buf, binary.LittleEndian, []uint16{1, uint16(channels)})
+	binary.Write(&buf, binary.LittleEndian, []uint32{
+		uint32(rate), uint32(rate * channels * width),
+	})
+	binary.Write(&buf, binary.LittleEndian, []uint16{
+		uint16(channels * width), uint16(bits),
+	})
+	if tags != "" {
+		if len(tags)%2 != 0 {
+			tags += "\x00"
+		}
+		buf.WriteString("LIST")
+		binary.Write(&buf, binary.LittleEndian, uint32(len(tags)))
+		buf.WriteString(tags)
+	}
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(data.Len()))
+	data.WriteTo(&buf)
+	bs := buf.Bytes()
+	binary.LittleEndian.PutUint32(bs[4:], uint32(len(bs)-8))
+	return bs
+}
+
+// bitWriter writes big endian bits.
+type bitWriter struct {
+	buf bytes.Buffer
+	x   uint64
+	n   uint
+}
+
+func (w *bitWriter) write(v uint64, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.x = w.x<<1 | v>>uint(i)&1
+		if w.n++; w.n == 8 {
+			w.buf.WriteByte(byte(w.x))
+			w.x, w.n = 0, 0
+		}
+	}
+}
+
+func (w *bitWriter) align() {
+	for w.n != 0 {
+		w.write(0, 1)
+	}
+}
+
+// rice writes the residual of samples after order warm-up samples
+// with one partition.
+func (w *bitWriter) rice(residual []int64) {
+	const param = 10
+	w.write(0, 2)
+	w.write(0, 4)
+	w.write(param, 4)
+	for _, r := range residual {
+		v := uint64(r<<1) ^ uint64(r>>63)
+		for q := v >> param; q > 0; q-- {
+			w.write(0, 1)
+		}
+		w.write(1, 1)
+		w.write(v, param)
+	}
+}
+
+// flacFile encodes stereo samples as FLAC.  Its frames alternate
+// between the channel assignments and subframe types that the decoder
+// supports.  CRCs are left as zeros because the decoder doesn't check
+// them.
+func flacFile(left, right []int64, rate, bits int) []byte {
+	const blockSize = 4096
+	var w bitWriter
+	w.buf.WriteString("fLaC")
+	w.write(0, 1)
+	w.write(0, 7)
+	w.write(34, 24)
+	w.write(blockSize, 16)
+	w.write(blockSize, 16)
+	w.write(0, 24)
+	w.write(0, 24)
+	w.write(uint64(rate), 20)
+	w.write(1, 3)
+	w.write(uint64(bits-1), 5)
+	w.write(uint64(len(left)), 36)
+	w.write(0, 64)
+	w.write(0, 64)
+	// a metadata block that is skipped:
+	w.write(1, 1)
+	w.write(4, 7)
+	w.write(8, 24)
+	w.buf.WriteString("tags...!")
+	for frame := 0; frame*blockSize < len(left); frame++ {
+		start, end := frame*blockSize, (frame+1)*blockSize
+		if end > len(left) {
+			end = len(left)
+		}
+		l, r := left[start:end], right[start:end]
+		assignment := []uint64{1, 8, 9, 10}[frame%4]
+		w.write(0x3ffe, 14)
+		w.write(0, 2)
+		if end-start == blockSize {
+			w.write(12, 4)
+		} else {
+			w.write(7, 4)
+		}
+		w.write(0, 4)
+		w.write(assignment, 4)
+		w.write(0, 4)
+		w.write(uint64(frame), 8)
+		if end-start != blockSize {
+			w.write(uint64(end-start-1), 16)
+		}
+		w.write(0, 8)
+		chs := [2][]int64{l, r}
+		side := make([]int64, len(l))
+		for i := range side {
+			side[i] = l[i] - r[i]
+		}
+		sideBit := -1
+		switch assignment {
+		case 8:
+			chs[1], sideBit = side, 1
+		case 9:
+			chs[0], sideBit = side, 0
+		case 10:
+			mid := make([]int64, len(l))
+			for i := range mid {
+				mid[i] = (l[i] + r[i]) >> 1
+			}
+			chs[0], chs[1], sideBit = mid, side, 1
+		}
+		for c, ch := range chs {
+			sampleBits := uint(bits)
+			if c == sideBit {
+				sampleBits++
+			}
+			switch (frame + c) % 3 {
+			case 0:
+				// verbatim:
+				w.write(1<<1, 8)
+				for _, s := range ch {
+					w.write(uint64(s), sampleBits)
+				}
+			case 1:
+				// fixed, order 2:
+				w.write(10<<1, 8)
+				w.write(uint64(ch[0]), sampleBits)
+				w.write(uint64(ch[1]), sampleBits)
+				residual := make([]int64, len(ch)-2)
+				for i := range residual {
+					residual[i] = ch[i+2] - 2*ch[i+1] + ch[i]
+				}
+				w.rice(residual)
+			case 2:
+				// LPC, order 1 with a coefficient of 3/4:
+				w.write(32<<1, 8)
+				w.write(uint64(ch[0]), sampleBits)
+				w.write(3, 4)
+				w.write(2, 5)
+				w.write(3, 4)
+				residual := make([]int64, len(ch)-1)
+				for i := range residual {
+					residual[i] = ch[i+1] - (3*ch[i])>>2
+				}
+				w.rice(residual)
+			}
+		}
+		w.align()
+		w.write(0, 16)
+	}
+	return w.buf.Bytes()
+}
+
+func audioOf(t *testing.T, data []byte) []byte {
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	if err := uniquefile.AudioIndicator.Indicate(context.Background(), bytes.NewReader(data), ind); err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := ind.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), lookup["audio"]...)
+}
+
+func TestAudioIndicator(t *testing.T) {
+	const seconds = 8
+	melody := tune(1, 44100, seconds)
+	expect := audioOf(t, wavFile(quantize(melody, 16), 44100, 2, 16, ""))
+	if len(expect) == 0 {
+		t.Fatal("expected a fingerprint of the WAV file")
+	}
+	cd := quantize(melody, 16)
+	flac := flacFile(cd, cd, 44100, 16)
+	other := quantize(tune(2, 44100, seconds), 16)
+	for _, tc := range []struct {
+		name      string
+		data      []byte
+		different bool
+	}{
+		{"24bitMono", wavFile(quantize(melody, 24), 44100, 1, 24, "INFOtitle"), false},
+		{"8bit", wavFile(quantize(melody, 8), 44100, 1, 8, ""), false},
+		{"22kHz", wavFile(quantize(tune(1, 22050, seconds), 16), 22050, 2, 16, ""), false},
+		{"flac", flac, false},
+		{"flacTruncated", flac[:len(flac)-1000], false},
+		{"other", wavFile(other, 44100, 2, 16, ""), true},
+		{"otherFLAC", flacFile(other, other, 44100, 16), true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			sig := audioOf(t, tc.data)
+			s, err := uniquefile.AudioIndicator.Similarity(
+				context.Background(), []byte("audio"), expect, sig,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			threshold := uniquefile.AudioIndicator.Threshold([]byte("audio"))
+			if different := s < threshold; different != tc.different {
+				t.Fatalf(
+					"expected different: %v, actual: %v "+
+						"(similarity: %.3f)",
+					tc.different, different, s,
+				)
+			}
+		})
+	}
+}
+
+func TestAudioIndicatorFLACStereo(t *testing.T) {
+	// The left and right channels are different so that the FLAC
+	// decoder's channel decorrelation has to be right.
+	left := quantize(tune(3, 8000, 4), 16)
+	right := quantize(tune(4, 8000, 4), 16)
+	mixed := make([]int64, len(left))
+	for i := range mixed {
+		mixed[i] = (left[i] + right[i]) / 2
+	}
+	wav := audioOf(t, wavFile(mixed, 8000, 1, 16, ""))
+	flac := audioOf(t, flacFile(left, right, 8000, 16))
+	s, err := uniquefile.AudioIndicator.Similarity(
+		context.Background(), []byte("audio"), wav, flac,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s < 0.95 {
+		t.Fatalf("expected the FLAC and WAV to match, similarity: %.3f", s)
+	}
+}
+
+func TestAudioIndicatorNotAudio(t *testing.T) {
+	for _, data := range [][]byte{
+		[]byte("not audio"),
+		[]byte("RIFF\x00\x00\x00\x00WAVEdata\x00\x00\x00\x00"),
+		[]byte("fLaC"),
+		[]byte("RIFF\x00\x00\x00\x00AVI LIST"),
+	} {
+		if sig := audioOf(t, data); len(sig) != 0 {
+			t.Fatalf("expected nothing for %q, got %x", data, sig)
+		}
+	}
+	_, err := uniquefile.AudioIndicator.Similarity(
+		context.Background(), []byte("audio"), []byte{1, 2, 3}, []byte{1, 2, 3, 4},
+	)
+	if err == nil {
+		t.Fatal("expected an error for an invalid fingerprint")
+	}
+}
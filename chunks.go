@@ -0,0 +1,340 @@
+package uniquefile
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"math/bits"
+	"strings"
+	"sync"
+
+	"github.com/skillian/errors"
+)
+
+const chunksIndicatorKey = "chunks"
+
+// ChunksIndicator splits its data into content-defined chunks that
+// average 1MiB and writes the list of chunks under the "chunks1m" key.
+var ChunksIndicator interface {
+	Indicator
+	IndicatorSimilarer
+	IndicatorBander
+} = NewChunksIndicator(1 << 20)
+
+// NewChunksIndicator creates an Indicator that splits its data into
+// chunks with FastCDC and writes the ordered list of chunks (see
+// ParseChunks) under a "chunks" key suffixed with the average chunk
+// size (e.g. "chunks1m").  avg must be a power of two between 1KiB and
+// 64MiB.  Chunks are at least avg / 4 bytes and at most avg * 8 bytes.
+//
+// Because the chunk boundaries depend on the content and not on the
+// offset into the data, inserting or removing bytes only changes the
+// chunks around the edit, so data that is mostly the same will have
+// mostly the same chunks.  The Indicator's Similarity method returns
+// the fraction of the data in chunks that two lists have in common.
+func NewChunksIndicator(avg int64) interface {
+	Indicator
+	IndicatorSimilarer
+	IndicatorBander
+} {
+	ir, err := newChunksIndicator(avg)
+	if err != nil {
+		panic(err)
+	}
+	return ir
+}
+
+func newChunksIndicatorFromSpec(spec IndicatorSpec) (Indicator, error) {
+	if err := spec.CheckParams("avg"); err != nil {
+		return nil, err
+	}
+	avg, err := spec.Size("avg", 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	return newChunksIndicator(avg)
+}
+
+func newChunksIndicator(avg int64) (chunksIndicator, error) {
+	if avg < 1<<10 || avg > 64<<20 || avg&(avg-1) != 0 {
+		return chunksIndicator{}, errors.Errorf(
+			"average chunk size must be a power of two "+
+				"between 1KiB and 64MiB, not %d",
+			avg,
+		)
+	}
+	// "Normalized chunking" from the FastCDC paper:  Before the
+	// chunk reaches the average size, more bits of the
+	// fingerprint must be zero to cut it, and after, fewer, so
+	// that chunk sizes cluster around the average.
+	n := uint(bits.TrailingZeros64(uint64(avg)))
+	max := int(avg * 8)
+	return chunksIndicator{
+		key:   chunksIndicatorKey + sizeSuffix(avg),
+		min:   int(avg / 4),
+		avg:   int(avg),
+		max:   max,
+		maskS: ^uint64(0) << (64 - (n + 2)),
+		maskL: ^uint64(0) << (64 - (n - 2)),
+		bufs: &sync.Pool{
+			New: func() interface{} {
+				bs := make([]byte, max)
+				return &bs
+			},
+		},
+	}, nil
+}
+
+type chunksIndicator struct {
+	key   string
+	min   int
+	avg   int
+	max   int
+	maskS uint64
+	maskL uint64
+
+	// bufs holds the buffers of max bytes that the data is read
+	// into so that they're reused across files.
+	bufs *sync.Pool
+}
+
+func (ir chunksIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	r = readerContext{ctx, r}
+	bp := ir.bufs.Get().(*[]byte)
+	defer ir.bufs.Put(bp)
+	buf := *bp
+	var chunks []byte
+	start, end := 0, 0
+	eof := false
+	for {
+		if !eof && end-start < ir.max {
+			n := copy(buf, buf[start:end])
+			start, end = 0, n
+			n, err := io.ReadAtLeast(r, buf[end:], ir.max-end)
+			end += n
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if start == end {
+			break
+		}
+		n := ir.cut(buf[start:end])
+		chunks = appendChunk(chunks, buf[start:start+n])
+		start += n
+	}
+	ind.Write([]byte(ir.key), chunks)
+	return nil
+}
+
+func (ir chunksIndicator) Keys() []Bytes { return []Bytes{Bytes(ir.key)} }
+
+// Similarity returns the fraction of the bytes of the larger of the two
+// chunk lists that are in chunks that both lists have.
+func (ir chunksIndicator) Similarity(ctx context.Context, key, a, b []byte) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if string(key) != ir.key {
+		return 0, ErrCannotCmp
+	}
+	as, err := ParseChunks(a)
+	if err != nil {
+		return 0, err
+	}
+	bs, err := ParseChunks(b)
+	if err != nil {
+		return 0, err
+	}
+	counts := make(map[Chunk]int, len(as))
+	var aTotal, bTotal, shared int64
+	for _, c := range as {
+		counts[c]++
+		aTotal += int64(c.Length)
+	}
+	for _, c := range bs {
+		bTotal += int64(c.Length)
+		if counts[c] > 0 {
+			counts[c]--
+			shared += int64(c.Length)
+		}
+	}
+	if aTotal < bTotal {
+		aTotal = bTotal
+	}
+	if aTotal == 0 {
+		return 1, nil
+	}
+	return float64(shared) / float64(aTotal), nil
+}
+
+// Threshold considers data that shares at least half of its chunks
+// to be a near-duplicate.
+func (chunksIndicator) Threshold(key []byte) float64 { return 0.5 }
+
+// Bands returns the chunks themselves because lists with any
+// similarity must have a chunk in common.
+func (ir chunksIndicator) Bands(ctx context.Context, key, value []byte, threshold float64) ([][]byte, error) {
+	if string(key) != ir.key {
+		return nil, ErrCannotCmp
+	}
+	if len(value)%chunkSize != 0 {
+		return nil, errors.Errorf(
+			"chunks value length %d is not a multiple of %d",
+			len(value), chunkSize,
+		)
+	}
+	if threshold <= 0 || len(value) == 0 {
+		// everything (or every empty list) is a candidate:
+		return [][]byte{nil}, nil
+	}
+	bands := make([][]byte, 0, len(value)/chunkSize)
+	for i := 0; i < len(value); i += chunkSize {
+		bands = append(bands, value[i:i+chunkSize])
+	}
+	return bands, nil
+}
+
+// cut finds the length of the next chunk at the start of data.
+func (ir chunksIndicator) cut(data []byte) int {
+	n := len(data)
+	if n <= ir.min {
+		return n
+	}
+	if n > ir.max {
+		n = ir.max
+	}
+	normal := ir.avg
+	if n < normal {
+		normal = n
+	}
+	var fp uint64
+	i := ir.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&ir.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&ir.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// gearTable is the table of random values that the gear hash adds
+// into the fingerprint for each byte.  It must never change or else
+// chunks from previously written indications will no longer match.
+var gearTable = func() (t [256]uint64) {
+	// splitmix64 with a fixed seed:
+	x := uint64(0x756e6971756566)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+// ChunkHashSize is the size of a Chunk's hash in bytes.
+const ChunkHashSize = 16
+
+// chunkSize is the size of an encoded chunk:  A 32-bit length
+// followed by the hash.
+const chunkSize = 4 + ChunkHashSize
+
+// Chunk is a content-defined chunk of data produced by a chunks
+// indicator.
+type Chunk struct {
+	// Length of the chunk in bytes.
+	Length uint32
+
+	// Hash is the first 16 bytes of the SHA-256 of the chunk.
+	Hash [ChunkHashSize]byte
+}
+
+func appendChunk(bs, data []byte) []byte {
+	var buf [chunkSize]byte
+	byteOrder.PutUint32(buf[:4], uint32(len(data)))
+	sum := sha256.Sum256(data)
+	copy(buf[4:], sum[:ChunkHashSize])
+	return append(bs, buf[:]...)
+}
+
+// IsChunksKey returns true if key is a key written by a chunks
+// indicator.
+func IsChunksKey(key []byte) bool {
+	return strings.HasPrefix(string(key), chunksIndicatorKey)
+}
+
+// ParseChunks parses the value written by a chunks indicator into its
+// list of chunks.  The value is a sequence of chunks, each of which is
+// the chunk's length as a big endian 32-bit integer followed by its
+// hash.
+func ParseChunks(value []byte) ([]Chunk, error) {
+	if len(value)%chunkSize != 0 {
+		return nil, errors.Errorf(
+			"chunks value length %d is not a multiple of %d",
+			len(value), chunkSize,
+		)
+	}
+	chunks := make([]Chunk, len(value)/chunkSize)
+	for i := range chunks {
+		bs := value[i*chunkSize:]
+		chunks[i].Length = byteOrder.Uint32(bs[:4])
+		copy(chunks[i].Hash[:], bs[4:chunkSize])
+	}
+	return chunks, nil
+}
+
+// DedupeSavings calculates the total length of all of the chunks and
+// how many of those bytes are in chunks that are repeated and would
+// not need to be stored again by block-level deduplication.
+func DedupeSavings(chunkLists ...[]Chunk) (total, saved int64) {
+	seen := make(map[Chunk]struct{})
+	for _, chunks := range chunkLists {
+		for _, c := range chunks {
+			total += int64(c.Length)
+			if _, ok := seen[c]; ok {
+				saved += int64(c.Length)
+				continue
+			}
+			seen[c] = struct{}{}
+		}
+	}
+	return
+}
+
+// SharedChunks finds the other resources in the Repo that share chunks
+// with chunks, which were indicated under key.  The result maps each
+// resource to the number of bytes that it shares with chunks.
+func SharedChunks(ctx context.Context, r ChunkRepo, u URI, key Bytes, chunks []Chunk) (map[URI]int64, error) {
+	shared := make(map[URI]int64)
+	seen := make(map[Chunk]struct{}, len(chunks))
+	for _, c := range chunks {
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		seen[c] = struct{}{}
+		uris, err := r.ChunkURIs(ctx, key, c)
+		if err != nil {
+			return nil, err
+		}
+		counted := make(map[URI]struct{}, len(uris))
+		for _, v := range uris {
+			if _, ok := counted[v]; ok || v == u {
+				continue
+			}
+			counted[v] = struct{}{}
+			shared[v] += int64(c.Length)
+		}
+	}
+	return shared, nil
+}
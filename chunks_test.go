@@ -0,0 +1,117 @@
+package uniquefile_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/skillian/uniquefile"
+)
+
+func indicateChunks(t *testing.T, ir uniquefile.Indicator, data []byte) []uniquefile.Chunk {
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	if err := ir.Indicate(context.Background(), bytes.NewReader(data), ind); err != nil {
+		t.Fatal(err)
+	}
+	var chunks []uniquefile.Chunk
+	if err := ind.Each(func(key, value []byte) error {
+		if string(key) != "chunks64k" {
+			t.Fatalf("unexpected key: %q", key)
+		}
+		var err error
+		chunks, err = uniquefile.ParseChunks(value)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+func TestChunksIndicator(t *testing.T) {
+	const avg = 64 << 10
+	ir, ok := uniquefile.ParseIndicator("chunks:avg=64k")
+	if !ok {
+		t.Fatal("failed to parse chunks indicator")
+	}
+	data := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	chunks := indicateChunks(t, ir, data)
+	var total int
+	for i, c := range chunks {
+		total += int(c.Length)
+		if i < len(chunks)-1 && (c.Length < avg/4 || c.Length > avg*8) {
+			t.Fatalf("chunk %d length %d is out of range", i, c.Length)
+		}
+	}
+	if total != len(data) {
+		t.Fatalf("chunks total %d bytes, expected %d", total, len(data))
+	}
+	if n := len(data) / len(chunks); n < avg/2 || n > avg*2 {
+		t.Fatalf("average chunk size %d is far from %d", n, avg)
+	}
+	edited := append(append(append([]byte(nil), data[:1000]...), "inserted"...), data[1000:]...)
+	editedChunks := indicateChunks(t, ir, edited)
+	total64, saved := uniquefile.DedupeSavings(chunks, editedChunks)
+	if int(total64) != len(data)+len(edited) {
+		t.Fatalf("unexpected total: %d", total64)
+	}
+	if saved < int64(len(data))*9/10 {
+		t.Fatalf(
+			"expected most of the edited data's chunks to be "+
+				"shared, but only %d of %d bytes were",
+			saved, len(data),
+		)
+	}
+	if again := indicateChunks(t, ir, data); len(again) != len(chunks) || again[0] != chunks[0] {
+		t.Fatal("chunking is not deterministic")
+	}
+}
+
+func TestParseChunksInvalid(t *testing.T) {
+	if _, err := uniquefile.ParseChunks(make([]byte, 21)); err == nil {
+		t.Fatal("expected error parsing truncated chunks")
+	}
+	if _, ok := uniquefile.ParseIndicator("chunks:avg=1000"); ok {
+		t.Fatal("expected non-power-of-two average to be rejected")
+	}
+}
+
+func TestSharedChunks(t *testing.T) {
+	ctx := context.Background()
+	uri := func(p string) uniquefile.URI {
+		return uniquefile.URI{Scheme: uniquefile.FileScheme, Path: p}
+	}
+	ir, ok := uniquefile.ParseIndicator("chunks:avg=64k")
+	if !ok {
+		t.Fatal("failed to parse chunks indicator")
+	}
+	rng := rand.New(rand.NewSource(2))
+	data := make([]byte, 2<<20)
+	rng.Read(data)
+	other := make([]byte, 2<<20)
+	rng.Read(other)
+	edited := append(append(append([]byte(nil), data[:1<<20]...), "inserted"...), data[1<<20:]...)
+	r := memRepo{}
+	for p, d := range map[string][]byte{"/a": data, "/b": edited, "/c": other} {
+		ind := uniquefile.NewIndication()
+		if err := ir.Indicate(ctx, bytes.NewReader(d), ind); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetIndications(ctx, uri(p), ind); err != nil {
+			t.Fatal(err)
+		}
+		uniquefile.PutIndication(&ind)
+	}
+	shared, err := uniquefile.SharedChunks(ctx, r, uri("/a"), "chunks64k", indicateChunks(t, ir, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 1 {
+		t.Fatalf("expected only /b to share chunks with /a, got %v", shared)
+	}
+	if n := shared[uri("/b")]; n < int64(len(data))*9/10 || n >= int64(len(data)) {
+		t.Fatalf("expected most but not all of /a to be shared, got %d bytes", n)
+	}
+}
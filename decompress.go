@@ -0,0 +1,147 @@
+package uniquefile
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"hash"
+	"io"
+
+	"github.com/skillian/errors"
+)
+
+const (
+	decompressedIndicatorName = "decompressed"
+	decompressedKeyPrefix     = "decomp."
+
+	// defaultDecompressLimit is the default limit on the size of
+	// the decompressed data.
+	defaultDecompressLimit = 4 << 30
+)
+
+// DecompressedIndicator hashes the decompressed contents of gzip, bzip2
+// and zlib data with SHA-256 under the "decomp.sha256" key (see
+// NewDecompressedIndicator).
+var DecompressedIndicator Indicator = decompressedIndicator{
+	key:    decompressedKeyPrefix + sha256Key,
+	hasher: hashers[sha256Key],
+	limit:  defaultDecompressLimit,
+}
+
+// NewDecompressedIndicator creates an Indicator that detects data
+// compressed with gzip, bzip2 or zlib and hashes its decompressed
+// contents with the given algorithm under "decomp." followed by the
+// algorithm (e.g. "decomp.sha256").  The hash is the same as the hash
+// of the uncompressed file, so the uncompressed copy of a file can be
+// found by querying the Repo for the hash under the algorithm's own
+// key (e.g. "sha256").
+//
+// If the data isn't compressed, if it is corrupt, or if it decompresses
+// to more than limit bytes, nothing is written.
+func NewDecompressedIndicator(algo string, limit int64) (Indicator, error) {
+	hasher, ok := hashers[algo]
+	if !ok {
+		return nil, errors.Errorf("unknown hash algorithm: %q", algo)
+	}
+	if limit <= 0 {
+		return nil, errors.Errorf(
+			"decompression limit must be positive, not %d",
+			limit,
+		)
+	}
+	return decompressedIndicator{
+		key:    decompressedKeyPrefix + algo,
+		hasher: hasher,
+		limit:  limit,
+	}, nil
+}
+
+func newDecompressedIndicatorFromSpec(spec IndicatorSpec) (Indicator, error) {
+	if err := spec.CheckParams("algo", "limit"); err != nil {
+		return nil, err
+	}
+	algo, _, err := spec.Hash("algo", sha256Key)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := spec.Size("limit", defaultDecompressLimit)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecompressedIndicator(algo, limit)
+}
+
+type decompressedIndicator struct {
+	key    string
+	hasher func() hash.Hash
+	limit  int64
+}
+
+func (ir decompressedIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	br := bufio.NewReader(readerContext{ctx, r})
+	head, err := br.Peek(3)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var dr io.Reader
+	switch {
+	case len(head) >= 2 && head[0] == 0x1f && head[1] == 0x8b:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil
+		}
+		dr = gz
+	case len(head) >= 3 && string(head) == "BZh":
+		dr = bzip2.NewReader(br)
+	case isZlibHeader(head):
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			return nil
+		}
+		dr = zr
+	default:
+		return nil
+	}
+	h := ir.hasher()
+	bp := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(bp)
+	n, err := copyContext(ctx, h, io.LimitReader(dr, ir.limit+1), *bp)
+	if err != nil {
+		if isCorruptCompression(err) {
+			return nil
+		}
+		return err
+	}
+	if n > ir.limit {
+		return nil
+	}
+	var buf [64]byte
+	ind.Write([]byte(ir.key), h.Sum(buf[:0]))
+	return nil
+}
+
+// isZlibHeader checks if the data starts with a zlib header that uses
+// deflate, which is the only compression method zlib defines.
+func isZlibHeader(head []byte) bool {
+	return len(head) >= 2 &&
+		head[0]&0x0f == 8 && head[0]>>4 <= 7 &&
+		(uint16(head[0])<<8|uint16(head[1]))%31 == 0
+}
+
+// isCorruptCompression checks if err means that the compressed data
+// was corrupt instead of that it couldn't be read.
+func isCorruptCompression(err error) bool {
+	switch err.(type) {
+	case flate.CorruptInputError, bzip2.StructuralError:
+		return true
+	}
+	switch err {
+	case gzip.ErrChecksum, gzip.ErrHeader, zlib.ErrChecksum,
+		zlib.ErrHeader, zlib.ErrDictionary, io.ErrUnexpectedEOF:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package uniquefile_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/skillian/uniquefile"
+)
+
+// helloWorldBzip2 is "hello, world!\n" compressed with bzip2 -9.
+const helloWorldBzip2 = "425a68393141592653598979e5e1000003518000106004064490802000220343208069a689a049c48376778bb9229c284844bcf2f080"
+
+func TestDecompressedIndicator(t *testing.T) {
+	const payload = "hello, world!\n"
+	sum := sha256.Sum256([]byte(payload))
+	var gz, zl bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	zw := zlib.NewWriter(&zl)
+	for _, w := range []io.WriteCloser{gw, zw} {
+		if _, err := io.WriteString(w, payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bz, err := hex.DecodeString(helloWorldBzip2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := gz.Bytes()[:gz.Len()-6]
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		limit  int64
+		expect []byte
+	}{
+		{"gzip", gz.Bytes(), 1 << 20, sum[:]},
+		{"bzip2", bz, 1 << 20, sum[:]},
+		{"zlib", zl.Bytes(), 1 << 20, sum[:]},
+		{"exactLimit", gz.Bytes(), int64(len(payload)), sum[:]},
+		{"overLimit", gz.Bytes(), int64(len(payload)) - 1, nil},
+		{"truncated", truncated, 1 << 20, nil},
+		{"uncompressed", []byte(payload), 1 << 20, nil},
+		{"empty", nil, 1 << 20, nil},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ir, err := uniquefile.NewDecompressedIndicator("sha256", tc.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ind := uniquefile.NewIndication()
+			defer uniquefile.PutIndication(&ind)
+			r := iotest.OneByteReader(bytes.NewReader(tc.data))
+			if err := ir.Indicate(context.Background(), r, ind); err != nil {
+				t.Fatal(err)
+			}
+			lookup, err := ind.Lookup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.expect == nil {
+				if len(lookup) != 0 {
+					t.Fatalf("expected nothing, got %v", lookup)
+				}
+				return
+			}
+			if !bytes.Equal(lookup["decomp.sha256"], tc.expect) {
+				t.Fatalf(
+					"expected %x, got %v",
+					tc.expect, lookup,
+				)
+			}
+		})
+	}
+}
+
+func TestDecompressedIndicatorSpec(t *testing.T) {
+	spec, err := uniquefile.ParseIndicatorSpec("decompressed:algo=xxh64,limit=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ir, err := uniquefile.NewIndicator(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	io.WriteString(gw, strings.Repeat("x", 1<<10))
+	gw.Close()
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	if err := ir.Indicate(context.Background(), &gz, ind); err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := ind.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := lookup["decomp.xxh64"]; !ok || len(v) != 8 {
+		t.Fatalf("expected an xxh64 hash, got %v", lookup)
+	}
+	for _, s := range []string{"decompressed:limit=0", "decompressed:algo=nope"} {
+		spec, err := uniquefile.ParseIndicatorSpec(s)
+		if err == nil {
+			_, err = uniquefile.NewIndicator(spec)
+		}
+		if err == nil {
+			t.Fatalf("expected error from %q", s)
+		}
+	}
+}
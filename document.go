@@ -0,0 +1,836 @@
+package uniquefile
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"hash"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skillian/errors"
+)
+
+const (
+	documentTextIndicatorKey = "doctext"
+
+	pdfMIMEType  = "application/pdf"
+	docxMIMEType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	xlsxMIMEType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	pptxMIMEType = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+
+	// pdfStreamLimit is the limit on the size of a decompressed PDF
+	// content stream.
+	pdfStreamLimit = 64 << 20
+
+	// defaultDocumentLimit is the default limit on the size of a
+	// document that is read into memory.
+	defaultDocumentLimit = 256 << 20
+)
+
+// DocumentTextIndicator hashes the text of PDF and Office Open XML
+// documents with SHA-256 under the "doctext" key (see
+// NewDocumentTextIndicator).
+var DocumentTextIndicator interface {
+	Indicator
+	IndicatorCmper
+} = documentTextIndicator{
+	key:    documentTextIndicatorKey,
+	hasher: hashers[sha256Key],
+	limit:  defaultDocumentLimit,
+}
+
+// NewDocumentTextIndicator creates an Indicator that extracts the text
+// of PDF documents and of Word, Excel and PowerPoint documents in the
+// Office Open XML formats (docx, xlsx and pptx) and hashes it with the
+// given algorithm.  Runs of whitespace in the text are replaced with a
+// single space, so documents that were exported or saved again with
+// different timestamps, object numbers, compression or line breaks
+// but with the same text have the same hash.  The hash is written
+// under "doctext" if the algorithm is sha256 or under "doctext."
+// followed by the algorithm otherwise (e.g. "doctext.xxh64").  The
+// text comes from:
+//
+//	PDF:	The strings shown by the text operators in the content
+//		streams of the pages, in page order, and of the forms
+//		that they draw.  The pages are found through the
+//		newest cross-reference section, so objects that an
+//		incremental update replaced are not read.  Only
+//		uncompressed and FlateDecode streams are read and
+//		encrypted documents are skipped.
+//	docx:	The body, headers, footers, footnotes and endnotes.
+//	xlsx:	The values of the cells in each worksheet with shared
+//		strings looked up by their index.
+//	pptx:	The slides.
+//
+// If the data isn't a document in one of those formats, if it is
+// corrupt, or if it has no text, nothing is written.  PDF documents
+// are read into memory and so are Office Open XML documents if r is
+// not an io.ReaderAt and io.Seeker; nothing is written for those
+// larger than 256MiB.
+func NewDocumentTextIndicator(algo string) (interface {
+	Indicator
+	IndicatorCmper
+}, error) {
+	ir, err := newDocumentTextIndicator(algo, defaultDocumentLimit)
+	if err != nil {
+		return nil, err
+	}
+	return ir, nil
+}
+
+func newDocumentTextIndicatorFromSpec(spec IndicatorSpec) (Indicator, error) {
+	if err := spec.CheckParams("algo", "limit"); err != nil {
+		return nil, err
+	}
+	algo, _, err := spec.Hash("algo", sha256Key)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := spec.Size("limit", defaultDocumentLimit)
+	if err != nil {
+		return nil, err
+	}
+	ir, err := newDocumentTextIndicator(algo, limit)
+	if err != nil {
+		return nil, err
+	}
+	return ir, nil
+}
+
+func newDocumentTextIndicator(algo string, limit int64) (documentTextIndicator, error) {
+	if limit <= 0 {
+		return documentTextIndicator{}, errors.Errorf(
+			"document size limit must be positive, not %d",
+			limit,
+		)
+	}
+	hasher, ok := hashers[algo]
+	if !ok {
+		return documentTextIndicator{}, errors.Errorf(
+			"unknown hash algorithm: %q", algo,
+		)
+	}
+	key := documentTextIndicatorKey
+	if algo != sha256Key {
+		key += "." + algo
+	}
+	return documentTextIndicator{key: key, hasher: hasher, limit: limit}, nil
+}
+
+type documentTextIndicator struct {
+	key    string
+	hasher func() hash.Hash
+
+	// limit is the limit on the size of a document that is read
+	// into memory.
+	limit int64
+}
+
+// errDocumentFormat means that a document is corrupt.
+var errDocumentFormat = errors.New("invalid document")
+
+func (ir documentTextIndicator) Keys() []Bytes { return []Bytes{Bytes(ir.key)} }
+
+// Cmp compares the hashes byte-by-byte.
+func (ir documentTextIndicator) Cmp(ctx context.Context, key, a, b []byte) (int, error) {
+	if string(key) != ir.key {
+		return 0, ErrCannotCmp
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return bytes.Compare(a, b), nil
+}
+
+func (ir documentTextIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	br := bufio.NewReaderSize(readerContext{ctx, r}, mimeTypeSniffLen)
+	head, err := br.Peek(mimeTypeSniffLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	h := ir.hasher()
+	dt := documentText{w: h}
+	switch mimeType := DetectContentType(head); mimeType {
+	case pdfMIMEType:
+		err = pdfText(ctx, &dt, br, ir.limit)
+	case docxMIMEType, xlsxMIMEType, pptxMIMEType:
+		var zr *zip.Reader
+		if zr, err = newZIPReader(ctx, r, br, ir.limit); zr == nil || err != nil {
+			return err
+		}
+		err = ooxmlText(ctx, &dt, zr, mimeType)
+	default:
+		return nil
+	}
+	if err != nil {
+		if isCorruptDocument(err) {
+			return nil
+		}
+		return err
+	}
+	if dt.n == 0 {
+		return nil
+	}
+	var buf [64]byte
+	ind.Write([]byte(ir.key), h.Sum(buf[:0]))
+	return nil
+}
+
+// isCorruptDocument checks if err means that a document was corrupt
+// instead of that it couldn't be read.
+func isCorruptDocument(err error) bool {
+	if _, ok := err.(*xml.SyntaxError); ok {
+		return true
+	}
+	switch err {
+	case errDocumentFormat, zip.ErrFormat, zip.ErrChecksum,
+		zip.ErrAlgorithm:
+		return true
+	}
+	return isCorruptCompression(err)
+}
+
+// documentText writes text into w with its runs of whitespace replaced
+// by a single space and without leading or trailing whitespace.
+type documentText struct {
+	w io.Writer
+
+	// n is the number of bytes written into w.
+	n int
+
+	// space is set when whitespace was written after the last
+	// character.
+	space bool
+
+	out []byte
+}
+
+func (dt *documentText) Write(p []byte) (int, error) {
+	dt.out = dt.out[:0]
+	for _, c := range p {
+		switch c {
+		case ' ', '\t', '\n', '\r', '\f', '\v':
+			dt.space = true
+			continue
+		}
+		if dt.space && dt.n+len(dt.out) > 0 {
+			dt.out = append(dt.out, ' ')
+		}
+		dt.space = false
+		dt.out = append(dt.out, c)
+	}
+	n, err := dt.w.Write(dt.out)
+	dt.n += n
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Space separates the text written before from the text written after
+// as if whitespace was written.
+func (dt *documentText) Space() { dt.space = true }
+
+// pdfText writes the text of the pages of a PDF document into dt.  If
+// the document is larger than limit, nothing is written.
+func pdfText(ctx context.Context, dt *documentText, r io.Reader, limit int64) error {
+	var buf bytes.Buffer
+	n, err := copyContext(ctx, &buf, io.LimitReader(r, limit+1), nil)
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return nil
+	}
+	doc, err := newPDFDocument(ctx, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if doc.trailer["Encrypt"] != nil {
+		return nil
+	}
+	root, err := doc.resolveDict(doc.trailer["Root"])
+	if err != nil {
+		return err
+	}
+	pt := pdfPageText{doc: doc, dt: dt, visited: make(map[int]bool)}
+	return pt.pages(root["Pages"], nil, 0)
+}
+
+// pdfPageText writes the text of the pages of a PDF document.
+type pdfPageText struct {
+	doc *pdfDocument
+	dt  *documentText
+
+	// visited has the object numbers of the page tree nodes and of
+	// the forms being drawn to catch cycles.
+	visited map[int]bool
+}
+
+// pages writes the text of the pages under a node of the page tree.
+// The resources are inherited from the node's ancestors.
+func (pt pdfPageText) pages(node, resources interface{}, depth int) error {
+	if depth > pdfMaxDepth {
+		return errDocumentFormat
+	}
+	if ref, ok := node.(pdfRef); ok {
+		if pt.visited[ref.num] {
+			return errDocumentFormat
+		}
+		pt.visited[ref.num] = true
+	}
+	dict, err := pt.doc.resolveDict(node)
+	if err != nil {
+		return err
+	}
+	if dict == nil {
+		return errDocumentFormat
+	}
+	if res, ok := dict["Resources"]; ok {
+		resources = res
+	}
+	kids, err := pt.doc.resolve(dict["Kids"])
+	if err != nil {
+		return err
+	}
+	if kids, ok := kids.(pdfArray); ok {
+		for _, kid := range kids {
+			if err := pt.pages(kid, resources, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// A page's content can be split into an array of streams that
+	// are read as if they were one.
+	contents, err := pt.doc.resolve(dict["Contents"])
+	if err != nil {
+		return err
+	}
+	streams, ok := contents.(pdfArray)
+	if !ok {
+		streams = pdfArray{contents}
+	}
+	var content []byte
+	for _, v := range streams {
+		v, err := pt.doc.resolve(v)
+		if err != nil {
+			return err
+		}
+		stm, ok := v.(*pdfStream)
+		if !ok {
+			continue
+		}
+		data, err := pt.doc.decodeStream(stm)
+		if err != nil {
+			return err
+		}
+		content = append(append(content, data...), '\n')
+	}
+	if err := pt.content(content, resources, depth); err != nil {
+		return err
+	}
+	pt.dt.Space()
+	return nil
+}
+
+// content writes the text of a page's or a form's content stream and
+// of the forms that it draws with the Do operator.
+func (pt pdfPageText) content(content []byte, resources interface{}, depth int) error {
+	return pdfContentText(pt.dt, content, func(name pdfName) error {
+		res, err := pt.doc.resolveDict(resources)
+		if err != nil {
+			return err
+		}
+		xobjects, err := pt.doc.resolveDict(res["XObject"])
+		if err != nil {
+			return err
+		}
+		ref := xobjects[name]
+		if ref, ok := ref.(pdfRef); ok {
+			if pt.visited[ref.num] {
+				return errDocumentFormat
+			}
+			pt.visited[ref.num] = true
+			defer delete(pt.visited, ref.num)
+		}
+		v, err := pt.doc.resolve(ref)
+		if err != nil {
+			return err
+		}
+		stm, ok := v.(*pdfStream)
+		if !ok || stm.dict["Subtype"] != pdfName("Form") || depth >= pdfMaxDepth {
+			return nil
+		}
+		data, err := pt.doc.decodeStream(stm)
+		if err != nil {
+			return err
+		}
+		formResources, ok := stm.dict["Resources"]
+		if !ok {
+			formResources = resources
+		}
+		return pt.content(data, formResources, depth+1)
+	})
+}
+
+// pdfContentText writes the strings that are shown by the text
+// operators in a PDF content stream into dt.  The Do operator calls do
+// with the name of the XObject to draw.
+func pdfContentText(dt *documentText, content []byte, do func(name pdfName) error) error {
+	var strs [][]byte
+	var name pdfName
+	text := false
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case isPDFSpace(c), c == '[', c == ']', c == '{', c == '}', c == '>':
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			s, n := pdfLiteralString(content[i:])
+			strs = append(strs, s)
+			i += n
+		case c == '<':
+			if i+1 < len(content) && content[i+1] == '<' {
+				i += 2
+				continue
+			}
+			s, n := pdfHexString(content[i:])
+			strs = append(strs, s)
+			i += n
+		case c == '/':
+			l := pdfLexer{data: content, pos: i}
+			name = l.readName()
+			i = l.pos
+		default:
+			j := i + 1
+			for j < len(content) && isPDFRegular(content[j]) {
+				j++
+			}
+			op := string(content[i:j])
+			i = j
+			if isPDFNumber(op) {
+				continue
+			}
+			switch op {
+			case "BT":
+				text = true
+			case "ET":
+				text = false
+				dt.Space()
+			case "Td", "TD", "Tm", "T*":
+				dt.Space()
+			case "Tj", "TJ", "'", "\"":
+				if !text {
+					break
+				}
+				if op == "'" || op == "\"" {
+					dt.Space()
+				}
+				for _, s := range strs {
+					if _, err := dt.Write(s); err != nil {
+						return err
+					}
+				}
+			case "Do":
+				if err := do(name); err != nil {
+					return err
+				}
+			case "ID":
+				// inline image data ends at an EI operator
+				k := bytes.Index(content[i:], []byte("EI"))
+				for k != -1 && i+k+2 < len(content) && !isPDFSpace(content[i+k+2]) {
+					next := bytes.Index(content[i+k+2:], []byte("EI"))
+					if next == -1 {
+						k = -1
+						break
+					}
+					k += 2 + next
+				}
+				if k == -1 {
+					return nil
+				}
+				i += k + 2
+			}
+			strs = strs[:0]
+			name = ""
+		}
+	}
+	return nil
+}
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isPDFRegular(c byte) bool {
+	if isPDFSpace(c) {
+		return false
+	}
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return false
+	}
+	return true
+}
+
+func isPDFNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// pdfLiteralString parses a literal string in parentheses from the
+// beginning of bs and returns its contents and how many bytes of bs
+// it took up.
+func pdfLiteralString(bs []byte) (s []byte, n int) {
+	depth := 0
+	for n = 0; n < len(bs); n++ {
+		c := bs[n]
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return s, n + 1
+			}
+		case '\r':
+			// all ends of lines are read as LF
+			if n+1 < len(bs) && bs[n+1] == '\n' {
+				n++
+			}
+			c = '\n'
+		case '\\':
+			n++
+			if n == len(bs) {
+				return s, n
+			}
+			c = bs[n]
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if n+1 < len(bs) && bs[n+1] == '\n' {
+					n++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c < '0' || c > '7' {
+					break
+				}
+				var o byte
+				for k := 0; k < 3 && n < len(bs) && bs[n] >= '0' && bs[n] <= '7'; k++ {
+					o = o<<3 | (bs[n] - '0')
+					n++
+				}
+				n--
+				c = o
+			}
+		}
+		s = append(s, c)
+	}
+	return s, n
+}
+
+// pdfHexString parses a hexadecimal string in angle brackets from the
+// beginning of bs and returns its contents and how many bytes of bs it
+// took up.
+func pdfHexString(bs []byte) (s []byte, n int) {
+	var b byte
+	odd := false
+	for n = 1; n < len(bs) && bs[n] != '>'; n++ {
+		var d byte
+		switch c := bs[n]; {
+		case c >= '0' && c <= '9':
+			d = c - '0'
+		case c >= 'a' && c <= 'f':
+			d = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			d = c - 'A' + 10
+		default:
+			continue
+		}
+		if odd {
+			s = append(s, b<<4|d)
+		} else {
+			b = d
+		}
+		odd = !odd
+	}
+	if odd {
+		// a missing last digit is 0
+		s = append(s, b<<4)
+	}
+	if n < len(bs) {
+		n++
+	}
+	return s, n
+}
+
+func ooxmlText(ctx context.Context, dt *documentText, zr *zip.Reader, mimeType string) error {
+	var prefix string
+	var isPart func(name string) bool
+	switch mimeType {
+	case docxMIMEType:
+		prefix = "word/"
+		isPart = func(name string) bool {
+			return name == "document" || name == "footnotes" ||
+				name == "endnotes" ||
+				isNumberedPart(name, "header") ||
+				isNumberedPart(name, "footer")
+		}
+	case xlsxMIMEType:
+		prefix = "xl/worksheets/"
+		isPart = func(name string) bool {
+			return isNumberedPart(name, "sheet")
+		}
+	case pptxMIMEType:
+		prefix = "ppt/slides/"
+		isPart = func(name string) bool {
+			return isNumberedPart(name, "slide")
+		}
+	}
+	var sharedStrings []string
+	var parts []*zip.File
+	for _, f := range zr.File {
+		if mimeType == xlsxMIMEType && f.Name == "xl/sharedStrings.xml" {
+			var err error
+			if sharedStrings, err = xlsxSharedStrings(ctx, f); err != nil {
+				return err
+			}
+			continue
+		}
+		name := strings.TrimPrefix(f.Name, prefix)
+		if len(name) == len(f.Name) || !strings.HasSuffix(name, ".xml") {
+			continue
+		}
+		if isPart(strings.TrimSuffix(name, ".xml")) {
+			parts = append(parts, f)
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return partNameLess(parts[i].Name, parts[j].Name)
+	})
+	for _, f := range parts {
+		err := readZIPXML(ctx, f, func(d *xml.Decoder) error {
+			if mimeType == xlsxMIMEType {
+				return xlsxSheetText(dt, d, sharedStrings)
+			}
+			return ooxmlPartText(dt, d)
+		})
+		if err != nil {
+			return err
+		}
+		dt.Space()
+	}
+	return nil
+}
+
+// isNumberedPart checks if name is base followed by a number (e.g.
+// "slide12").
+func isNumberedPart(name, base string) bool {
+	if !strings.HasPrefix(name, base) || len(name) == len(base) {
+		return false
+	}
+	for _, c := range name[len(base):] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// partNameLess compares part names so that their numbers are in order
+// (e.g. "slide2.xml" before "slide10.xml").
+func partNameLess(a, b string) bool {
+	for a != "" && b != "" {
+		i := strings.IndexAny(a, "0123456789")
+		j := strings.IndexAny(b, "0123456789")
+		if i == -1 || j == -1 || a[:i] != b[:j] {
+			break
+		}
+		a, b = a[i:], b[j:]
+		i = strings.IndexFunc(a, func(r rune) bool { return r < '0' || r > '9' })
+		j = strings.IndexFunc(b, func(r rune) bool { return r < '0' || r > '9' })
+		if i == -1 {
+			i = len(a)
+		}
+		if j == -1 {
+			j = len(b)
+		}
+		na, nb := strings.TrimLeft(a[:i], "0"), strings.TrimLeft(b[:j], "0")
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		}
+		if na != nb {
+			return na < nb
+		}
+		a, b = a[i:], b[j:]
+	}
+	return a < b
+}
+
+func readZIPXML(ctx context.Context, f *zip.File, fn func(d *xml.Decoder) error) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	d := xml.NewDecoder(readerContext{ctx, rc})
+	err = fn(d)
+	if closeErr := rc.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ooxmlPartText writes the text runs of a WordprocessingML or
+// DrawingML part into dt.
+func ooxmlPartText(dt *documentText, d *xml.Decoder) error {
+	text := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			switch tok.Name.Local {
+			case "t":
+				text++
+			case "tab", "br", "cr":
+				dt.Space()
+			}
+		case xml.EndElement:
+			switch tok.Name.Local {
+			case "t":
+				text--
+			case "p":
+				dt.Space()
+			}
+		case xml.CharData:
+			if text > 0 {
+				if _, err = dt.Write(tok); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// xlsxSharedStrings reads the shared strings table of a workbook.
+func xlsxSharedStrings(ctx context.Context, f *zip.File) (strs []string, err error) {
+	err = readZIPXML(ctx, f, func(d *xml.Decoder) error {
+		var sb strings.Builder
+		text := 0
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			switch tok := tok.(type) {
+			case xml.StartElement:
+				switch tok.Name.Local {
+				case "si":
+					sb.Reset()
+				case "t":
+					text++
+				}
+			case xml.EndElement:
+				switch tok.Name.Local {
+				case "si":
+					strs = append(strs, sb.String())
+				case "t":
+					text--
+				}
+			case xml.CharData:
+				if text > 0 {
+					sb.Write(tok)
+				}
+			}
+		}
+	})
+	return
+}
+
+// xlsxSheetText writes the values of a worksheet's cells into dt.
+func xlsxSheetText(dt *documentText, d *xml.Decoder, sharedStrings []string) error {
+	var typ string
+	var value []byte
+	text := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			switch tok.Name.Local {
+			case "c":
+				typ, value = "", value[:0]
+				for _, attr := range tok.Attr {
+					if attr.Name.Local == "t" {
+						typ = attr.Value
+					}
+				}
+			case "v", "t":
+				text++
+			}
+		case xml.EndElement:
+			switch tok.Name.Local {
+			case "v", "t":
+				text--
+			case "c":
+				if typ == "s" {
+					i, err := strconv.Atoi(strings.TrimSpace(string(value)))
+					if err != nil || i < 0 || i >= len(sharedStrings) {
+						return errDocumentFormat
+					}
+					value = append(value[:0], sharedStrings[i]...)
+				}
+				dt.Space()
+				if _, err = dt.Write(value); err != nil {
+					return err
+				}
+			}
+		case xml.CharData:
+			if text > 0 {
+				value = append(value, tok...)
+			}
+		}
+	}
+}
@@ -0,0 +1,617 @@
+package uniquefile_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/skillian/uniquefile"
+)
+
+// pdfOptions changes how pdfDocument writes a PDF.
+type pdfOptions struct {
+	flate bool
+
+	// firstObj is the number of the first object (default: 1).
+	firstObj int
+
+	// date is the document's creation date (default: 20210304).
+	date string
+
+	// reverse writes the objects in reverse order.
+	reverse bool
+
+	// xrefStream puts the dictionaries into an object stream and
+	// writes a cross-reference stream instead of a table.
+	xrefStream bool
+
+	// forms are the contents of form XObjects named Fm1, Fm2, etc.
+	// that the pages can draw.
+	forms []string
+
+	// trailer has more entries for the trailer.
+	trailer string
+}
+
+// pdfWriter writes the objects and cross-reference sections of a PDF.
+type pdfWriter struct {
+	t     *testing.T
+	buf   bytes.Buffer
+	flate bool
+
+	// offsets has the objects written since the last
+	// cross-reference section.
+	offsets map[int]int
+
+	// objStm has the dictionaries that go into an object stream.
+	objStm []pdfTestObject
+	size   int
+}
+
+type pdfTestObject struct {
+	num  int
+	body string
+}
+
+func newPDFWriter(t *testing.T, data []byte, flate bool) *pdfWriter {
+	w := &pdfWriter{t: t, flate: flate, offsets: make(map[int]int)}
+	if data == nil {
+		data = []byte("%PDF-1.5\n%\xe2\xe3\xcf\xd3\n")
+	}
+	w.buf.Write(data)
+	return w
+}
+
+func (w *pdfWriter) deflate(data string) string {
+	var zb bytes.Buffer
+	zw := zlib.NewWriter(&zb)
+	if _, err := zw.Write([]byte(data)); err != nil {
+		w.t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		w.t.Fatal(err)
+	}
+	return zb.String()
+}
+
+func (w *pdfWriter) object(num int, body string) {
+	w.offsets[num] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", num, body)
+	if num >= w.size {
+		w.size = num + 1
+	}
+}
+
+func (w *pdfWriter) stream(num int, dict, data string) {
+	if w.flate {
+		data = w.deflate(data)
+		dict += " /Filter /FlateDecode"
+	}
+	w.object(num, fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data))
+}
+
+// xref writes a cross-reference table of the objects written since
+// the last one and the trailer.  prev is the offset of the previous
+// table or -1 if there isn't one.
+func (w *pdfWriter) xref(trailer string, prev int) {
+	start := w.buf.Len()
+	w.buf.WriteString("xref\n")
+	if prev < 0 {
+		w.buf.WriteString("0 1\n0000000000 65535 f \n")
+	} else {
+		trailer += fmt.Sprintf(" /Prev %d", prev)
+	}
+	nums := make([]int, 0, len(w.offsets))
+	for num := range w.offsets {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		fmt.Fprintf(&w.buf, "%d 1\n%010d 00000 n \n", num, w.offsets[num])
+	}
+	fmt.Fprintf(
+		&w.buf, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n",
+		w.size, trailer, start,
+	)
+	w.offsets = make(map[int]int)
+}
+
+// xrefStream writes the dictionaries into object stream stmNum and
+// a cross-reference stream numbered xrefNum whose rows are compressed
+// with the PNG up predictor.
+func (w *pdfWriter) xrefStream(stmNum, xrefNum int, trailer string) {
+	var head, objs strings.Builder
+	for _, o := range w.objStm {
+		fmt.Fprintf(&head, "%d %d ", o.num, objs.Len())
+		objs.WriteString(o.body + "\n")
+	}
+	w.stream(
+		stmNum,
+		fmt.Sprintf("/Type /ObjStm /N %d /First %d", len(w.objStm), head.Len()),
+		head.String()+objs.String(),
+	)
+	w.offsets[xrefNum] = w.buf.Len()
+	if xrefNum >= w.size {
+		w.size = xrefNum + 1
+	}
+	rows := make([][7]byte, w.size)
+	for num, off := range w.offsets {
+		rows[num] = [7]byte{1, byte(off >> 24), byte(off >> 16), byte(off >> 8), byte(off)}
+	}
+	for i, o := range w.objStm {
+		rows[o.num] = [7]byte{2, byte(stmNum >> 24), byte(stmNum >> 16), byte(stmNum >> 8), byte(stmNum), byte(i >> 8), byte(i)}
+	}
+	var data []byte
+	var prev [7]byte
+	for _, row := range rows {
+		data = append(data, 2)
+		for i := range row {
+			data = append(data, row[i]-prev[i])
+		}
+		prev = row
+	}
+	z := w.deflate(string(data))
+	fmt.Fprintf(
+		&w.buf,
+		"%d 0 obj\n<< /Type /XRef /Size %d /W [1 4 2] /Filter /FlateDecode "+
+			"/DecodeParms << /Predictor 12 /Columns 7 >> /Length %d %s >>\n"+
+			"stream\n%s\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n",
+		xrefNum, w.size, len(z), trailer, z, w.offsets[xrefNum],
+	)
+}
+
+// pdfDocument creates a PDF with a page for each content stream.  Its
+// objects are numbered from opts.firstObj: the document information
+// dictionary, the catalog, the page tree, a metadata stream, an image,
+// the forms and then each page and its content stream.
+func pdfDocument(t *testing.T, opts pdfOptions, contents ...string) []byte {
+	if opts.firstObj == 0 {
+		opts.firstObj = 1
+	}
+	if opts.date == "" {
+		opts.date = "20210304"
+	}
+	w := newPDFWriter(t, nil, opts.flate)
+	var objs []func()
+	dict := func(num int, body string) {
+		objs = append(objs, func() {
+			if opts.xrefStream {
+				w.objStm = append(w.objStm, pdfTestObject{num, body})
+				return
+			}
+			w.object(num, body)
+		})
+	}
+	stream := func(num int, dict, data string) {
+		objs = append(objs, func() { w.stream(num, dict, data) })
+	}
+	info, catalog, pages, meta, img := opts.firstObj, opts.firstObj+1, opts.firstObj+2, opts.firstObj+3, opts.firstObj+4
+	page := img + 1 + len(opts.forms)
+	dict(info, fmt.Sprintf("<< /CreationDate (D:%s) /Producer (test) >>", opts.date))
+	dict(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R /Metadata %d 0 R >>", pages, meta))
+	var kids, xobjects strings.Builder
+	fmt.Fprintf(&xobjects, "/Im1 %d 0 R", img)
+	for i := range opts.forms {
+		fmt.Fprintf(&xobjects, " /Fm%d %d 0 R", i+1, img+1+i)
+	}
+	for i := range contents {
+		fmt.Fprintf(&kids, "%d 0 R ", page+2*i)
+	}
+	dict(pages, fmt.Sprintf(
+		"<< /Type /Pages /Kids [%s] /Count %d /Resources << /XObject << %s >> >> >>",
+		kids.String(), len(contents), xobjects.String(),
+	))
+	stream(meta, "/Type /Metadata /Subtype /XML", "<x:xmpmeta>BT ("+opts.date+") Tj ET</x:xmpmeta>")
+	stream(img, "/Type /XObject /Subtype /Image /Width 1 /Height 1", "BT (image) Tj ET")
+	for i, form := range opts.forms {
+		stream(img+1+i, "/Type /XObject /Subtype /Form /BBox [0 0 612 792]", form)
+	}
+	for i, c := range contents {
+		dict(page+2*i, fmt.Sprintf(
+			"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 612 792] /Contents %d 0 R >>",
+			pages, page+2*i+1,
+		))
+		stream(page+2*i+1, "", c)
+	}
+	if opts.reverse {
+		for i, j := 0, len(objs)-1; i < j; i, j = i+1, j-1 {
+			objs[i], objs[j] = objs[j], objs[i]
+		}
+	}
+	for _, obj := range objs {
+		obj()
+	}
+	trailer := fmt.Sprintf("/Root %d 0 R /Info %d 0 R %s", catalog, info, opts.trailer)
+	if opts.xrefStream {
+		w.xrefStream(page+2*len(contents), page+2*len(contents)+1, trailer)
+	} else {
+		w.xref(trailer, -1)
+	}
+	return w.buf.Bytes()
+}
+
+// pdfUpdate appends an incremental update to a document that
+// pdfDocument created with its first object numbered 1.  The update
+// replaces the dictionaries and the streams.
+func pdfUpdate(t *testing.T, data []byte, dicts, streams map[int]string) []byte {
+	i := bytes.LastIndex(data, []byte("startxref\n"))
+	var prev int
+	if _, err := fmt.Sscan(string(data[i+len("startxref\n"):]), &prev); err != nil {
+		t.Fatal(err)
+	}
+	w := newPDFWriter(t, data, true)
+	for num, body := range dicts {
+		w.object(num, body)
+	}
+	for num, content := range streams {
+		w.stream(num, "", content)
+	}
+	w.xref("/Root 2 0 R /Info 1 0 R", prev)
+	return w.buf.Bytes()
+}
+
+// ooxmlDocument creates an Office Open XML document from its parts and
+// a core properties part with the modification date.
+func ooxmlDocument(t *testing.T, date string, parts ...archiveFile) []byte {
+	files := append([]archiveFile{{
+		"docProps/core.xml",
+		"<cp:coreProperties><dcterms:modified>" + date +
+			"</dcterms:modified></cp:coreProperties>",
+	}}, parts...)
+	return zipArchive(t, files...)
+}
+
+func docxDocument(t *testing.T, date string, paragraphs ...[]string) []byte {
+	var sb strings.Builder
+	sb.WriteString(`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>`)
+	for _, p := range paragraphs {
+		sb.WriteString("<w:p>")
+		for _, r := range p {
+			fmt.Fprintf(&sb, `<w:r><w:t xml:space="preserve">%s</w:t></w:r>`, r)
+		}
+		sb.WriteString("</w:p>")
+	}
+	sb.WriteString("</w:body></w:document>")
+	return ooxmlDocument(t, date, archiveFile{"word/document.xml", sb.String()})
+}
+
+// xlsxDocument creates a workbook with a single sheet whose cells
+// have the values.  Strings are put into the shared strings table
+// sorted in order or in reverse.
+func xlsxDocument(t *testing.T, date string, reverse bool, values ...interface{}) []byte {
+	var strs []string
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	if reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(strs)))
+	} else {
+		sort.Strings(strs)
+	}
+	var sst, sheet strings.Builder
+	sst.WriteString("<sst>")
+	for _, s := range strs {
+		fmt.Fprintf(&sst, "<si><t>%s</t></si>", s)
+	}
+	sst.WriteString("</sst>")
+	sheet.WriteString("<worksheet><sheetData><row>")
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			for i, s := range strs {
+				if s == v {
+					fmt.Fprintf(&sheet, `<c t="s"><v>%d</v></c>`, i)
+				}
+			}
+		default:
+			fmt.Fprintf(&sheet, "<c><v>%v</v></c>", v)
+		}
+	}
+	sheet.WriteString("</row></sheetData></worksheet>")
+	return ooxmlDocument(
+		t, date,
+		archiveFile{"xl/workbook.xml", "<workbook/>"},
+		archiveFile{"xl/sharedStrings.xml", sst.String()},
+		archiveFile{"xl/worksheets/sheet1.xml", sheet.String()},
+	)
+}
+
+func pptxSlide(name, text string) archiveFile {
+	return archiveFile{
+		"ppt/slides/" + name + ".xml",
+		"<p:sld><p:txBody><a:p><a:r><a:t>" + text +
+			"</a:t></a:r></a:p></p:txBody></p:sld>",
+	}
+}
+
+func indicateDocumentText(t *testing.T, data []byte) []byte {
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	r := iotest.OneByteReader(bytes.NewReader(data))
+	if err := uniquefile.DocumentTextIndicator.Indicate(context.Background(), r, ind); err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := ind.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lookup) > 1 {
+		t.Fatalf("expected at most one key, got %v", lookup)
+	}
+	if h, ok := lookup["doctext"]; ok {
+		return append([]byte(nil), h...)
+	}
+	return nil
+}
+
+func TestDocumentTextIndicator(t *testing.T) {
+	const (
+		date1 = "2021-03-04T05:06:07Z"
+		date2 = "2022-08-09T10:11:12Z"
+	)
+	helloPage := "BT /F1 12 Tf 72 720 Td (Hello, world!) Tj T* (Second \\(line\\)) Tj ET"
+	changedPage := strings.Replace(helloPage, "world", "there", 1)
+	page2 := "BT (Page 2) Tj ET"
+	for _, tc := range []struct {
+		name  string
+		a, b  []byte
+		equal bool
+	}{
+		{
+			"pdfReexported",
+			pdfDocument(t, pdfOptions{}, helloPage),
+			pdfDocument(t, pdfOptions{flate: true, firstObj: 7, date: "20220809"}, helloPage),
+			true,
+		},
+		{
+			"pdfKerning",
+			pdfDocument(t, pdfOptions{flate: true}, helloPage),
+			pdfDocument(
+				t, pdfOptions{flate: true},
+				"BT /F1 12 Tf 72 720 Td [(Hel) -15 (lo,)] TJ ( world!) Tj\n"+
+					"0 -14 Td <5365636f6e64> Tj ( \\050line\\051) Tj ET",
+			),
+			true,
+		},
+		{
+			"pdfPages",
+			pdfDocument(t, pdfOptions{flate: true}, helloPage, page2),
+			pdfDocument(t, pdfOptions{flate: true}, helloPage),
+			false,
+		},
+		{
+			"pdfPageOrder",
+			pdfDocument(t, pdfOptions{flate: true}, helloPage, page2),
+			pdfDocument(t, pdfOptions{flate: true}, page2, helloPage),
+			false,
+		},
+		{
+			"pdfObjectOrder",
+			pdfDocument(t, pdfOptions{flate: true}, helloPage, page2),
+			pdfDocument(t, pdfOptions{flate: true, reverse: true}, helloPage, page2),
+			true,
+		},
+		{
+			"pdfXRefStream",
+			pdfDocument(t, pdfOptions{}, helloPage, page2),
+			pdfDocument(t, pdfOptions{flate: true, firstObj: 3, xrefStream: true}, helloPage, page2),
+			true,
+		},
+		{
+			"pdfForm",
+			pdfDocument(t, pdfOptions{flate: true}, helloPage),
+			pdfDocument(t, pdfOptions{flate: true, forms: []string{helloPage}}, "q /Im1 Do /Fm1 Do Q"),
+			true,
+		},
+		{
+			"pdfIncrementalUpdate",
+			pdfDocument(t, pdfOptions{flate: true}, helloPage),
+			pdfUpdate(
+				t, pdfDocument(t, pdfOptions{flate: true}, helloPage),
+				map[int]string{1: "<< /ModDate (D:20220809) /Producer (editor) >>"},
+				nil,
+			),
+			true,
+		},
+		{
+			"pdfIncrementalUpdateContent",
+			pdfDocument(t, pdfOptions{flate: true}, changedPage),
+			pdfUpdate(
+				t, pdfDocument(t, pdfOptions{flate: true}, helloPage),
+				nil, map[int]string{7: changedPage},
+			),
+			true,
+		},
+		{
+			"pdfChanged",
+			pdfDocument(t, pdfOptions{flate: true}, helloPage),
+			pdfDocument(t, pdfOptions{flate: true}, changedPage),
+			false,
+		},
+		{
+			"docxResaved",
+			docxDocument(t, date1, []string{"Hello, world!"}, []string{"Second paragraph"}),
+			docxDocument(t, date2, []string{"Hel", "lo, ", "world!"}, []string{"Second ", " paragraph "}),
+			true,
+		},
+		{
+			"docxChanged",
+			docxDocument(t, date1, []string{"Hello, world!"}),
+			docxDocument(t, date1, []string{"Hello, there!"}),
+			false,
+		},
+		{
+			"pdfAndDocx",
+			pdfDocument(t, pdfOptions{flate: true}, helloPage),
+			docxDocument(t, date1, []string{"Hello, world!"}, []string{"Second (line)"}),
+			true,
+		},
+		{
+			"xlsxSharedStringOrder",
+			xlsxDocument(t, date1, false, "name", "apple", 42),
+			xlsxDocument(t, date2, true, "name", "apple", 42),
+			true,
+		},
+		{
+			"xlsxChanged",
+			xlsxDocument(t, date1, false, "name", "apple", 42),
+			xlsxDocument(t, date1, false, "name", "apple", 43),
+			false,
+		},
+		{
+			"pptxSlideOrder",
+			ooxmlDocument(
+				t, date1,
+				pptxSlide("slide10", "three"),
+				pptxSlide("slide1", "one"),
+				pptxSlide("slide2", "two"),
+			),
+			ooxmlDocument(
+				t, date2,
+				pptxSlide("slide1", "one"),
+				pptxSlide("slide2", "two"),
+				pptxSlide("slide3", "three"),
+			),
+			true,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			a := indicateDocumentText(t, tc.a)
+			b := indicateDocumentText(t, tc.b)
+			if a == nil || b == nil {
+				t.Fatalf("expected hashes, got %x and %x", a, b)
+			}
+			if bytes.Equal(a, b) != tc.equal {
+				t.Fatalf(
+					"expected equal: %v, got %x and %x",
+					tc.equal, a, b,
+				)
+			}
+		})
+	}
+}
+
+func TestDocumentTextIndicatorNothing(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"text", []byte("Hello, world!\n")},
+		{"zip", zipArchive(t, archiveFile{"a.txt", "Hello, world!"})},
+		{"pdfNoText", pdfDocument(t, pdfOptions{flate: true}, "0 0 m 10 10 l S")},
+		{"pdfEncrypted", pdfDocument(t, pdfOptions{flate: true, trailer: "/Encrypt 99 0 R"}, "BT (Hello) Tj ET")},
+		{"pdfDeletedPages", pdfUpdate(
+			t, pdfDocument(t, pdfOptions{flate: true}, "BT (Hello) Tj ET"),
+			map[int]string{3: "<< /Type /Pages /Kids [] /Count 0 >>"}, nil,
+		)},
+		{"docxCorrupt", ooxmlDocument(
+			t, "2021-03-04T05:06:07Z",
+			archiveFile{"word/document.xml", "<w:document><w:t>Hello"},
+		)},
+		{"empty", nil},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if h := indicateDocumentText(t, tc.data); h != nil {
+				t.Fatalf("expected nothing, got %x", h)
+			}
+		})
+	}
+}
+
+func TestDocumentTextIndicatorLimit(t *testing.T) {
+	text := strings.Repeat("Hello, world! ", 100)
+	pdf := pdfDocument(t, pdfOptions{}, "BT ("+text+") Tj ET")
+	docx := docxDocument(t, "2021-03-04T05:06:07Z", []string{text})
+	for _, tc := range []struct {
+		name   string
+		r      io.Reader
+		limit  string
+		expect bool
+	}{
+		{"pdfOver", bytes.NewReader(pdf), "1k", false},
+		{"pdfAt", bytes.NewReader(pdf), strconv.Itoa(len(pdf)), true},
+		// Only documents that are read into memory are limited.
+		{"docxSeekable", bytes.NewReader(docx), "100", true},
+		{"docxOver", iotest.OneByteReader(bytes.NewReader(docx)), "100", false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := uniquefile.ParseIndicatorSpec("doctext:limit=" + tc.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ir, err := uniquefile.NewIndicator(spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ind := uniquefile.NewIndication()
+			defer uniquefile.PutIndication(&ind)
+			if err := ir.Indicate(context.Background(), tc.r, ind); err != nil {
+				t.Fatal(err)
+			}
+			lookup, err := ind.Lookup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := lookup["doctext"]; ok != tc.expect {
+				t.Fatalf("expected a hash: %v, got %v", tc.expect, lookup)
+			}
+		})
+	}
+	spec, err := uniquefile.ParseIndicatorSpec("doctext:limit=0")
+	if err == nil {
+		_, err = uniquefile.NewIndicator(spec)
+	}
+	if err == nil {
+		t.Fatal("expected error from a zero limit")
+	}
+}
+
+func TestDocumentTextIndicatorURIs(t *testing.T) {
+	ctx := context.Background()
+	repo := memRepo{}
+	docs := map[string][]byte{
+		"/report-1.docx": docxDocument(t, "2021-03-04T05:06:07Z", []string{"Quarterly report"}),
+		"/report-2.docx": docxDocument(t, "2022-08-09T10:11:12Z", []string{"Quarterly ", "report"}),
+		"/other.docx":    docxDocument(t, "2021-03-04T05:06:07Z", []string{"Annual report"}),
+	}
+	for p, data := range docs {
+		ind := uniquefile.NewIndication()
+		if err := uniquefile.DocumentTextIndicator.Indicate(ctx, bytes.NewReader(data), ind); err != nil {
+			t.Fatal(err)
+		}
+		u := uniquefile.URI{Scheme: uniquefile.FileScheme, Path: p}
+		if err := repo.SetIndications(ctx, u, ind); err != nil {
+			t.Fatal(err)
+		}
+		uniquefile.PutIndication(&ind)
+	}
+	query := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&query)
+	if err := uniquefile.DocumentTextIndicator.Indicate(ctx, bytes.NewReader(docs["/report-1.docx"]), query); err != nil {
+		t.Fatal(err)
+	}
+	uris, err := repo.URIs(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := make([]string, len(uris))
+	for i, u := range uris {
+		paths[i] = u.Path
+	}
+	sort.Strings(paths)
+	if len(paths) != 2 || paths[0] != "/report-1.docx" || paths[1] != "/report-2.docx" {
+		t.Fatalf("expected both reports, got %v", paths)
+	}
+}
@@ -0,0 +1,369 @@
+package uniquefile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	exifIndicatorName = "exif"
+
+	exifMakeKey        = "exif.make"
+	exifModelKey       = "exif.model"
+	exifDateTimeKey    = "exif.datetime"
+	exifWidthKey       = "exif.width"
+	exifHeightKey      = "exif.height"
+	exifOrientationKey = "exif.orientation"
+
+	// ExifDateTimeLayout is the layout of the capture time written
+	// by the ExifIndicator.  Like in EXIF itself, there is no time
+	// zone.
+	ExifDateTimeLayout = "2006-01-02T15:04:05"
+
+	exifRawDateTimeLayout = "2006:01:02 15:04:05"
+)
+
+// ExifIndicator reads the metadata of JPEG and TIFF photos (including
+// camera raw formats based on TIFF) and writes it under several keys:
+//
+//	exif.make:		The camera manufacturer.
+//	exif.model:		The camera model.
+//	exif.datetime:		When the photo was taken, formatted with
+//				ExifDateTimeLayout.
+//	exif.width:		The width and height of the image in
+//	exif.height:		pixels as big endian 64-bit integers.
+//	exif.orientation:	The EXIF orientation from 1 (upright) to 8
+//				as a big endian 64-bit integer.
+//
+// Keys are only written when the photo has them.  For JPEGs, the
+// dimensions are those of the image itself instead of what the EXIF
+// metadata says.  Photos with the same capture time and camera are
+// probably different exports of the same shot, even if their pixels
+// aren't the same anymore.
+//
+// TIFF metadata can be anywhere in the file, so if r is not an
+// io.ReaderAt and io.Seeker (like *os.File), a TIFF file is read into
+// memory first.
+var ExifIndicator interface {
+	Indicator
+	IndicatorCmper
+} = exifIndicator{}
+
+type exifIndicator struct{}
+
+var exifKeys = []Bytes{
+	exifMakeKey, exifModelKey, exifDateTimeKey,
+	exifWidthKey, exifHeightKey, exifOrientationKey,
+}
+
+func (exifIndicator) Keys() []Bytes { return exifKeys }
+
+// Cmp orders the values byte-by-byte, which orders the capture times
+// chronologically and the dimensions and orientations numerically.
+func (exifIndicator) Cmp(ctx context.Context, key, a, b []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	switch Bytes(key) {
+	case exifMakeKey, exifModelKey, exifDateTimeKey,
+		exifWidthKey, exifHeightKey, exifOrientationKey:
+	default:
+		return 0, ErrCannotCmp
+	}
+	return bytes.Compare(a, b), nil
+}
+
+func (exifIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	br := bufio.NewReader(readerContext{ctx, r})
+	head, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	var md exifMetadata
+	switch {
+	case len(head) >= 3 && head[0] == 0xff && head[1] == 0xd8 && head[2] == 0xff:
+		err = md.readJPEG(br)
+	case string(head) == "II*\x00" || string(head) == "MM\x00*":
+		err = md.readTIFFFile(ctx, r, br)
+	default:
+		return nil
+	}
+	if err != nil {
+		if err == errMediaFormat || err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		return err
+	}
+	md.write(ind)
+	return nil
+}
+
+// exifMetadata is what the ExifIndicator found.  Zero values weren't
+// found.
+type exifMetadata struct {
+	make, model   string
+	dateTime      time.Time
+	width, height uint64
+	orientation   uint64
+}
+
+func (md *exifMetadata) write(ind *Indication) {
+	writeString := func(key, s string) {
+		if s != "" {
+			ind.Write([]byte(key), []byte(s))
+		}
+	}
+	writeUint := func(key string, v uint64) {
+		if v != 0 {
+			var buf [8]byte
+			byteOrder.PutUint64(buf[:], v)
+			ind.Write([]byte(key), buf[:])
+		}
+	}
+	writeString(exifMakeKey, md.make)
+	writeString(exifModelKey, md.model)
+	if !md.dateTime.IsZero() {
+		writeString(exifDateTimeKey, md.dateTime.Format(ExifDateTimeLayout))
+	}
+	writeUint(exifWidthKey, md.width)
+	writeUint(exifHeightKey, md.height)
+	writeUint(exifOrientationKey, md.orientation)
+}
+
+// readJPEG reads the segments of a JPEG up to the image data.
+func (md *exifMetadata) readJPEG(br *bufio.Reader) error {
+	if _, err := br.Discard(2); err != nil {
+		return err
+	}
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c != 0xff {
+			return errMediaFormat
+		}
+		marker := byte(0xff)
+		for marker == 0xff {
+			if marker, err = br.ReadByte(); err != nil {
+				return err
+			}
+		}
+		switch {
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			continue
+		case marker == 0xd9 || marker == 0xda:
+			// EOI or SOS:  The metadata is before the image.
+			return nil
+		}
+		var length [2]byte
+		if _, err = io.ReadFull(br, length[:]); err != nil {
+			return err
+		}
+		size := int(length[0])<<8 | int(length[1])
+		if size < 2 {
+			return errMediaFormat
+		}
+		size -= 2
+		switch {
+		case marker == 0xe1:
+			seg := make([]byte, size)
+			if _, err = io.ReadFull(br, seg); err != nil {
+				return err
+			}
+			if tiff := bytes.TrimPrefix(seg, []byte("Exif\x00\x00")); len(tiff) < len(seg) {
+				// Errors from broken EXIF metadata in an
+				// otherwise good JPEG are ignored.
+				width, height := md.width, md.height
+				_ = md.readTIFF(bytes.NewReader(tiff), int64(len(tiff)))
+				if width != 0 {
+					md.width, md.height = width, height
+				}
+			}
+			continue
+		case marker >= 0xc0 && marker <= 0xcf &&
+			marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			// SOFn: precision, height, width
+			var sof [5]byte
+			if size < len(sof) {
+				return errMediaFormat
+			}
+			if _, err = io.ReadFull(br, sof[:]); err != nil {
+				return err
+			}
+			md.height = uint64(sof[1])<<8 | uint64(sof[2])
+			md.width = uint64(sof[3])<<8 | uint64(sof[4])
+			size -= len(sof)
+		}
+		if _, err = br.Discard(size); err != nil {
+			return err
+		}
+	}
+}
+
+func (md *exifMetadata) readTIFFFile(ctx context.Context, r io.Reader, br *bufio.Reader) error {
+	type readerAtSeeker interface {
+		io.ReaderAt
+		io.Seeker
+	}
+	if rs, ok := r.(readerAtSeeker); ok {
+		end, err := rs.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		return md.readTIFF(rs, end)
+	}
+	var buf bytes.Buffer
+	if _, err := copyContext(ctx, &buf, br, nil); err != nil {
+		return err
+	}
+	return md.readTIFF(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
+
+// TIFF tags that the ExifIndicator reads.
+const (
+	tiffImageWidth      = 0x0100
+	tiffImageLength     = 0x0101
+	tiffMake            = 0x010f
+	tiffModel           = 0x0110
+	tiffOrientation     = 0x0112
+	tiffExifIFD         = 0x8769
+	exifDateTimeOrig    = 0x9003
+	exifDateTimeDigit   = 0x9004
+	exifPixelXDimension = 0xa002
+	exifPixelYDimension = 0xa003
+)
+
+// TIFF field types
+const (
+	tiffASCII = 2
+	tiffShort = 3
+	tiffLong  = 4
+)
+
+// readTIFF reads the first IFD of TIFF data and its EXIF IFD.
+func (md *exifMetadata) readTIFF(ra io.ReaderAt, size int64) error {
+	var header [8]byte
+	if _, err := ra.ReadAt(header[:], 0); err != nil {
+		return err
+	}
+	var order binary.ByteOrder
+	switch string(header[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return errMediaFormat
+	}
+	t := tiffReader{ra: ra, size: size, order: order}
+	ifd0, err := t.readIFD(int64(order.Uint32(header[4:])))
+	if err != nil {
+		return err
+	}
+	md.make = t.ascii(ifd0[tiffMake])
+	md.model = t.ascii(ifd0[tiffModel])
+	md.orientation = t.uint(ifd0[tiffOrientation])
+	if o := md.orientation; o < 1 || o > 8 {
+		md.orientation = 0
+	}
+	md.width = t.uint(ifd0[tiffImageWidth])
+	md.height = t.uint(ifd0[tiffImageLength])
+	off, ok := ifd0[tiffExifIFD]
+	if !ok {
+		return nil
+	}
+	exif, err := t.readIFD(int64(t.uint(off)))
+	if err != nil {
+		return err
+	}
+	for _, tag := range []uint16{exifDateTimeOrig, exifDateTimeDigit} {
+		dt, err := time.Parse(exifRawDateTimeLayout, t.ascii(exif[tag]))
+		if err == nil {
+			md.dateTime = dt
+			break
+		}
+	}
+	if md.width == 0 || md.height == 0 {
+		md.width = t.uint(exif[exifPixelXDimension])
+		md.height = t.uint(exif[exifPixelYDimension])
+	}
+	return nil
+}
+
+type tiffReader struct {
+	ra    io.ReaderAt
+	size  int64
+	order binary.ByteOrder
+}
+
+// tiffEntry is the 12 byte entry of a field in an IFD.
+type tiffEntry [12]byte
+
+// readIFD reads the entries of the IFD at offset by their tags.
+func (t tiffReader) readIFD(offset int64) (map[uint16]tiffEntry, error) {
+	var count [2]byte
+	if offset < 8 || offset+2 > t.size {
+		return nil, errMediaFormat
+	}
+	if _, err := t.ra.ReadAt(count[:], offset); err != nil {
+		return nil, err
+	}
+	n := int64(t.order.Uint16(count[:]))
+	if offset+2+n*12 > t.size {
+		return nil, errMediaFormat
+	}
+	buf := make([]byte, n*12)
+	if _, err := t.ra.ReadAt(buf, offset+2); err != nil {
+		return nil, err
+	}
+	entries := make(map[uint16]tiffEntry, n)
+	for i := 0; i < len(buf); i += 12 {
+		var e tiffEntry
+		copy(e[:], buf[i:])
+		entries[t.order.Uint16(e[:2])] = e
+	}
+	return entries, nil
+}
+
+// uint returns the first value of a SHORT or LONG field or 0.
+func (t tiffReader) uint(e tiffEntry) uint64 {
+	switch t.order.Uint16(e[2:4]) {
+	case tiffShort:
+		return uint64(t.order.Uint16(e[8:10]))
+	case tiffLong:
+		return uint64(t.order.Uint32(e[8:12]))
+	}
+	return 0
+}
+
+// ascii returns the value of an ASCII field without its NUL
+// terminator or padding or "" if it's missing or invalid.
+func (t tiffReader) ascii(e tiffEntry) string {
+	if t.order.Uint16(e[2:4]) != tiffASCII {
+		return ""
+	}
+	n := int64(t.order.Uint32(e[4:8]))
+	var bs []byte
+	if n <= 4 {
+		bs = e[8 : 8+n]
+	} else {
+		off := int64(t.order.Uint32(e[8:12]))
+		if off+n > t.size || n > 1<<16 {
+			return ""
+		}
+		bs = make([]byte, n)
+		if _, err := t.ra.ReadAt(bs, off); err != nil {
+			return ""
+		}
+	}
+	if i := bytes.IndexByte(bs, 0); i != -1 {
+		bs = bs[:i]
+	}
+	return strings.TrimSpace(string(bs))
+}
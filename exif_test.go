@@ -0,0 +1,199 @@
+package uniquefile_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/skillian/uniquefile"
+)
+
+// tiffField is a field of a test TIFF IFD.  Its value is a string for
+// an ASCII field, a uint16 for a SHORT or a uint32 for a LONG.
+type tiffField struct {
+	tag   uint16
+	value interface{}
+}
+
+// tiffData creates TIFF data with the fields in its first IFD and in
+// its EXIF IFD if there are any exif fields.
+func tiffData(order binary.ByteOrder, ifd0, exif []tiffField) []byte {
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, tiffField{0x8769, uint32(0)})
+	}
+	ifdSize := func(fs []tiffField) int { return 2 + 12*len(fs) + 4 }
+	exifOffset := 8 + ifdSize(ifd0)
+	dataOffset := exifOffset + ifdSize(exif)
+	var ifds, data bytes.Buffer
+	writeIFD := func(fs []tiffField) {
+		binary.Write(&ifds, order, uint16(len(fs)))
+		for _, f := range fs {
+			var entry [12]byte
+			order.PutUint16(entry[:], f.tag)
+			switch v := f.value.(type) {
+			case string:
+				v += "\x00"
+				order.PutUint16(entry[2:], 2)
+				order.PutUint32(entry[4:], uint32(len(v)))
+				if len(v) <= 4 {
+					copy(entry[8:], v)
+				} else {
+					order.PutUint32(entry[8:], uint32(dataOffset+data.Len()))
+					data.WriteString(v)
+				}
+			case uint16:
+				order.PutUint16(entry[2:], 3)
+				order.PutUint32(entry[4:], 1)
+				order.PutUint16(entry[8:], v)
+			case uint32:
+				if f.tag == 0x8769 {
+					v = uint32(exifOffset)
+				}
+				order.PutUint16(entry[2:], 4)
+				order.PutUint32(entry[4:], 1)
+				order.PutUint32(entry[8:], v)
+			}
+			ifds.Write(entry[:])
+		}
+		binary.Write(&ifds, order, uint32(0))
+	}
+	writeIFD(ifd0)
+	if len(exif) > 0 {
+		writeIFD(exif)
+	}
+	header := []byte("II*\x00\x08\x00\x00\x00")
+	if order == binary.BigEndian {
+		header = []byte("MM\x00*\x00\x00\x00\x08")
+	}
+	return append(append(header, ifds.Bytes()...), data.Bytes()...)
+}
+
+func exifOf(t *testing.T, r io.Reader) map[string]string {
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	if err := uniquefile.ExifIndicator.Indicate(context.Background(), r, ind); err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := ind.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]string, len(lookup))
+	for k, v := range lookup {
+		m[string(k)] = string(v)
+	}
+	return m
+}
+
+func uint64Value(v uint64) string {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return string(buf[:])
+}
+
+func TestExifIndicator(t *testing.T) {
+	camera := []tiffField{
+		{0x010f, "Canon"},
+		{0x0110, "Canon EOS 5D  "},
+		{0x0112, uint16(6)},
+	}
+	shot := []tiffField{
+		{0x9003, "2021:07:04 18:30:15"},
+		{0xa002, uint32(4000)},
+		{0xa003, uint32(3000)},
+	}
+	jpg := encodeImage(t, testImage(64, 48, false), true)
+	withExif := func(tiff []byte) []byte {
+		return insertAt(jpg, 2, jpegSegment(0xe1, "Exif\x00\x00"+string(tiff)))
+	}
+	full := map[string]string{
+		"exif.make":        "Canon",
+		"exif.model":       "Canon EOS 5D",
+		"exif.datetime":    "2021-07-04T18:30:15",
+		"exif.width":       uint64Value(64),
+		"exif.height":      uint64Value(48),
+		"exif.orientation": uint64Value(6),
+	}
+	tiffFile := tiffData(binary.LittleEndian, append([]tiffField{
+		{0x0100, uint32(6000)},
+		{0x0101, uint16(4000)},
+		{0x0112, uint16(1)},
+	}, camera[:2]...), []tiffField{{0x9004, "2019:01:02 03:04:05"}})
+	tiffPath := filepath.Join(t.TempDir(), "photo.tif")
+	if err := os.WriteFile(tiffPath, tiffFile, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(tiffPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tiffExpect := map[string]string{
+		"exif.make":        "Canon",
+		"exif.model":       "Canon EOS 5D",
+		"exif.datetime":    "2019-01-02T03:04:05",
+		"exif.width":       uint64Value(6000),
+		"exif.height":      uint64Value(4000),
+		"exif.orientation": uint64Value(1),
+	}
+	for _, tc := range []struct {
+		name   string
+		r      io.Reader
+		expect map[string]string
+	}{
+		{"jpegBigEndian", bytes.NewReader(withExif(tiffData(binary.BigEndian, camera, shot))), full},
+		{"jpegLittleEndian", bytes.NewReader(withExif(tiffData(binary.LittleEndian, camera, shot))), full},
+		{"jpegNoExif", bytes.NewReader(jpg), map[string]string{
+			"exif.width":  uint64Value(64),
+			"exif.height": uint64Value(48),
+		}},
+		{"jpegBrokenExif", bytes.NewReader(withExif([]byte("MM\x00*\xff\xff\xff\xff"))), map[string]string{
+			"exif.width":  uint64Value(64),
+			"exif.height": uint64Value(48),
+		}},
+		{"jpegInvalidValues", bytes.NewReader(withExif(tiffData(binary.BigEndian,
+			[]tiffField{{0x0112, uint16(9)}},
+			[]tiffField{{0x9003, "0000:00:00 00:00:00"}},
+		))), map[string]string{
+			"exif.width":  uint64Value(64),
+			"exif.height": uint64Value(48),
+		}},
+		{"tiffFile", f, tiffExpect},
+		{"tiffStream", io.MultiReader(bytes.NewReader(tiffFile)), tiffExpect},
+		{"notPhoto", bytes.NewReader([]byte("MM\x00")), map[string]string{}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			actual := exifOf(t, tc.r)
+			if len(actual) != len(tc.expect) {
+				t.Fatalf("expected %q, actual: %q", tc.expect, actual)
+			}
+			for k, v := range tc.expect {
+				if actual[k] != v {
+					t.Fatalf("expected %s = %q, actual: %q", k, v, actual[k])
+				}
+			}
+		})
+	}
+}
+
+func TestExifIndicatorCmp(t *testing.T) {
+	ctx := context.Background()
+	c, err := uniquefile.ExifIndicator.Cmp(
+		ctx, []byte("exif.datetime"),
+		[]byte("2019-12-31T23:59:59"), []byte("2020-01-01T00:00:00"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c >= 0 {
+		t.Fatalf("expected the earlier time to be less, got %d", c)
+	}
+	if _, err = uniquefile.ExifIndicator.Cmp(ctx, []byte("sha256"), nil, nil); err != uniquefile.ErrCannotCmp {
+		t.Fatalf("expected ErrCannotCmp, got %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package uniquefile
+
+import "sort"
+
+// UnregisterIndicator lets the tests remove the indicators they
+// register so that they can be run more than once.
+var UnregisterIndicator = unregisterIndicator
+
+// IndicationJSONKeys are the keys whose values aren't represented in
+// hex by Indication.MarshalJSON.
+func IndicationJSONKeys() []string {
+	keys := make([]string, 0, len(indicationJSONKinds))
+	for key := range indicationJSONKinds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,496 @@
+package uniquefile
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// decodeFLAC decodes a FLAC stream.  The frames' CRCs aren't checked,
+// but a stream that ends in the middle of a frame is fingerprinted up
+// to the last whole frame.
+func decodeFLAC(r *bufio.Reader) (*audioFingerprinter, error) {
+	var buf [34]byte
+	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+		return nil, err
+	}
+	var info *flacStreamInfo
+	for last := false; !last; {
+		if _, err := io.ReadFull(r, buf[:4]); err != nil {
+			return nil, flacEOF(err)
+		}
+		last = buf[0]&0x80 != 0
+		typ := buf[0] & 0x7f
+		size := int64(buf[1])<<16 | int64(buf[2])<<8 | int64(buf[3])
+		if typ == 0 {
+			if size < int64(len(buf)) {
+				return nil, audioFormatError("FLAC stream info is too short")
+			}
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return nil, flacEOF(err)
+			}
+			size -= int64(len(buf))
+			info = &flacStreamInfo{
+				sampleRate:    int64(buf[10])<<12 | int64(buf[11])<<4 | int64(buf[12])>>4,
+				channels:      int(buf[12]>>1&0x7) + 1,
+				bitsPerSample: int(buf[12]&1)<<4 | int(buf[13]>>4) + 1,
+			}
+		}
+		if _, err := io.CopyN(io.Discard, r, size); err != nil {
+			return nil, flacEOF(err)
+		}
+	}
+	if info == nil || info.sampleRate == 0 {
+		return nil, audioFormatError("FLAC stream has no stream info")
+	}
+	d := flacDecoder{br: flacBitReader{r: r}, info: info}
+	fp := newAudioFingerprinter(info.sampleRate)
+	var mono []float64
+	for {
+		if _, err := r.Peek(1); err != nil {
+			if err == io.EOF {
+				return fp, nil
+			}
+			return nil, err
+		}
+		channels, bitsPerSample, err := d.frame()
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return fp, nil
+			}
+			return nil, err
+		}
+		n := len(channels[0])
+		if cap(mono) < n {
+			mono = make([]float64, n)
+		}
+		mono = mono[:n]
+		scale := math.Ldexp(1, bitsPerSample-1) * float64(len(channels))
+		for i := range mono {
+			var sum int64
+			for _, ch := range channels {
+				sum += ch[i]
+			}
+			mono[i] = float64(sum) / scale
+		}
+		if err = fp.add(mono); err != nil {
+			if err == errAudioLimit {
+				return fp, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+func flacEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return audioFormatError("FLAC stream is truncated")
+	}
+	return err
+}
+
+type flacStreamInfo struct {
+	sampleRate    int64
+	channels      int
+	bitsPerSample int
+}
+
+type flacDecoder struct {
+	br      flacBitReader
+	info    *flacStreamInfo
+	samples [8][]int64
+}
+
+const (
+	flacIndependent = 7
+	flacLeftSide    = 8
+	flacSideRight   = 9
+	flacMidSide     = 10
+)
+
+var flacSampleSizes = [8]int{0, 8, 12, 0, 16, 20, 24, 32}
+
+// frame decodes the next frame and returns its samples by channel.
+func (d *flacDecoder) frame() (channels [][]int64, bitsPerSample int, err error) {
+	br := &d.br
+	sync, err := br.read(14)
+	if err != nil {
+		return nil, 0, err
+	}
+	if sync != 0x3ffe {
+		return nil, 0, audioFormatError("FLAC frame sync code not found")
+	}
+	if _, err = br.read(2); err != nil {
+		return nil, 0, err
+	}
+	blockSizeCode, err := br.read(4)
+	if err != nil {
+		return nil, 0, err
+	}
+	sampleRateCode, err := br.read(4)
+	if err != nil {
+		return nil, 0, err
+	}
+	assignment, err := br.read(4)
+	if err != nil {
+		return nil, 0, err
+	}
+	sampleSizeCode, err := br.read(4)
+	if err != nil {
+		return nil, 0, err
+	}
+	// the frame or sample number is encoded like UTF-8:
+	first, err := br.read(8)
+	if err != nil {
+		return nil, 0, err
+	}
+	for n := bits.LeadingZeros8(^uint8(first)); n > 1; n-- {
+		if _, err = br.read(8); err != nil {
+			return nil, 0, err
+		}
+	}
+	var blockSize int
+	switch {
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode >= 2 && blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6 || blockSizeCode == 7:
+		v, err := br.read(8 << (blockSizeCode - 6))
+		if err != nil {
+			return nil, 0, err
+		}
+		blockSize = int(v) + 1
+	case blockSizeCode >= 8:
+		blockSize = 256 << (blockSizeCode - 8)
+	default:
+		return nil, 0, audioFormatError("invalid FLAC block size")
+	}
+	switch sampleRateCode {
+	case 12:
+		_, err = br.read(8)
+	case 13, 14:
+		_, err = br.read(16)
+	case 15:
+		err = audioFormatError("invalid FLAC sample rate")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	// header CRC-8:
+	if _, err = br.read(8); err != nil {
+		return nil, 0, err
+	}
+	bitsPerSample = d.info.bitsPerSample
+	if sampleSizeCode>>1 != 0 {
+		if bitsPerSample = flacSampleSizes[sampleSizeCode>>1]; bitsPerSample == 0 {
+			return nil, 0, audioFormatError("invalid FLAC sample size")
+		}
+	}
+	numChannels := 2
+	switch {
+	case assignment <= flacIndependent:
+		numChannels = int(assignment) + 1
+	case assignment > flacMidSide:
+		return nil, 0, audioFormatError("invalid FLAC channel assignment")
+	}
+	for c := 0; c < numChannels; c++ {
+		sampleBits := bitsPerSample
+		// side channels have an extra bit:
+		if (assignment == flacLeftSide || assignment == flacMidSide) && c == 1 ||
+			assignment == flacSideRight && c == 0 {
+			sampleBits++
+		}
+		if cap(d.samples[c]) < blockSize {
+			d.samples[c] = make([]int64, blockSize)
+		}
+		d.samples[c] = d.samples[c][:blockSize]
+		if err = d.subframe(d.samples[c], sampleBits); err != nil {
+			return nil, 0, err
+		}
+	}
+	// the frame is padded to a whole byte and ends with a CRC-16:
+	br.align()
+	if _, err = br.read(16); err != nil {
+		return nil, 0, err
+	}
+	channels = d.samples[:numChannels]
+	switch assignment {
+	case flacLeftSide:
+		for i, side := range channels[1] {
+			channels[1][i] = channels[0][i] - side
+		}
+	case flacSideRight:
+		for i, side := range channels[0] {
+			channels[0][i] = side + channels[1][i]
+		}
+	case flacMidSide:
+		for i, side := range channels[1] {
+			mid := channels[0][i]<<1 | side&1
+			channels[0][i] = (mid + side) >> 1
+			channels[1][i] = (mid - side) >> 1
+		}
+	}
+	return channels, bitsPerSample, nil
+}
+
+var flacFixedCoefs = [5][]int64{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+func (d *flacDecoder) subframe(samples []int64, sampleBits int) error {
+	br := &d.br
+	header, err := br.read(8)
+	if err != nil {
+		return err
+	}
+	if header&0x80 != 0 {
+		return audioFormatError("invalid FLAC subframe header")
+	}
+	wasted := 0
+	if header&1 != 0 {
+		n, err := br.unary()
+		if err != nil {
+			return err
+		}
+		wasted = int(n) + 1
+		if sampleBits -= wasted; sampleBits <= 0 {
+			return audioFormatError("invalid FLAC wasted bits")
+		}
+	}
+	switch typ := int(header >> 1 & 0x3f); {
+	case typ == 0:
+		v, err := br.readSigned(sampleBits)
+		if err != nil {
+			return err
+		}
+		for i := range samples {
+			samples[i] = v
+		}
+	case typ == 1:
+		for i := range samples {
+			if samples[i], err = br.readSigned(sampleBits); err != nil {
+				return err
+			}
+		}
+	case typ >= 8 && typ <= 12:
+		order := typ - 8
+		if err = d.predict(samples, sampleBits, flacFixedCoefs[order], 0); err != nil {
+			return err
+		}
+	case typ >= 32:
+		order := typ - 31
+		if order > len(samples) {
+			return audioFormatError("FLAC predictor order is larger than its block")
+		}
+		// The warm-up samples are before the coefficients, so
+		// they're read into samples here and predict skips them.
+		for i := 0; i < order; i++ {
+			if samples[i], err = br.readSigned(sampleBits); err != nil {
+				return err
+			}
+		}
+		precision, err := br.read(4)
+		if err != nil {
+			return err
+		}
+		if precision == 15 {
+			return audioFormatError("invalid FLAC coefficient precision")
+		}
+		shift, err := br.readSigned(5)
+		if err != nil {
+			return err
+		}
+		if shift < 0 {
+			return audioFormatError("invalid FLAC coefficient shift")
+		}
+		coefs := make([]int64, order)
+		for i := range coefs {
+			if coefs[i], err = br.readSigned(int(precision) + 1); err != nil {
+				return err
+			}
+		}
+		if err = d.predictFrom(samples, order, coefs, uint(shift)); err != nil {
+			return err
+		}
+	default:
+		return audioFormatError("invalid FLAC subframe type")
+	}
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= uint(wasted)
+		}
+	}
+	return nil
+}
+
+// predict reads the warm-up samples and the residual of a fixed or LPC
+// subframe and restores the samples.
+func (d *flacDecoder) predict(samples []int64, sampleBits int, coefs []int64, shift uint) (err error) {
+	order := len(coefs)
+	if order > len(samples) {
+		return audioFormatError("FLAC predictor order is larger than its block")
+	}
+	for i := 0; i < order; i++ {
+		if samples[i], err = d.br.readSigned(sampleBits); err != nil {
+			return err
+		}
+	}
+	return d.predictFrom(samples, order, coefs, shift)
+}
+
+// predictFrom reads the residual after the order warm-up samples and
+// restores the samples.
+func (d *flacDecoder) predictFrom(samples []int64, order int, coefs []int64, shift uint) error {
+	if err := d.residual(samples, order); err != nil {
+		return err
+	}
+	for i := order; i < len(samples); i++ {
+		var sum int64
+		for j, c := range coefs {
+			sum += c * samples[i-1-j]
+		}
+		samples[i] += sum >> shift
+	}
+	return nil
+}
+
+// residual reads the Rice coded residual into samples[order:].
+func (d *flacDecoder) residual(samples []int64, order int) error {
+	br := &d.br
+	method, err := br.read(2)
+	if err != nil {
+		return err
+	}
+	paramBits, escape := 4, uint64(15)
+	switch method {
+	case 0:
+	case 1:
+		paramBits, escape = 5, 31
+	default:
+		return audioFormatError("invalid FLAC residual coding method")
+	}
+	partitionOrder, err := br.read(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << partitionOrder
+	partitionSize := len(samples) >> partitionOrder
+	if partitionSize<<partitionOrder != len(samples) || partitionSize < order {
+		return audioFormatError("invalid FLAC partition order")
+	}
+	i := order
+	for p := 0; p < partitions; p++ {
+		end := (p + 1) * partitionSize
+		param, err := br.read(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			n, err := br.read(5)
+			if err != nil {
+				return err
+			}
+			for ; i < end; i++ {
+				if n == 0 {
+					samples[i] = 0
+				} else if samples[i], err = br.readSigned(int(n)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			q, err := br.unary()
+			if err != nil {
+				return err
+			}
+			low, err := br.read(int(param))
+			if err != nil {
+				return err
+			}
+			v := q<<param | low
+			samples[i] = int64(v>>1) ^ -int64(v&1)
+		}
+	}
+	return nil
+}
+
+// flacBitReader reads big endian bits.
+type flacBitReader struct {
+	r *bufio.Reader
+
+	// x holds n bits that were read from r but not yet returned,
+	// aligned to its most significant bit.
+	x uint64
+	n int
+}
+
+func (br *flacBitReader) fill(n int) error {
+	for br.n < n {
+		b, err := br.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		br.x |= uint64(b) << uint(56-br.n)
+		br.n += 8
+	}
+	return nil
+}
+
+// read reads an unsigned integer of up to 56 bits.
+func (br *flacBitReader) read(n int) (uint64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if err := br.fill(n); err != nil {
+		return 0, err
+	}
+	v := br.x >> uint(64-n)
+	br.x <<= uint(n)
+	br.n -= n
+	return v, nil
+}
+
+func (br *flacBitReader) readSigned(n int) (int64, error) {
+	v, err := br.read(n)
+	if err != nil {
+		return 0, err
+	}
+	shift := uint(64 - n)
+	return int64(v<<shift) >> shift, nil
+}
+
+// unary counts the zero bits before the next one bit.
+func (br *flacBitReader) unary() (uint64, error) {
+	var n uint64
+	for {
+		if br.n == 0 {
+			if err := br.fill(8); err != nil {
+				return 0, err
+			}
+		}
+		if zeros := bits.LeadingZeros64(br.x); zeros < br.n {
+			br.x <<= uint(zeros + 1)
+			br.n -= zeros + 1
+			return n + uint64(zeros), nil
+		}
+		n += uint64(br.n)
+		br.x, br.n = 0, 0
+	}
+}
+
+// align skips the bits up to the next byte.
+func (br *flacBitReader) align() {
+	skip := br.n % 8
+	br.x <<= uint(skip)
+	br.n -= skip
+}
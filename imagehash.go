@@ -0,0 +1,386 @@
+package uniquefile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+
+	// register the decoders that the image hash indicator accepts:
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/skillian/errors"
+)
+
+const (
+	imageHashIndicatorKey = "imagehash"
+	aHashKey              = "ahash"
+	dHashKey              = "dhash"
+	pHashKey              = "phash"
+
+	// defaultImageHashMaxPixels is the default limit on the number
+	// of pixels in an image that is decoded.
+	defaultImageHashMaxPixels = 64 << 20
+)
+
+// ImageHashIndicator computes a 64-bit perceptual hash of an image
+// (see NewImageHashIndicator) with the pHash algorithm.
+var ImageHashIndicator interface {
+	Indicator
+	IndicatorCmper
+	IndicatorSimilarer
+	IndicatorBander
+} = imageHashIndicator{key: pHashKey, hash: pHash, maxPixels: defaultImageHashMaxPixels}
+
+// NewImageHashIndicator creates an Indicator that decodes a JPEG, PNG
+// or GIF image and computes a 64-bit perceptual hash of it with the
+// given algorithm:
+//
+//	ahash:	Whether each pixel of an 8x8 grayscale thumbnail is
+//		brighter than the average.
+//	dhash:	Whether each pixel of a 9x8 grayscale thumbnail is
+//		brighter than its neighbor to the right.
+//	phash:	Whether each of the lowest 8x8 frequencies of the
+//		discrete cosine transform of a 32x32 grayscale thumbnail
+//		is greater than the median.
+//
+// The hash is written as a big endian 64-bit integer under the
+// algorithm's name.  Unlike a cryptographic hash, images that look
+// the same have the same or similar hashes even if they were resized
+// or recompressed, so the Indicator's Cmp method returns the Hamming
+// distance between two hashes instead of an ordering:  0 means that
+// the images are very likely the same and anything above about 10
+// means they are probably different.  Its Similarity method scales
+// the distance to the fraction of bits that are the same.
+//
+// If the data is not an image in one of those formats or if the image
+// has more than 64Mi pixels, nothing is written.  Only the image's
+// header is read to find that out, so other files are not read any
+// further and huge images are never decoded.
+func NewImageHashIndicator(algo string) (interface {
+	Indicator
+	IndicatorCmper
+	IndicatorSimilarer
+	IndicatorBander
+}, error) {
+	ir, err := newImageHashIndicator(algo, defaultImageHashMaxPixels)
+	if err != nil {
+		return nil, err
+	}
+	return ir, nil
+}
+
+func newImageHashIndicatorFromSpec(spec IndicatorSpec) (Indicator, error) {
+	if err := spec.CheckParams("algo", "pixels"); err != nil {
+		return nil, err
+	}
+	algo, ok := spec.Params["algo"]
+	if !ok {
+		algo = pHashKey
+	}
+	maxPixels, err := spec.Size("pixels", defaultImageHashMaxPixels)
+	if err != nil {
+		return nil, err
+	}
+	ir, err := newImageHashIndicator(algo, maxPixels)
+	if err != nil {
+		return nil, err
+	}
+	return ir, nil
+}
+
+func newImageHashIndicator(algo string, maxPixels int64) (imageHashIndicator, error) {
+	if maxPixels <= 0 {
+		return imageHashIndicator{}, errors.Errorf(
+			"image pixel limit must be positive, not %d",
+			maxPixels,
+		)
+	}
+	ir := imageHashIndicator{key: algo, maxPixels: maxPixels}
+	switch algo {
+	case aHashKey:
+		ir.hash = aHash
+	case dHashKey:
+		ir.hash = dHash
+	case pHashKey:
+		ir.hash = pHash
+	default:
+		return imageHashIndicator{}, errors.Errorf(
+			"unknown image hash algorithm: %q", algo,
+		)
+	}
+	return ir, nil
+}
+
+type imageHashIndicator struct {
+	key       string
+	hash      func(img image.Image) uint64
+	maxPixels int64
+}
+
+var imageHashKeys = []Bytes{aHashKey, dHashKey, pHashKey}
+
+func (imageHashIndicator) Keys() []Bytes { return imageHashKeys }
+
+// Cmp returns the Hamming distance between two image hashes.
+func (imageHashIndicator) Cmp(ctx context.Context, key, a, b []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	switch Bytes(key) {
+	case aHashKey, dHashKey, pHashKey:
+	default:
+		return 0, ErrCannotCmp
+	}
+	if len(a) != 8 || len(b) != 8 {
+		return 0, ErrCannotCmp
+	}
+	return bits.OnesCount64(byteOrder.Uint64(a) ^ byteOrder.Uint64(b)), nil
+}
+
+// imageHashThreshold is the Hamming distance at or under which images
+// are considered near-duplicates.
+const imageHashThreshold = 10
+
+// Similarity returns the fraction of the bits of two image hashes that
+// are the same.
+func (ir imageHashIndicator) Similarity(ctx context.Context, key, a, b []byte) (float64, error) {
+	d, err := ir.Cmp(ctx, key, a, b)
+	if err != nil {
+		return 0, err
+	}
+	return 1 - float64(d)/64, nil
+}
+
+func (imageHashIndicator) Threshold(key []byte) float64 {
+	return 1 - float64(imageHashThreshold)/64
+}
+
+// Bands splits a hash into blocks of bits so that hashes within the
+// Hamming distance that the threshold allows differ in no more than e
+// bits of at least one block.  Each band is a block with e of its bits
+// cleared, and there is a band for every choice of e bits, so two
+// blocks that differ in e bits or less have the band in common that
+// clears the bits where they differ.  The number of blocks is chosen
+// to get the fewest candidates with no more than imageHashMaxBands
+// bands.
+func (ir imageHashIndicator) Bands(ctx context.Context, key, value []byte, threshold float64) ([][]byte, error) {
+	if _, err := ir.Cmp(ctx, key, value, value); err != nil {
+		return nil, err
+	}
+	// a tiny bit is added to the distance so that rounding doesn't
+	// lose the threshold's own distance:
+	blocks, e := imageHashBlocks(int(math.Floor((1-threshold)*64 + 1e-9)))
+	if blocks == 0 {
+		// every hash is a candidate:
+		return [][]byte{nil}, nil
+	}
+	h := byteOrder.Uint64(value)
+	var bands [][]byte
+	for i := 0; i < blocks; i++ {
+		lo, hi := i*64/blocks, (i+1)*64/blocks
+		n := uint(hi - lo)
+		bits := h >> uint(64-hi) & (1<<n - 1)
+		// Gosper's hack enumerates the masks of e bits:
+		for mask := uint64(1)<<uint(e) - 1; mask < 1<<n; {
+			band := make([]byte, 17)
+			band[0] = byte(i)
+			byteOrder.PutUint64(band[1:], mask)
+			byteOrder.PutUint64(band[9:], bits&^mask)
+			bands = append(bands, band)
+			if mask == 0 {
+				break
+			}
+			c := mask & -mask
+			r := mask + c
+			mask = ((r^mask)>>2)/c | r
+		}
+	}
+	return bands, nil
+}
+
+// imageHashMaxBands is the most bands that Bands returns for a hash.
+const imageHashMaxBands = 1024
+
+// imageHashBlocks finds the number of blocks to split hashes into and
+// the number of bits that can differ in a block so that hashes within
+// distance d differ in no more than e bits of at least one block.  If
+// splitting the hashes doesn't rule out many candidates, 0 blocks are
+// returned.
+func imageHashBlocks(d int) (blocks, e int) {
+	best := 1.0
+	for m := 1; m <= 64; m++ {
+		short, long := 64/m, (64+m-1)/m
+		em := d / m
+		if em > short || float64(m)*binomial(long, em) > imageHashMaxBands {
+			continue
+		}
+		// the chance that a block of random hashes is within em
+		// bits:
+		near := 0.0
+		for k := 0; k <= em; k++ {
+			near += binomial(short, k)
+		}
+		if frac := float64(m) * near / math.Exp2(float64(short)); frac < best {
+			best, blocks, e = frac, m, em
+		}
+	}
+	return
+}
+
+func binomial(n, k int) float64 {
+	b := 1.0
+	for i := 0; i < k; i++ {
+		b = b * float64(n-i) / float64(i+1)
+	}
+	return b
+}
+
+func (ir imageHashIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	br := bufio.NewReader(readerContext{ctx, r})
+	head, err := br.Peek(imageSniffLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !MatchMIMEType(DetectContentType(head), imageHashMIMETypes...) {
+		return nil
+	}
+	// Decode the header first to check the size of the image before
+	// the decoder allocates its pixels.  What it reads is kept so
+	// that the image can be decoded from the start.
+	var buf bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(br, &buf))
+	if err != nil {
+		if err == image.ErrFormat {
+			return nil
+		}
+		return err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > ir.maxPixels {
+		return nil
+	}
+	img, _, err := image.Decode(io.MultiReader(&buf, br))
+	if err != nil {
+		if err == image.ErrFormat {
+			return nil
+		}
+		return err
+	}
+	var bs [8]byte
+	byteOrder.PutUint64(bs[:], ir.hash(img))
+	ind.Write([]byte(ir.key), bs[:])
+	return nil
+}
+
+// imageSniffLen is how much of the data is peeked at to check that it
+// is an image before trying to decode it.
+const imageSniffLen = 512
+
+// imageHashMIMETypes are the media types of the images that have
+// decoders registered.
+var imageHashMIMETypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// grayThumbnail shrinks img to w x h by averaging the luminance of the
+// pixels that fall into each thumbnail pixel.
+func grayThumbnail(img image.Image, w, h int) []float64 {
+	b := img.Bounds()
+	sums := make([]float64, w*h)
+	counts := make([]int, w*h)
+	dx, dy := b.Dx(), b.Dy()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		ty := (y - b.Min.Y) * h / dy
+		for x := b.Min.X; x < b.Max.X; x++ {
+			tx := (x - b.Min.X) * w / dx
+			r, g, bl, _ := img.At(x, y).RGBA()
+			i := ty*w + tx
+			sums[i] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+			counts[i]++
+		}
+	}
+	for i, c := range counts {
+		if c > 0 {
+			sums[i] /= float64(c)
+		}
+	}
+	return sums
+}
+
+func aHash(img image.Image) (hash uint64) {
+	px := grayThumbnail(img, 8, 8)
+	var mean float64
+	for _, p := range px {
+		mean += p
+	}
+	mean /= float64(len(px))
+	for i, p := range px {
+		if p > mean {
+			hash |= 1 << uint(63-i)
+		}
+	}
+	return
+}
+
+func dHash(img image.Image) (hash uint64) {
+	px := grayThumbnail(img, 9, 8)
+	i := 0
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if px[y*9+x] < px[y*9+x+1] {
+				hash |= 1 << uint(63-i)
+			}
+			i++
+		}
+	}
+	return
+}
+
+func pHash(img image.Image) (hash uint64) {
+	const n = 32
+	px := grayThumbnail(img, n, n)
+	// separable 2D DCT-II, but only the lowest 8 frequencies of
+	// each dimension are needed.
+	var cos [8][n]float64
+	for u := range cos {
+		for x := 0; x < n; x++ {
+			cos[u][x] = math.Cos(float64((2*x+1)*u) * math.Pi / (2 * n))
+		}
+	}
+	var rows [n][8]float64
+	for y := 0; y < n; y++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for x := 0; x < n; x++ {
+				sum += px[y*n+x] * cos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	var coefs [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var sum float64
+			for y := 0; y < n; y++ {
+				sum += rows[y][u] * cos[v][y]
+			}
+			coefs[v*8+u] = sum
+		}
+	}
+	// The DC coefficient is just the average brightness, so leave
+	// it out of the median.
+	sorted := make([]float64, 63)
+	copy(sorted, coefs[1:])
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	for i, c := range coefs {
+		if c > median {
+			hash |= 1 << uint(63-i)
+		}
+	}
+	return
+}
@@ -0,0 +1,168 @@
+package uniquefile_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/skillian/uniquefile"
+)
+
+// testImage draws random rectangles over a gradient so that the image
+// has structure at several frequencies.  Every call with the same
+// size draws the same picture.
+func testImage(w, h int, invert bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := uint8(x * 255 / w)
+			img.Set(x, y, color.RGBA{c, uint8(y * 255 / h), 128, 255})
+		}
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 12; i++ {
+		x0, y0 := rng.Float64(), rng.Float64()
+		x1, y1 := x0+rng.Float64()/3, y0+rng.Float64()/3
+		c := color.RGBA{
+			uint8(rng.Intn(256)), uint8(rng.Intn(256)),
+			uint8(rng.Intn(256)), 255,
+		}
+		for y := int(y0 * float64(h)); y < int(y1*float64(h)) && y < h; y++ {
+			for x := int(x0 * float64(w)); x < int(x1*float64(w)) && x < w; x++ {
+				img.Set(x, y, c)
+			}
+		}
+	}
+	if invert {
+		for i := range img.Pix {
+			if i%4 != 3 {
+				img.Pix[i] = 255 - img.Pix[i]
+			}
+		}
+	}
+	return img
+}
+
+func encodeImage(t *testing.T, img image.Image, asJPEG bool) []byte {
+	var buf bytes.Buffer
+	var err error
+	if asJPEG {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 40})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageHashIndicator(t *testing.T) {
+	ctx := context.Background()
+	original := encodeImage(t, testImage(300, 200, false), false)
+	resized := encodeImage(t, testImage(150, 100, false), true)
+	inverted := encodeImage(t, testImage(300, 200, true), false)
+	for _, algo := range []string{"ahash", "dhash", "phash"} {
+		algo := algo
+		t.Run(algo, func(t *testing.T) {
+			ir, err := uniquefile.NewImageHashIndicator(algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash := func(data []byte) []byte {
+				ind := uniquefile.NewIndication()
+				if err := ir.Indicate(ctx, bytes.NewReader(data), ind); err != nil {
+					t.Fatal(err)
+				}
+				lookup, err := ind.Lookup()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return lookup[uniquefile.Bytes(algo)]
+			}
+			a, b, c := hash(original), hash(resized), hash(inverted)
+			same, err := ir.Cmp(ctx, []byte(algo), a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if same > 6 {
+				t.Fatalf("resized image distance %d is too far", same)
+			}
+			different, err := ir.Cmp(ctx, []byte(algo), a, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if different < 20 {
+				t.Fatalf("inverted image distance %d is too close", different)
+			}
+		})
+	}
+}
+
+func TestImageHashIndicatorNotImage(t *testing.T) {
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	err := uniquefile.ImageHashIndicator.Indicate(
+		context.Background(), bytes.NewReader([]byte("hello, world!")), ind,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ind.Bytes()) != 0 {
+		t.Fatalf("expected no indication, got %v", ind.Bytes())
+	}
+	if _, err := uniquefile.ImageHashIndicator.Cmp(
+		context.Background(), []byte("length"), nil, nil,
+	); err != uniquefile.ErrCannotCmp {
+		t.Fatalf("expected ErrCannotCmp, got %v", err)
+	}
+}
+
+// indicateNoImageHash indicates data with ir and fails the test if
+// anything is written.
+func indicateNoImageHash(t *testing.T, ir uniquefile.Indicator, r io.Reader) {
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	if err := ir.Indicate(context.Background(), r, ind); err != nil {
+		t.Fatal(err)
+	}
+	if len(ind.Bytes()) != 0 {
+		t.Fatalf("expected no indication, got %v", ind.Bytes())
+	}
+}
+
+func TestImageHashIndicatorOnlyReadsHeader(t *testing.T) {
+	// If the indicator read past the beginning of data that isn't
+	// an image, it would get the error.
+	r := io.MultiReader(
+		bytes.NewReader(bytes.Repeat([]byte("not an image\n"), 1<<16)),
+		iotest.ErrReader(errors.New("read past the header")),
+	)
+	indicateNoImageHash(t, uniquefile.ImageHashIndicator, r)
+}
+
+func TestImageHashIndicatorTooManyPixels(t *testing.T) {
+	// A PNG with a header that claims to be 60000x60000 would make
+	// the decoder allocate gigabytes:
+	data := encodeImage(t, testImage(1, 1, false), false)
+	ihdr := data[8:]
+	byteOrder.PutUint32(ihdr[8:], 60000)
+	byteOrder.PutUint32(ihdr[12:], 60000)
+	byteOrder.PutUint32(ihdr[21:], crc32.ChecksumIEEE(ihdr[4:21]))
+	indicateNoImageHash(t, uniquefile.ImageHashIndicator, bytes.NewReader(data))
+	ir, ok := uniquefile.ParseIndicator("imagehash:algo=dhash,pixels=1k")
+	if !ok {
+		t.Fatal("failed to parse image hash indicator")
+	}
+	data = encodeImage(t, testImage(300, 200, false), false)
+	indicateNoImageHash(t, ir, bytes.NewReader(data))
+}
@@ -2,6 +2,9 @@ package uniquefile_test
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"testing"
 
@@ -79,3 +82,369 @@ func TestIndication(t *testing.T) {
 		})
 	}
 }
+
+func TestIndicationMarshalBinary(t *testing.T) {
+	a := &uniquefile.Indication{}
+	a.Write([]byte("sha256"), []byte("hash"))
+	a.Write([]byte("length"), []byte{0, 0, 0, 4})
+	a.Write([]byte("chunks"), nil)
+	b := &uniquefile.Indication{}
+	b.Write([]byte("length"), []byte{0, 0, 0, 4})
+	b.Write([]byte("chunks"), nil)
+	b.Write([]byte("sha256"), []byte("hash"))
+	ad, err := a.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bd, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ad, bd) {
+		t.Fatalf("encodings do not match:\n\t%v\n\t%v", ad, bd)
+	}
+	expect := []byte("\x01\x06chunks\x00\x06length\x04\x00\x00\x00\x04\x06sha256\x04hash")
+	if !bytes.Equal(ad, expect) {
+		t.Fatalf("expected:\n\t%q\nactual:\n\t%q", expect, ad)
+	}
+	c := &uniquefile.Indication{}
+	c.Write([]byte("stale"), []byte("value"))
+	if err := c.UnmarshalBinary(ad); err != nil {
+		t.Fatal(err)
+	}
+	// c must not refer to the encoded data:
+	ad[len(ad)-1] = 'X'
+	lookup, err := c.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lookup) != 3 || string(lookup["sha256"]) != "hash" ||
+		len(lookup["chunks"]) != 0 || len(lookup["length"]) != 4 {
+		t.Fatalf("unexpected indication after round trip: %q", lookup)
+	}
+}
+
+func TestIndicationUnmarshalBinaryErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"version", "\x02\x01a\x01b"},
+		{"truncatedKey", "\x01\x05abc"},
+		{"truncatedValue", "\x01\x01a"},
+		{"oversizedLength", "\x01\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01"},
+		{"unsorted", "\x01\x01b\x00\x01a\x00"},
+		{"longVarint", "\x01\x81\x00a\x00"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ind := &uniquefile.Indication{}
+			ind.Write([]byte("kept"), nil)
+			if err := ind.UnmarshalBinary([]byte(tc.data)); err == nil {
+				t.Fatalf("expected error, got %q", ind.Bytes())
+			}
+		})
+	}
+}
+
+func TestIndicationMarshalJSON(t *testing.T) {
+	ind := &uniquefile.Indication{}
+	ind.Write([]byte("sha256"), []byte{0xde, 0xad, 0xbe, 0xef})
+	ind.Write([]byte("mimetype"), []byte("text/plain"))
+	ind.Write([]byte("length"), []byte{0, 0, 0, 0, 0, 0, 0x01, 0x00})
+	bs, err := json.Marshal(ind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expect = `{"length":256,"mimetype":"text/plain","sha256":"deadbeef"}`
+	if string(bs) != expect {
+		t.Fatalf("expected:\n\t%s\nactual:\n\t%s", expect, bs)
+	}
+	decoded := &uniquefile.Indication{}
+	if err := json.Unmarshal(bs, decoded); err != nil {
+		t.Fatal(err)
+	}
+	a, err := ind.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := decoded.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Fatalf("round trip does not match:\n\t%q\n\t%q", a, b)
+	}
+}
+
+func TestIndicationJSONRoundTrip(t *testing.T) {
+	values := []string{
+		"", "text/plain", "Cam\xe9ra", "\x00\x00\x00\x00\x00\x00\x01\x00",
+		"\xff\xfe", "<a & b>",
+	}
+	for _, key := range append(uniquefile.IndicationJSONKeys(), "sha256") {
+		for _, value := range values {
+			key, value := key, value
+			t.Run(fmt.Sprintf("%s=%q", key, value), func(t *testing.T) {
+				ind := &uniquefile.Indication{}
+				ind.Write([]byte(key), []byte(value))
+				bs, err := json.Marshal(ind)
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded := &uniquefile.Indication{}
+				if err := json.Unmarshal(bs, decoded); err != nil {
+					t.Fatalf("%s: %v", bs, err)
+				}
+				if !bytes.Equal(ind.Bytes(), decoded.Bytes()) {
+					t.Fatalf(
+						"%s does not round trip:\n\t%q\n\t%q",
+						bs, ind.Bytes(), decoded.Bytes(),
+					)
+				}
+			})
+		}
+	}
+	ind := &uniquefile.Indication{}
+	ind.Write([]byte("exif.model"), []byte("Cam\xe9ra"))
+	bs, err := json.Marshal(ind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const expect = `{"exif.model":{"hex":"43616de97261"}}`
+	if string(bs) != expect {
+		t.Fatalf("expected:\n\t%s\nactual:\n\t%s", expect, bs)
+	}
+}
+
+func TestIndicationUnmarshalJSONErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+	}{
+		{"array", `["sha256"]`},
+		{"hex", `{"sha256":"xyz"}`},
+		{"numberHash", `{"sha256":12}`},
+		{"negativeLength", `{"length":-1}`},
+		{"object", `{"mimetype":{}}`},
+		{"objectHash", `{"sha256":{"hex":"deadbeef"}}`},
+		{"objectNotHex", `{"mimetype":{"hex":"xyz"}}`},
+		{"objectExtra", `{"mimetype":{"hex":"00","text":"a"}}`},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ind := &uniquefile.Indication{}
+			if err := json.Unmarshal([]byte(tc.data), ind); err == nil {
+				t.Fatalf("expected error, got %q", ind.Bytes())
+			}
+		})
+	}
+}
+
+func TestParseIndication(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		data   string
+		err    error
+		offset int
+	}{
+		{"empty", "", nil, 0},
+		{"valid", "\x05hello\x05world\x00\x00", nil, 0},
+		{"truncatedLength", "\x01a\x01b\x80", uniquefile.ErrIndicationTruncated, 4},
+		{"truncatedKey", "\x05hel", uniquefile.ErrIndicationTruncated, 0},
+		{"truncatedValue", "\x01a\x05wor", uniquefile.ErrIndicationTruncated, 2},
+		{"oversizedLength", "\x01a\xff\xff\xff\xff\xff\xff\xff\xff\xff\x7f", uniquefile.ErrIndicationLength, 2},
+		{"trailingKey", "\x01a\x01b\x01c", uniquefile.ErrIndicationTrailing, 4},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ind, err := uniquefile.ParseIndication([]byte(tc.data))
+			if tc.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer uniquefile.PutIndication(&ind)
+				if string(ind.Bytes()) != tc.data {
+					t.Fatalf("expected %q, got %q", tc.data, ind.Bytes())
+				}
+				return
+			}
+			var ie *uniquefile.IndicationError
+			if !errors.As(err, &ie) {
+				t.Fatalf("expected an IndicationError, got %v", err)
+			}
+			if !errors.Is(err, tc.err) || ie.Offset != tc.offset {
+				t.Fatalf(
+					"expected %v at offset %d, got %v",
+					tc.err, tc.offset, err,
+				)
+			}
+		})
+	}
+}
+
+// indicationSeeds are added to the corpus of the fuzz tests.
+var indicationSeeds = []string{
+	"",
+	"\x05hello\x05world",
+	"\x06length\x08\x00\x00\x00\x00\x00\x00\x00\x03\x06sha256\x00",
+	"\x05hel",
+	"\x01a\xff\xff\xff\xff\xff\xff\xff\xff\xff\x01",
+	"\x01a\x01b\x01c",
+	"\x80\x00\x00",
+}
+
+func FuzzParseIndication(f *testing.F) {
+	for _, seed := range indicationSeeds {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ind, err := uniquefile.ParseIndication(data)
+		if err != nil {
+			var ie *uniquefile.IndicationError
+			if !errors.As(err, &ie) {
+				t.Fatalf("expected an IndicationError, got %v", err)
+			}
+			if ie.Offset < 0 || ie.Offset >= len(data) {
+				t.Fatalf("offset %d is out of range", ie.Offset)
+			}
+			return
+		}
+		defer uniquefile.PutIndication(&ind)
+		rebuilt := &uniquefile.Indication{}
+		if err := ind.Each(func(key, value []byte) error {
+			rebuilt.Write(key, value)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := rebuilt.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		data, err = ind.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rebuilt.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzIndicationUnmarshalBinary(f *testing.F) {
+	for _, seed := range indicationSeeds {
+		f.Add(append([]byte{1}, seed...))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ind := &uniquefile.Indication{}
+		if err := ind.UnmarshalBinary(data); err != nil {
+			return
+		}
+		encoded, err := ind.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, data) {
+			t.Fatalf("expected %q, got %q", data, encoded)
+		}
+	})
+}
+
+func newTestIndication(kvps ...string) *uniquefile.Indication {
+	ind := &uniquefile.Indication{}
+	for i := 0; i < len(kvps); i += 2 {
+		ind.Write([]byte(kvps[i]), []byte(kvps[i+1]))
+	}
+	return ind
+}
+
+func TestIndicationAccessors(t *testing.T) {
+	ind := newTestIndication("a", "1", "b", "2", "c", "3")
+	if v, ok := ind.Get([]byte("b")); !ok || string(v) != "2" {
+		t.Fatalf("expected b=2, got %q, %v", v, ok)
+	}
+	if ind.Has([]byte("d")) {
+		t.Fatal("expected no d")
+	}
+	ind.Write([]byte("b"), []byte("22"))
+	ind.Write([]byte("a"), []byte("b"))
+	if !ind.Delete([]byte("c")) || ind.Delete([]byte("c")) {
+		t.Fatal("expected c to be deleted once")
+	}
+	keys, err := ind.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("expected keys [a b], got %q", keys)
+	}
+	if !bytes.Equal(ind.Bytes(), newTestIndication("a", "b", "b", "22").Bytes()) {
+		t.Fatalf("unexpected bytes: %q", ind.Bytes())
+	}
+	c := ind.Clone()
+	ind.Reset()
+	ind.Write([]byte("a"), []byte("x"))
+	if v, ok := c.Get([]byte("a")); !ok || string(v) != "b" {
+		t.Fatalf("clone shares bytes with original: a=%q", v)
+	}
+	uniquefile.PutIndication(&c)
+}
+
+func TestIndicationEqual(t *testing.T) {
+	a := newTestIndication("a", "1", "b", "2")
+	for _, tc := range []struct {
+		name           string
+		b              *uniquefile.Indication
+		subset, equals bool
+	}{
+		{"reordered", newTestIndication("b", "2", "a", "1"), true, true},
+		{"superset", newTestIndication("b", "2", "a", "1", "c", "3"), true, false},
+		{"subset", newTestIndication("a", "1"), false, false},
+		{"different", newTestIndication("a", "1", "b", "3"), false, false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if a.SubsetOf(tc.b) != tc.subset {
+				t.Fatalf("expected subset: %v", tc.subset)
+			}
+			if a.Equal(tc.b) != tc.equals || tc.b.Equal(a) != tc.equals {
+				t.Fatalf("expected equal: %v", tc.equals)
+			}
+		})
+	}
+}
+
+func TestIndicationMerge(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy uniquefile.MergePolicy
+		expect *uniquefile.Indication
+	}{
+		{"keep", uniquefile.MergeKeep, newTestIndication("a", "1", "b", "2", "c", "4")},
+		{"replace", uniquefile.MergeReplace, newTestIndication("a", "1", "b", "3", "c", "4")},
+		{"fail", uniquefile.MergeFail, nil},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ind := newTestIndication("a", "1", "b", "2")
+			err := ind.Merge(newTestIndication("b", "3", "c", "4"), tc.policy)
+			if tc.expect == nil {
+				var mce *uniquefile.MergeConflictError
+				if !errors.As(err, &mce) || mce.Key != "b" {
+					t.Fatalf("expected conflict on b, got %v", err)
+				}
+				if !ind.Equal(newTestIndication("a", "1", "b", "2")) {
+					t.Fatalf("expected nothing merged, got %q", ind.Bytes())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ind.Equal(tc.expect) {
+				t.Fatalf("expected %q, got %q", tc.expect.Bytes(), ind.Bytes())
+			}
+		})
+	}
+}
@@ -3,16 +3,28 @@ package uniquefile
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"hash"
 	"hash/crc32"
 	"io"
 	"io/fs"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
+	"github.com/cespare/xxhash/v2"
 	"github.com/skillian/errors"
+	"golang.org/x/crypto/blake2b"
 )
 
 // Bytes is just a string but its separate type makes it clear that
@@ -26,17 +38,482 @@ type Indicator interface {
 	Indicate(ctx context.Context, r io.Reader, ind *Indication) error
 }
 
-var indicators = map[string]Indicator{
-	lengthIndicatorKey: LengthIndicator,
-	crc32Key:           CRC32Indicator,
-	sha256Key:          SHA256Indicator,
+// IndicatorFactory creates an Indicator from a spec with parameters.
+type IndicatorFactory func(spec IndicatorSpec) (Indicator, error)
+
+type registeredIndicator struct {
+	ir          Indicator
+	factory     IndicatorFactory
+	description string
+}
+
+var (
+	indicatorsMu sync.RWMutex
+	indicators   = make(map[string]*registeredIndicator)
+)
+
+func init() {
+	RegisterIndicator(
+		lengthIndicatorKey, LengthIndicator,
+		"length: the length of the data in bytes",
+	)
+	RegisterIndicator(
+		crc32Key, CRC32Indicator,
+		"length, crc32: the length and CRC32 of the data",
+	)
+	RegisterIndicator(
+		sha256Key, SHA256Indicator,
+		"length, sha256: the length and SHA-256 of the data",
+	)
+	RegisterIndicator(
+		md5Key, MD5Indicator,
+		"length, md5: the length and MD5 of the data",
+	)
+	RegisterIndicator(
+		sha1Key, SHA1Indicator,
+		"length, sha1: the length and SHA-1 of the data",
+	)
+	RegisterIndicator(
+		sha512Key, SHA512Indicator,
+		"length, sha512: the length and SHA-512 of the data",
+	)
+	RegisterIndicator(
+		xxh64Key, XXH64Indicator,
+		"length, xxh64: the length and 64-bit xxHash of the data",
+	)
+	RegisterIndicator(
+		blake2bKey, BLAKE2bIndicator,
+		"length, blake2b: the length and 512-bit BLAKE2b of "+
+			"the data",
+	)
+	RegisterIndicator(
+		headTailIndicatorKey, HeadTailIndicator,
+		"head4k, tail4k: hashes of the first and last 4KiB of "+
+			"the data",
+	)
+	RegisterIndicatorFactory(
+		headTailIndicatorKey, newHeadTailIndicatorFromSpec,
+		"parameters: size (default: 4k), algo (default: sha256)",
+	)
+	RegisterIndicator(
+		chunksIndicatorKey, ChunksIndicator,
+		"chunks1m: the list of the lengths and hashes of "+
+			"content-defined chunks of the data",
+	)
+	RegisterIndicatorFactory(
+		chunksIndicatorKey, newChunksIndicatorFromSpec,
+		"parameters: avg (default: 1m)",
+	)
+	RegisterIndicator(
+		mimeTypeIndicatorKey, MIMETypeIndicator,
+		"mimetype: the media type of the data detected from "+
+			"its leading bytes",
+	)
+	RegisterIndicator(
+		textHashIndicatorKey, TextHashIndicator,
+		"text: the SHA-256 of text data after normalizing its "+
+			"line endings, trailing whitespace and byte "+
+			"order mark",
+	)
+	RegisterIndicatorFactory(
+		textHashIndicatorKey, newTextHashIndicatorFromSpec,
+		"parameters: eol, space, bom (each default: true), "+
+			"algo (default: sha256)",
+	)
+	RegisterIndicator(
+		archiveIndicatorKey, ArchiveIndicator,
+		"members, manifest: the hashes of the files in a ZIP "+
+			"or tar archive and a hash of the archive's "+
+			"list of files",
+	)
+	RegisterIndicatorFactory(
+		archiveIndicatorKey, newArchiveIndicatorFromSpec,
+		"parameters: limit (default: 256m)",
+	)
+	RegisterIndicator(
+		decompressedIndicatorName, DecompressedIndicator,
+		"decomp.sha256: the SHA-256 of the decompressed contents "+
+			"of gzip, bzip2 or zlib data",
+	)
+	RegisterIndicatorFactory(
+		decompressedIndicatorName, newDecompressedIndicatorFromSpec,
+		"parameters: algo (default: sha256), limit (default: 4g)",
+	)
+	RegisterIndicator(
+		documentTextIndicatorKey, DocumentTextIndicator,
+		"doctext: the SHA-256 of the text of PDF, docx, xlsx or "+
+			"pptx documents with its whitespace normalized",
+	)
+	RegisterIndicatorFactory(
+		documentTextIndicatorKey, newDocumentTextIndicatorFromSpec,
+		"parameters: algo (default: sha256), limit (default: 256m)",
+	)
+	RegisterIndicator(
+		lineSetIndicatorKey, LineSetIndicator,
+		"lineset: a hash of the lines of text data that "+
+			"doesn't depend on their order",
+	)
+	RegisterIndicator(
+		minHashIndicatorKey, MinHashIndicator,
+		"minhash: a MinHash signature of the three-word "+
+			"shingles of text to find near-duplicate "+
+			"documents",
+	)
+	RegisterIndicator(
+		ssdeepIndicatorKey, SSDeepIndicator,
+		"ssdeep: an ssdeep-compatible context triggered "+
+			"piecewise hash to find partly changed files",
+	)
+	RegisterIndicator(
+		audioIndicatorKey, AudioIndicator,
+		"audio: a fingerprint of WAV or FLAC audio that "+
+			"doesn't depend on its bit depth or tags",
+	)
+	RegisterIndicator(
+		payloadIndicatorName, PayloadIndicator,
+		"payload-sha256: the SHA-256 of JPEG, PNG or MP3 data "+
+			"without its EXIF, XMP, ID3 or other tags",
+	)
+	RegisterIndicatorFactory(
+		payloadIndicatorName, newPayloadIndicatorFromSpec,
+		"parameters: algo (default: sha256)",
+	)
+	RegisterIndicator(
+		exifIndicatorName, ExifIndicator,
+		"exif.make, exif.model, exif.datetime, exif.width, "+
+			"exif.height, exif.orientation: the camera, "+
+			"capture time, dimensions and orientation of a "+
+			"JPEG or TIFF photo",
+	)
+	RegisterIndicator(
+		imageHashIndicatorKey, ImageHashIndicator,
+		"phash: a perceptual hash of a JPEG, PNG or GIF image",
+	)
+	RegisterIndicatorFactory(
+		imageHashIndicatorKey, newImageHashIndicatorFromSpec,
+		"parameters: algo (ahash, dhash or phash; default: phash), "+
+			"pixels (default: 64m)",
+	)
+}
+
+// RegisterIndicator makes an Indicator available by name to
+// ParseIndicator and NewIndicator.  The description should say what
+// keys the indicator writes and what they mean.  If RegisterIndicator
+// is called twice with the same name or if ir is nil, it panics.
+func RegisterIndicator(name string, ir Indicator, description string) {
+	if ir == nil {
+		panic("uniquefile: RegisterIndicator indicator is nil")
+	}
+	registerIndicator(name, func(ri *registeredIndicator) bool {
+		if ri.ir != nil {
+			return false
+		}
+		ri.ir = ir
+		return true
+	}, description)
+}
+
+// RegisterIndicatorFactory makes an IndicatorFactory available by name
+// to ParseIndicator and NewIndicator so that the indicator can be
+// created from a spec with parameters.  A name can have both an
+// Indicator and an IndicatorFactory registered for it, in which case
+// the Indicator is used when the spec has no parameters.  If
+// RegisterIndicatorFactory is called twice with the same name or if
+// factory is nil, it panics.
+func RegisterIndicatorFactory(name string, factory IndicatorFactory, description string) {
+	if factory == nil {
+		panic("uniquefile: RegisterIndicatorFactory factory is nil")
+	}
+	registerIndicator(name, func(ri *registeredIndicator) bool {
+		if ri.factory != nil {
+			return false
+		}
+		ri.factory = factory
+		return true
+	}, description)
+}
+
+func registerIndicator(name string, set func(ri *registeredIndicator) bool, description string) {
+	key := strings.TrimSpace(strings.ToLower(name))
+	if key == "" || strings.ContainsAny(key, ":,=") {
+		panic("uniquefile: invalid indicator name " + strconv.Quote(name))
+	}
+	indicatorsMu.Lock()
+	defer indicatorsMu.Unlock()
+	ri, ok := indicators[key]
+	if !ok {
+		ri = &registeredIndicator{}
+		indicators[key] = ri
+	}
+	if !set(ri) {
+		panic("uniquefile: indicator registered twice: " + name)
+	}
+	if ri.description == "" {
+		ri.description = description
+	} else if description != "" {
+		ri.description += "; " + description
+	}
+}
+
+// unregisterIndicator removes the Indicator and IndicatorFactory
+// registered under name.  It is only used by tests to clean up after
+// themselves.
+func unregisterIndicator(name string) {
+	indicatorsMu.Lock()
+	defer indicatorsMu.Unlock()
+	delete(indicators, strings.TrimSpace(strings.ToLower(name)))
+}
+
+// IndicatorInfo describes a registered indicator.
+type IndicatorInfo struct {
+	// Name is the name the indicator was registered under.
+	Name string
+
+	// Description describes the keys the indicator writes and, if
+	// it has one, the parameters its factory accepts.
+	Description string
+
+	// Parameterized is true if the indicator has a factory that
+	// accepts parameters.
+	Parameterized bool
+}
+
+// ListIndicators lists the registered indicators sorted by their
+// names.
+func ListIndicators() []IndicatorInfo {
+	indicatorsMu.RLock()
+	defer indicatorsMu.RUnlock()
+	infos := make([]IndicatorInfo, 0, len(indicators))
+	for name, ri := range indicators {
+		infos = append(infos, IndicatorInfo{
+			Name:          name,
+			Description:   ri.description,
+			Parameterized: ri.factory != nil,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// hashers are the hash algorithms that can be selected by name from
+// an IndicatorSpec's parameters.
+var hashers = map[string]func() hash.Hash{
+	crc32Key:   func() hash.Hash { return crc32.NewIEEE() },
+	sha256Key:  sha256.New,
+	md5Key:     md5.New,
+	sha1Key:    sha1.New,
+	sha512Key:  sha512.New,
+	xxh64Key:   newXXH64,
+	blake2bKey: newBLAKE2b,
 }
 
-// ParseIndicator parses an indicator by its key
+// ParseIndicator parses an indicator by its key or by an IndicatorSpec
+// (see ParseIndicatorSpec).
 func ParseIndicator(s string) (Indicator, bool) {
+	spec, err := ParseIndicatorSpec(s)
+	if err != nil {
+		return nil, false
+	}
+	ir, err := NewIndicator(spec)
+	return ir, err == nil
+}
+
+// IndicatorSpec is the name of an Indicator and the parameters it
+// should be created with.
+type IndicatorSpec struct {
+	Name   string
+	Params map[string]string
+}
+
+// ParseIndicatorSpec parses a spec in the form of name or
+// name:key=value,key=value (e.g. "sha256" or
+// "headtail:size=64k,algo=xxh64").  Names and keys are
+// case-insensitive.
+func ParseIndicatorSpec(s string) (spec IndicatorSpec, err error) {
 	s = strings.TrimSpace(strings.ToLower(s))
-	ir, ok := indicators[s]
-	return ir, ok
+	name, params := s, ""
+	if i := strings.IndexByte(s, ':'); i != -1 {
+		name, params = strings.TrimSpace(s[:i]), s[i+1:]
+	}
+	if name == "" {
+		return spec, errors.Errorf("missing indicator name in %q", s)
+	}
+	spec.Name = name
+	if params == "" {
+		return spec, nil
+	}
+	spec.Params = make(map[string]string)
+	for _, param := range strings.Split(params, ",") {
+		i := strings.IndexByte(param, '=')
+		if i == -1 {
+			return spec, errors.Errorf(
+				"indicator parameter %q is not in the "+
+					"form key=value", param,
+			)
+		}
+		k := strings.TrimSpace(param[:i])
+		v := strings.TrimSpace(param[i+1:])
+		if _, ok := spec.Params[k]; ok {
+			return spec, errors.Errorf(
+				"duplicate indicator parameter %q", k,
+			)
+		}
+		spec.Params[k] = v
+	}
+	return spec, nil
+}
+
+// NewIndicator creates an Indicator from its spec.
+func NewIndicator(spec IndicatorSpec) (Indicator, error) {
+	indicatorsMu.RLock()
+	ri, ok := indicators[spec.Name]
+	indicatorsMu.RUnlock()
+	switch {
+	case !ok:
+		return nil, errors.Errorf("no such indicator: %q", spec.Name)
+	case len(spec.Params) == 0 && ri.ir != nil:
+		return ri.ir, nil
+	case ri.factory != nil:
+		return ri.factory(spec)
+	}
+	return nil, errors.Errorf(
+		"indicator %q does not accept parameters",
+		spec.Name,
+	)
+}
+
+// String formats the spec so that it can be parsed by
+// ParseIndicatorSpec.  Parameters are sorted by their keys.
+func (spec IndicatorSpec) String() string {
+	if len(spec.Params) == 0 {
+		return spec.Name
+	}
+	keys := make([]string, 0, len(spec.Params))
+	for k := range spec.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sb := strings.Builder{}
+	sb.WriteString(spec.Name)
+	for i, k := range keys {
+		if i == 0 {
+			sb.WriteByte(':')
+		} else {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(spec.Params[k])
+	}
+	return sb.String()
+}
+
+// CheckParams returns an error if the spec has any parameters other
+// than those named.
+func (spec IndicatorSpec) CheckParams(names ...string) error {
+	for k := range spec.Params {
+		found := false
+		for _, name := range names {
+			if k == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.Errorf(
+				"indicator %q has no parameter %q",
+				spec.Name, k,
+			)
+		}
+	}
+	return nil
+}
+
+// Size gets a size parameter by its name.  Sizes are a number of bytes
+// optionally followed by a unit such as k, KiB, M, MiB, G or GiB.  All
+// units are powers of 1024.  If the parameter isn't in the spec, def
+// is returned.
+func (spec IndicatorSpec) Size(name string, def int64) (int64, error) {
+	v, ok := spec.Params[name]
+	if !ok {
+		return def, nil
+	}
+	n, err := parseSize(v)
+	if err != nil {
+		return 0, errors.ErrorfWithCause(
+			err, "indicator %q parameter %q",
+			spec.Name, name,
+		)
+	}
+	return n, nil
+}
+
+// Hash gets a hash algorithm parameter by its name.  If the parameter
+// isn't in the spec, the def algorithm is returned.
+func (spec IndicatorSpec) Hash(name, def string) (algo string, hasher func() hash.Hash, err error) {
+	algo = def
+	if v, ok := spec.Params[name]; ok {
+		algo = v
+	}
+	hasher, ok := hashers[algo]
+	if !ok {
+		return "", nil, errors.Errorf(
+			"indicator %q parameter %q: unknown hash "+
+				"algorithm %q",
+			spec.Name, name, algo,
+		)
+	}
+	return algo, hasher, nil
+}
+
+// Bool gets a boolean parameter by its name.  Values are parsed with
+// strconv.ParseBool.  If the parameter isn't in the spec, def is
+// returned.
+func (spec IndicatorSpec) Bool(name string, def bool) (bool, error) {
+	v, ok := spec.Params[name]
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.Errorf(
+			"indicator %q parameter %q: invalid boolean %q",
+			spec.Name, name, v,
+		)
+	}
+	return b, nil
+}
+
+func parseSize(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+	n, err := strconv.ParseInt(s[:i], 10, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+	var shift uint
+	switch strings.TrimSpace(s[i:]) {
+	case "", "b":
+	case "k", "kb", "kib":
+		shift = 10
+	case "m", "mb", "mib":
+		shift = 20
+	case "g", "gb", "gib":
+		shift = 30
+	default:
+		return 0, errors.Errorf("invalid size unit in %q", s)
+	}
+	if n <= 0 || n > math.MaxInt64>>shift {
+		return 0, errors.Errorf("size %q is out of range", s)
+	}
+	return n << shift, nil
 }
 
 // IndicatorCmper can be implemented by Indicators to compare
@@ -78,13 +555,40 @@ func NewIndication() *Indication {
 // PutIndication puts an Indication back into the cache so it can be
 // reused.
 func PutIndication(i **Indication) {
+	(*i).Reset()
 	indicationCache.Put(*i)
 	*i = nil
 }
 
+// ParseIndication creates an Indication from the bytes of another
+// Indication (see Bytes) after validating them (see Validate).  The
+// Indication does not refer to data after ParseIndication returns.
+func ParseIndication(data []byte) (*Indication, error) {
+	if err := (&Indication{buf: data}).Validate(); err != nil {
+		return nil, err
+	}
+	ind := NewIndication()
+	ind.buf = append(ind.buf, data...)
+	return ind, nil
+}
+
 // Bytes accesses the byte representation of the indication directly.
 func (i *Indication) Bytes() []byte { return i.buf }
 
+// Validate checks that the indication's bytes can be parsed into keys
+// and values.  If they can't, the error is an *IndicationError.
+func (i *Indication) Validate() error {
+	r := indicationReader{ind: i}
+	for {
+		if _, _, err := r.Next(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
 func (i *Indication) Each(fn func(key, value []byte) error) error {
 	r := i.Reader()
 	for {
@@ -123,16 +627,430 @@ func (i *Indication) Reader() (reader interface {
 // while you have a reader over the indication.
 func (i *Indication) Reset() { i.buf = i.buf[:0] }
 
-// Write writes a key and value into the indication.
+// Write writes a key and value into the indication.  If the key is
+// already in the indication, its value is replaced.
 func (i *Indication) Write(key, value []byte) {
-	writeSlice := func(i *Indication, bs []byte) {
-		var buf [binary.MaxVarintLen64]byte
-		n := binary.PutUvarint(buf[:], uint64(len(bs)))
-		i.buf = append(i.buf, buf[:n]...)
-		i.buf = append(i.buf, bs...)
+	start, end, ok := i.find(key)
+	if !ok {
+		i.buf = appendIndicationSlice(i.buf, key)
+		i.buf = appendIndicationSlice(i.buf, value)
+		return
 	}
-	writeSlice(i, key)
-	writeSlice(i, value)
+	// key and value might refer to i.buf, so the replacement is
+	// built into a new buffer.
+	buf := make([]byte, 0, len(i.buf)-(end-start)+len(key)+len(value)+2*binary.MaxVarintLen64)
+	buf = append(buf, i.buf[:start]...)
+	buf = appendIndicationSlice(buf, key)
+	buf = appendIndicationSlice(buf, value)
+	i.buf = append(buf, i.buf[end:]...)
+}
+
+// find finds where a key and its value start and end in the
+// indication's bytes.
+func (i *Indication) find(key []byte) (start, end int, ok bool) {
+	r := indicationReader{ind: i}
+	for {
+		start = r.idx
+		k, _, err := r.Next()
+		if err != nil {
+			return 0, 0, false
+		}
+		if bytes.Equal(k, key) {
+			return start, r.idx, true
+		}
+	}
+}
+
+// Get gets the value of a key.  The value refers to the indication's
+// bytes, so it must not be used after the indication is written into,
+// reset or put back with PutIndication.
+func (i *Indication) Get(key []byte) (value []byte, ok bool) {
+	r := indicationReader{ind: i}
+	for {
+		k, v, err := r.Next()
+		if err != nil {
+			return nil, false
+		}
+		if bytes.Equal(k, key) {
+			return v, true
+		}
+	}
+}
+
+// Has checks if the indication has a key.
+func (i *Indication) Has(key []byte) bool {
+	_, ok := i.Get(key)
+	return ok
+}
+
+// Keys returns the indication's keys in the order they were written.
+func (i *Indication) Keys() ([]Bytes, error) {
+	var keys []Bytes
+	err := i.Each(func(key, value []byte) error {
+		keys = append(keys, Bytes(key))
+		return nil
+	})
+	return keys, err
+}
+
+// Delete removes a key and its value from the indication.  It reports
+// whether the key was in the indication.  Do not call this while you
+// have a reader over the indication.
+func (i *Indication) Delete(key []byte) bool {
+	start, end, ok := i.find(key)
+	if !ok {
+		return false
+	}
+	i.buf = append(i.buf[:start], i.buf[end:]...)
+	return true
+}
+
+// Clone creates a copy of the indication that doesn't share any of
+// its bytes, so it can still be used after the original is put back
+// with PutIndication.
+func (i *Indication) Clone() *Indication {
+	c := NewIndication()
+	c.buf = append(c.buf, i.buf...)
+	return c
+}
+
+// SubsetOf checks if every key in the indication is in other with the
+// same value.
+func (i *Indication) SubsetOf(other *Indication) bool {
+	err := i.Each(func(key, value []byte) error {
+		if v, ok := other.Get(key); !ok || !bytes.Equal(v, value) {
+			return errNotSubset
+		}
+		return nil
+	})
+	return err == nil
+}
+
+var errNotSubset = errors.New("indication is not a subset")
+
+// Equal checks if the indications have the same keys and values,
+// regardless of the order they were written in.
+func (i *Indication) Equal(other *Indication) bool {
+	return i.SubsetOf(other) && other.SubsetOf(i)
+}
+
+// MergePolicy selects what Merge does with keys that are in both
+// indications with different values.
+type MergePolicy int
+
+const (
+	// MergeKeep keeps the indication's own value.
+	MergeKeep MergePolicy = iota
+
+	// MergeReplace replaces the indication's value with the other
+	// indication's value.
+	MergeReplace
+
+	// MergeFail makes Merge return a *MergeConflictError without
+	// merging anything.
+	MergeFail
+)
+
+// MergeConflictError is returned by Merge with the MergeFail policy
+// when both indications have a key with different values.
+type MergeConflictError struct {
+	Key Bytes
+}
+
+func (e *MergeConflictError) Error() string {
+	return "indications have different values for key " +
+		strconv.Quote(string(e.Key))
+}
+
+// Merge writes the keys and values from other into the indication.
+// Keys that are only in other are added and keys that are in both
+// with different values are handled according to the policy.  If
+// other's bytes are invalid (see Validate), nothing is merged.
+func (i *Indication) Merge(other *Indication, policy MergePolicy) error {
+	if policy < MergeKeep || policy > MergeFail {
+		return errors.Errorf("invalid merge policy: %d", policy)
+	}
+	if other == i {
+		return nil
+	}
+	if err := other.Validate(); err != nil {
+		return err
+	}
+	if policy == MergeFail {
+		if err := other.Each(func(key, value []byte) error {
+			if v, ok := i.Get(key); ok && !bytes.Equal(v, value) {
+				return &MergeConflictError{Key: Bytes(key)}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return other.Each(func(key, value []byte) error {
+		if policy == MergeKeep && i.Has(key) {
+			return nil
+		}
+		i.Write(key, value)
+		return nil
+	})
+}
+
+// appendIndicationSlice appends the length of bs as a varint followed
+// by bs itself.
+func appendIndicationSlice(buf, bs []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(bs)))]...)
+	return append(buf, bs...)
+}
+
+// indicationFormatVersion is the version of the encoding written by
+// MarshalBinary.
+const indicationFormatVersion = 1
+
+var (
+	_ encoding.BinaryMarshaler   = (*Indication)(nil)
+	_ encoding.BinaryUnmarshaler = (*Indication)(nil)
+)
+
+// MarshalBinary encodes the indication as a format version byte
+// followed by its keys and values sorted by their keys (and by their
+// values if an indication parsed from bytes has a key more than once).
+// Indications with the same keys and values are encoded into the same
+// bytes no matter what order they were written in, so their encodings
+// can be stored and compared directly.
+func (i *Indication) MarshalBinary() ([]byte, error) {
+	type kvp struct{ key, value []byte }
+	var kvps []kvp
+	err := i.Each(func(key, value []byte) error {
+		kvps = append(kvps, kvp{key, value})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(kvps, func(a, b int) bool {
+		if c := bytes.Compare(kvps[a].key, kvps[b].key); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(kvps[a].value, kvps[b].value) < 0
+	})
+	data := make([]byte, 1, 1+len(i.buf))
+	data[0] = indicationFormatVersion
+	for _, kv := range kvps {
+		data = appendIndicationSlice(data, kv.key)
+		data = appendIndicationSlice(data, kv.value)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary replaces the indication's keys and values with those
+// decoded from data that was encoded by MarshalBinary.  data must be
+// in the canonical form that MarshalBinary writes:  Its keys must be
+// sorted and its lengths must not have any extra bytes.  The
+// indication does not refer to data after UnmarshalBinary returns.
+func (i *Indication) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("missing indication format version")
+	}
+	if data[0] != indicationFormatVersion {
+		return errors.Errorf(
+			"unsupported indication format version: %d",
+			data[0],
+		)
+	}
+	if err := (&Indication{buf: data[1:]}).Validate(); err != nil {
+		if ie, ok := err.(*IndicationError); ok {
+			// make the offset relative to data:
+			ie.Offset++
+		}
+		return err
+	}
+	canonical, err := (&Indication{buf: data[1:]}).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(canonical, data) {
+		return errors.New("indication data is not canonical")
+	}
+	i.buf = append(i.buf[:0], data[1:]...)
+	return nil
+}
+
+// indicationJSONKind is how the values of a key are represented in
+// JSON.
+type indicationJSONKind int
+
+const (
+	// indicationJSONHex values are strings of the hexadecimal
+	// digits of their bytes.  Keys that aren't in
+	// indicationJSONKinds, such as hashes, are in hex.
+	indicationJSONHex indicationJSONKind = iota
+
+	// indicationJSONUint values are big endian 64-bit integers that
+	// are represented as numbers.
+	indicationJSONUint
+
+	// indicationJSONString values are text that is represented as
+	// strings.  Values that aren't valid UTF-8 are represented as
+	// an object with their hex in its "hex" field instead because
+	// a JSON string would replace their invalid bytes.
+	indicationJSONString
+)
+
+// indicationJSONKinds are the keys whose values are not represented in
+// hex.
+var indicationJSONKinds = map[string]indicationJSONKind{
+	lengthIndicatorKey:   indicationJSONUint,
+	exifWidthKey:         indicationJSONUint,
+	exifHeightKey:        indicationJSONUint,
+	exifOrientationKey:   indicationJSONUint,
+	mimeTypeIndicatorKey: indicationJSONString,
+	exifMakeKey:          indicationJSONString,
+	exifModelKey:         indicationJSONString,
+	exifDateTimeKey:      indicationJSONString,
+	ssdeepIndicatorKey:   indicationJSONString,
+}
+
+var (
+	_ json.Marshaler   = (*Indication)(nil)
+	_ json.Unmarshaler = (*Indication)(nil)
+)
+
+// MarshalJSON encodes the indication as a JSON object of its keys and
+// values sorted by key.  How a value is represented depends on its
+// key:  Lengths and image dimensions are numbers, media types, EXIF
+// text and ssdeep digests are strings and everything else, such as
+// hashes, is a string of hexadecimal digits.  Numbers whose values
+// aren't 8 bytes long are written in hex instead and text that isn't
+// valid UTF-8 is written as an object like {"hex":"43616de97261"}.
+func (i *Indication) MarshalJSON() ([]byte, error) {
+	data, err := i.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	sorted := Indication{buf: data[1:]}
+	buf := bytes.Buffer{}
+	buf.WriteByte('{')
+	err = sorted.Each(func(key, value []byte) error {
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(string(key))
+		if err != nil {
+			return err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		switch indicationJSONKinds[string(key)] {
+		case indicationJSONUint:
+			if len(value) == 8 {
+				buf.WriteString(strconv.FormatUint(byteOrder.Uint64(value), 10))
+				return nil
+			}
+		case indicationJSONString:
+			if !utf8.Valid(value) {
+				buf.WriteString(`{"hex":"`)
+				buf.WriteString(hex.EncodeToString(value))
+				buf.WriteString(`"}`)
+				return nil
+			}
+			v, err := json.Marshal(string(value))
+			if err != nil {
+				return err
+			}
+			buf.Write(v)
+			return nil
+		}
+		buf.WriteByte('"')
+		buf.WriteString(hex.EncodeToString(value))
+		buf.WriteByte('"')
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON replaces the indication's keys and values with those
+// decoded from a JSON object written by MarshalJSON.
+func (i *Indication) UnmarshalJSON(data []byte) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if tok, err := d.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return errors.Errorf("indication must be a JSON object, not %v", tok)
+	}
+	decoded := Indication{}
+	for d.More() {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+		var raw interface{}
+		if err = d.Decode(&raw); err != nil {
+			return err
+		}
+		var value []byte
+		switch raw := raw.(type) {
+		case json.Number:
+			if indicationJSONKinds[key] != indicationJSONUint {
+				return errors.Errorf(
+					"indication key %q cannot have a "+
+						"number value", key,
+				)
+			}
+			n, err := strconv.ParseUint(raw.String(), 10, 64)
+			if err != nil {
+				return errors.ErrorfWithCause(
+					err, "invalid indication key %q "+
+						"value", key,
+				)
+			}
+			value = make([]byte, 8)
+			byteOrder.PutUint64(value, n)
+		case string:
+			if indicationJSONKinds[key] == indicationJSONString {
+				value = []byte(raw)
+				break
+			}
+			if value, err = hex.DecodeString(raw); err != nil {
+				return errors.ErrorfWithCause(
+					err, "invalid indication key %q "+
+						"value", key,
+				)
+			}
+		case map[string]interface{}:
+			h, ok := raw["hex"].(string)
+			if indicationJSONKinds[key] != indicationJSONString || len(raw) != 1 || !ok {
+				return errors.Errorf(
+					"indication key %q cannot have an "+
+						"object value: %v", key, raw,
+				)
+			}
+			if value, err = hex.DecodeString(h); err != nil {
+				return errors.ErrorfWithCause(
+					err, "invalid indication key %q "+
+						"value", key,
+				)
+			}
+		default:
+			return errors.Errorf(
+				"indication key %q value must be a number "+
+					"or a string, not %v",
+				key, raw,
+			)
+		}
+		decoded.Write([]byte(key), value)
+	}
+	if _, err := d.Token(); err != nil {
+		return err
+	}
+	i.buf = append(i.buf[:0], decoded.buf...)
+	return nil
 }
 
 type IndicationLookup map[Bytes][]byte
@@ -143,43 +1061,92 @@ func (lu IndicationLookup) WriteToIndication(ind *Indication) {
 	}
 }
 
+var (
+	// ErrIndicationTruncated is the Err of an IndicationError when
+	// an Indication's bytes end partway through a length, key or
+	// value.
+	ErrIndicationTruncated = errors.New("indication is truncated")
+
+	// ErrIndicationLength is the Err of an IndicationError when a
+	// length in an Indication's bytes is too large to be a length
+	// of a key or value.
+	ErrIndicationLength = errors.New("indication length is too large")
+
+	// ErrIndicationTrailing is the Err of an IndicationError when
+	// an Indication's bytes end with a key without a value.
+	ErrIndicationTrailing = errors.New("indication has a trailing key without a value")
+)
+
+// IndicationError is returned when an Indication's bytes cannot be
+// parsed into keys and values.
+type IndicationError struct {
+	// Offset is where the length of the key or value that could
+	// not be parsed starts in the Indication's bytes.
+	Offset int
+
+	// Err is ErrIndicationTruncated, ErrIndicationLength or
+	// ErrIndicationTrailing.
+	Err error
+}
+
+func (e *IndicationError) Error() string {
+	return "invalid indication at offset " + strconv.Itoa(e.Offset) +
+		": " + e.Err.Error()
+}
+
+// Unwrap returns e.Err.
+func (e *IndicationError) Unwrap() error { return e.Err }
+
 type indicationReader struct {
 	ind *Indication
 	idx int
-}
-
-var _ io.ByteReader = (*indicationReader)(nil)
 
-func (r *indicationReader) ReadByte() (byte, error) {
-	if r.idx >= len(r.ind.buf) {
-		return 0, io.EOF
-	}
-	b := r.ind.buf[r.idx]
-	r.idx++
-	return b, nil
+	// err is returned by every call to Next after the first that
+	// fails.
+	err error
 }
 
 func (r *indicationReader) Next() (key, value []byte, err error) {
+	if r.err != nil {
+		return nil, nil, r.err
+	}
 	if r.idx == len(r.ind.buf) {
 		return nil, nil, io.EOF
 	}
-	readSlice := func(r *indicationReader) (bs []byte, err error) {
-		length, err := binary.ReadUvarint(r)
-		if err != nil {
-			return nil, err
+	start := r.idx
+	if key, err = r.readSlice(); err == nil {
+		if r.idx == len(r.ind.buf) {
+			err = &IndicationError{Offset: start, Err: ErrIndicationTrailing}
+		} else {
+			value, err = r.readSlice()
 		}
-		bs = r.ind.buf[r.idx : r.idx+int(length)]
-		r.idx += int(length)
-		return
 	}
-	key, err = readSlice(r)
 	if err != nil {
-		return
+		r.err = err
+		return nil, nil, err
+	}
+	return key, value, nil
+}
+
+// readSlice reads a length and then that many bytes.
+func (r *indicationReader) readSlice() ([]byte, error) {
+	rest := r.ind.buf[r.idx:]
+	length, n := binary.Uvarint(rest)
+	switch {
+	case n == 0:
+		return nil, &IndicationError{Offset: r.idx, Err: ErrIndicationTruncated}
+	case n < 0 || length > uint64(maxInt):
+		return nil, &IndicationError{Offset: r.idx, Err: ErrIndicationLength}
+	case int(length) > len(rest)-n:
+		return nil, &IndicationError{Offset: r.idx, Err: ErrIndicationTruncated}
 	}
-	value, err = readSlice(r)
-	return
+	r.idx += n + int(length)
+	return rest[n : n+int(length) : n+int(length)], nil
 }
 
+// maxInt is the largest int.
+const maxInt = int(^uint(0) >> 1)
+
 type Indicators struct {
 	reqs []chan *indicatorReq
 	irs  []Indicator
@@ -258,27 +1225,14 @@ func (irs *Indicators) Indicate(ctx context.Context, r io.Reader, ind *Indicatio
 	}
 	wg1.Wait()
 	var errs error
-	visited := map[Bytes]struct{}{}
 	for _, req := range reqs {
 		if req.err != nil {
 			errs = errors.CreateError(req.err, nil, errs, 0)
 			continue
 		}
-		r := req.ind.Reader()
-		for {
-			key, value, err := r.Next()
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				errs = errors.CreateError(err, nil, errs, 0)
-			}
-			bk := Bytes(key)
-			if _, ok := visited[bk]; ok {
-				continue
-			}
-			visited[bk] = struct{}{}
-			ind.Write(key, value)
+		// the first indicator to write a key wins:
+		if err := ind.Merge(req.ind, MergeKeep); err != nil {
+			errs = errors.CreateError(err, nil, errs, 0)
 		}
 	}
 	for _, req := range reqs {
@@ -290,7 +1244,7 @@ func (irs *Indicators) Indicate(ctx context.Context, r io.Reader, ind *Indicatio
 const crc32Key = "crc32"
 
 // CRC32Indicator computes the CRC32 of its data
-var CRC32Indicator Indicator = hashAndLengthIndicator{
+var CRC32Indicator HashIndicator = hashAndLengthIndicator{
 	hasher: func() hash.Hash { return crc32.NewIEEE() },
 	key:    crc32Key,
 }
@@ -298,29 +1252,601 @@ var CRC32Indicator Indicator = hashAndLengthIndicator{
 const sha256Key = "sha256"
 
 // SHA256Indicator computes the SHA-256 of its data
-var SHA256Indicator Indicator = hashAndLengthIndicator{
+var SHA256Indicator HashIndicator = hashAndLengthIndicator{
 	hasher: sha256.New,
 	key:    sha256Key,
 }
 
+const md5Key = "md5"
+
+// MD5Indicator computes the MD5 of its data.  MD5 is broken as a
+// cryptographic hash, so this is only useful to match against
+// existing manifests and hash sets.
+var MD5Indicator HashIndicator = hashAndLengthIndicator{
+	hasher: md5.New,
+	key:    md5Key,
+}
+
+const sha1Key = "sha1"
+
+// SHA1Indicator computes the SHA-1 of its data.  Like MD5, it is
+// mostly useful to match against existing manifests and hash sets.
+var SHA1Indicator HashIndicator = hashAndLengthIndicator{
+	hasher: sha1.New,
+	key:    sha1Key,
+}
+
+const sha512Key = "sha512"
+
+// SHA512Indicator computes the SHA-512 of its data
+var SHA512Indicator HashIndicator = hashAndLengthIndicator{
+	hasher: sha512.New,
+	key:    sha512Key,
+}
+
+const xxh64Key = "xxh64"
+
+// XXH64Indicator computes the 64-bit xxHash of its data.  It is not a
+// cryptographic hash but it is much faster than the others, so it
+// makes a good first pass.
+var XXH64Indicator HashIndicator = hashAndLengthIndicator{
+	hasher: newXXH64,
+	key:    xxh64Key,
+}
+
+func newXXH64() hash.Hash { return xxhash.New() }
+
+const blake2bKey = "blake2b"
+
+// BLAKE2bIndicator computes the 512-bit BLAKE2b of its data
+var BLAKE2bIndicator HashIndicator = hashAndLengthIndicator{
+	hasher: newBLAKE2b,
+	key:    blake2bKey,
+}
+
+func newBLAKE2b() hash.Hash {
+	// New512 only fails if the key is too long.
+	h, err := blake2b.New512(nil)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// HashIndicator is an Indicator that writes the length of its data and
+// a hash of it.  NewMultiHashIndicator can compute the hashes of any
+// number of HashIndicators from a single read of the data.
+type HashIndicator interface {
+	Indicator
+	IndicatorCmper
+
+	// HashKey is the key that the hash is written under.
+	HashKey() string
+
+	// NewHash creates the hash.Hash that the data is written into.
+	NewHash() hash.Hash
+}
+
 type hashAndLengthIndicator struct {
 	hasher func() hash.Hash
 	key    string
 }
 
+func (ir hashAndLengthIndicator) Keys() []Bytes {
+	return []Bytes{lengthIndicatorKey, Bytes(ir.key)}
+}
+
+// Cmp compares the lengths like LengthIndicator or the hashes
+// byte-by-byte.  Hashes that compare greater than others have no
+// meaning other than that they are different.
+func (ir hashAndLengthIndicator) Cmp(ctx context.Context, key, a, b []byte) (int, error) {
+	switch string(key) {
+	case lengthIndicatorKey:
+		return LengthIndicator.Cmp(ctx, key, a, b)
+	case ir.key:
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		return bytes.Compare(a, b), nil
+	}
+	return 0, ErrCannotCmp
+}
+
+func (ir hashAndLengthIndicator) HashKey() string { return ir.key }
+
+func (ir hashAndLengthIndicator) NewHash() hash.Hash { return ir.hasher() }
+
 func (ir hashAndLengthIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
 	h := ir.hasher()
-	length, err := copyContext(ctx, h, r, nil)
+	bp := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(bp)
+	length, err := copyContext(ctx, h, r, *bp)
 	if err != nil {
 		return err
 	}
-	var buf [32]byte
+	var buf [64]byte
 	byteOrder.PutUint64(buf[:], uint64(length))
 	ind.Write([]byte(lengthIndicatorKey), buf[:8])
 	ind.Write([]byte(ir.key), h.Sum(buf[:0]))
 	return nil
 }
 
+const textHashIndicatorKey = "text"
+
+// TextNormalization selects how text is normalized by the
+// TextHashIndicator before it is hashed.
+type TextNormalization struct {
+	// LineEndings replaces CRLF and CR line endings with LF.
+	LineEndings bool
+
+	// TrailingSpace removes spaces and tabs from the ends of lines.
+	TrailingSpace bool
+
+	// BOM removes a UTF-8 byte order mark from the beginning of
+	// the text.
+	BOM bool
+}
+
+// TextHashIndicator computes the SHA-256 of text data after normalizing
+// all of its line endings, trailing whitespace and byte order mark
+// (see NewTextHashIndicator).
+var TextHashIndicator interface {
+	Indicator
+	IndicatorCmper
+} = newTextHashIndicator(
+	TextNormalization{LineEndings: true, TrailingSpace: true, BOM: true},
+	sha256Key, sha256.New,
+)
+
+// NewTextHashIndicator creates an Indicator that normalizes text data
+// and hashes it with the given algorithm so that copies of a text file
+// that only differ in their line endings, trailing whitespace or byte
+// order mark have the same hash.  The hash is written under the "text"
+// key if all of the normalizations are used, or under "text-" followed
+// by "l", "s" and "b" for each of the line ending, trailing space and
+// BOM normalizations that are used.  A "." and the algorithm is
+// appended to the key if it isn't sha256 (e.g. "text-ls.xxh64").
+//
+// Data that contains a NUL byte is considered binary and nothing is
+// written for it.
+func NewTextHashIndicator(norm TextNormalization, algo string) (interface {
+	Indicator
+	IndicatorCmper
+}, error) {
+	hasher, ok := hashers[algo]
+	if !ok {
+		return nil, errors.Errorf("unknown hash algorithm: %q", algo)
+	}
+	return newTextHashIndicator(norm, algo, hasher), nil
+}
+
+func newTextHashIndicatorFromSpec(spec IndicatorSpec) (Indicator, error) {
+	if err := spec.CheckParams("eol", "space", "bom", "algo"); err != nil {
+		return nil, err
+	}
+	var norm TextNormalization
+	var err error
+	if norm.LineEndings, err = spec.Bool("eol", true); err != nil {
+		return nil, err
+	}
+	if norm.TrailingSpace, err = spec.Bool("space", true); err != nil {
+		return nil, err
+	}
+	if norm.BOM, err = spec.Bool("bom", true); err != nil {
+		return nil, err
+	}
+	algo, hasher, err := spec.Hash("algo", sha256Key)
+	if err != nil {
+		return nil, err
+	}
+	return newTextHashIndicator(norm, algo, hasher), nil
+}
+
+func newTextHashIndicator(norm TextNormalization, algo string, hasher func() hash.Hash) textHashIndicator {
+	key := textHashIndicatorKey
+	if !(norm.LineEndings && norm.TrailingSpace && norm.BOM) {
+		key += "-"
+		if norm.LineEndings {
+			key += "l"
+		}
+		if norm.TrailingSpace {
+			key += "s"
+		}
+		if norm.BOM {
+			key += "b"
+		}
+	}
+	if algo != sha256Key {
+		key += "." + algo
+	}
+	return textHashIndicator{norm: norm, hasher: hasher, key: key}
+}
+
+type textHashIndicator struct {
+	norm   TextNormalization
+	hasher func() hash.Hash
+	key    string
+}
+
+func (ir textHashIndicator) Keys() []Bytes { return []Bytes{Bytes(ir.key)} }
+
+// Cmp compares the hashes byte-by-byte.
+func (ir textHashIndicator) Cmp(ctx context.Context, key, a, b []byte) (int, error) {
+	if string(key) != ir.key {
+		return 0, ErrCannotCmp
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return bytes.Compare(a, b), nil
+}
+
+func (ir textHashIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	h := ir.hasher()
+	tn := textNormalizer{norm: ir.norm, w: h}
+	bp := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(bp)
+	if _, err := copyContext(ctx, &tn, r, *bp); err != nil {
+		if err == errBinaryText {
+			return nil
+		}
+		return err
+	}
+	if err := tn.Close(); err != nil {
+		return err
+	}
+	var buf [64]byte
+	ind.Write([]byte(ir.key), h.Sum(buf[:0]))
+	return nil
+}
+
+var errBinaryText = errors.New("data is binary, not text")
+
+var utf8BOM = [...]byte{0xEF, 0xBB, 0xBF}
+
+// textNormalizer normalizes the text written into it and writes the
+// result into w.  Close must be called after the last Write to flush
+// what it held back.
+type textNormalizer struct {
+	norm TextNormalization
+	w    io.Writer
+	out  []byte
+
+	// started is set after the beginning of the text was checked
+	// for a BOM.
+	started bool
+
+	// bom is how much of the BOM was matched at the beginning.
+	bom int
+
+	// cr is set when the last byte was a CR that might be the start
+	// of a CRLF.
+	cr bool
+
+	// space is the whitespace since the last character that will
+	// be dropped if the line ends before another character.
+	space []byte
+}
+
+func (tn *textNormalizer) Write(p []byte) (int, error) {
+	tn.out = tn.out[:0]
+	for _, c := range p {
+		if c == 0 {
+			return 0, errBinaryText
+		}
+		if !tn.started {
+			if tn.norm.BOM && c == utf8BOM[tn.bom] {
+				tn.bom++
+				tn.started = tn.bom == len(utf8BOM)
+				continue
+			}
+			tn.started = true
+			for _, b := range utf8BOM[:tn.bom] {
+				tn.byte(b)
+			}
+		}
+		tn.byte(c)
+	}
+	if _, err := tn.w.Write(tn.out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close flushes the normalized end of the text into w.
+func (tn *textNormalizer) Close() error {
+	tn.out = tn.out[:0]
+	if !tn.started {
+		tn.started = true
+		for _, b := range utf8BOM[:tn.bom] {
+			tn.byte(b)
+		}
+	}
+	if tn.cr {
+		tn.cr = false
+		tn.endLine("\r")
+	}
+	if !tn.norm.TrailingSpace {
+		tn.out = append(tn.out, tn.space...)
+	}
+	tn.space = tn.space[:0]
+	_, err := tn.w.Write(tn.out)
+	return err
+}
+
+func (tn *textNormalizer) byte(c byte) {
+	if tn.cr {
+		tn.cr = false
+		if c == '\n' {
+			tn.endLine("\r\n")
+			return
+		}
+		tn.endLine("\r")
+	}
+	switch c {
+	case '\r':
+		tn.cr = true
+	case '\n':
+		tn.endLine("\n")
+	case ' ', '\t':
+		if tn.norm.TrailingSpace {
+			tn.space = append(tn.space, c)
+			return
+		}
+		tn.out = append(tn.out, c)
+	default:
+		tn.out = append(tn.out, tn.space...)
+		tn.space = tn.space[:0]
+		tn.out = append(tn.out, c)
+	}
+}
+
+func (tn *textNormalizer) endLine(eol string) {
+	if !tn.norm.TrailingSpace {
+		tn.out = append(tn.out, tn.space...)
+	}
+	tn.space = tn.space[:0]
+	if tn.norm.LineEndings {
+		eol = "\n"
+	}
+	tn.out = append(tn.out, eol...)
+}
+
+// copyBufferSize is the size of the buffers that hashing indicators
+// read into.  It is much larger than io.Copy's default so that each
+// read and each write into the hashes handles more data.
+const copyBufferSize = 1 << 20
+
+var copyBuffers = sync.Pool{
+	New: func() interface{} {
+		bs := make([]byte, copyBufferSize)
+		return &bs
+	},
+}
+
+// NewMultiHashIndicator creates an Indicator that computes the hashes
+// of all of the HashIndicators from a single pass over the data.
+// Each buffer read from the data is written into every hash before
+// the next is read, so unlike NewIndicators, there are no pipes or
+// goroutines involved.  The length is written once, followed by each
+// of the hashes.
+func NewMultiHashIndicator(his ...HashIndicator) Indicator {
+	ir := multiHashIndicator{
+		keys:    make([]string, 0, len(his)),
+		hashers: make([]func() hash.Hash, 0, len(his)),
+	}
+	for _, hi := range his {
+		key := hi.HashKey()
+		found := false
+		for _, k := range ir.keys {
+			if k == key {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		ir.keys = append(ir.keys, key)
+		ir.hashers = append(ir.hashers, hi.NewHash)
+	}
+	return ir
+}
+
+type multiHashIndicator struct {
+	keys    []string
+	hashers []func() hash.Hash
+}
+
+func (ir multiHashIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	hs := make([]hash.Hash, len(ir.hashers))
+	ws := make([]io.Writer, len(ir.hashers))
+	for i, hasher := range ir.hashers {
+		hs[i] = hasher()
+		ws[i] = hs[i]
+	}
+	bp := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(bp)
+	length, err := copyContext(ctx, io.MultiWriter(ws...), r, *bp)
+	if err != nil {
+		return err
+	}
+	var buf [64]byte
+	byteOrder.PutUint64(buf[:], uint64(length))
+	ind.Write([]byte(lengthIndicatorKey), buf[:8])
+	for i, h := range hs {
+		ind.Write([]byte(ir.keys[i]), h.Sum(buf[:0]))
+	}
+	return nil
+}
+
+const headTailIndicatorKey = "headtail"
+
+// HeadTailIndicator computes the SHA-256 of the first and last 4KiB
+// of its data.
+var HeadTailIndicator Indicator = NewHeadTailIndicator(4 << 10)
+
+// NewHeadTailIndicator creates an Indicator that computes the SHA-256
+// of the first size bytes and the last size bytes of its data and
+// writes them under "head" and "tail" keys suffixed with the size
+// (e.g. "head4k" and "tail4k").  If the data is shorter than size,
+// both hashes are of the whole data.
+//
+// If the reader implements io.Seeker, the indicator seeks past the
+// middle of the data instead of reading through it.
+func NewHeadTailIndicator(size int64) Indicator {
+	return newHeadTailIndicator(size, sha256Key, sha256.New)
+}
+
+// newHeadTailIndicatorFromSpec creates a head/tail indicator from the
+// "size" and "algo" parameters of the spec.  The algorithm is
+// appended to the keys if it isn't SHA-256 (e.g. "head64k.crc32").
+func newHeadTailIndicatorFromSpec(spec IndicatorSpec) (Indicator, error) {
+	if err := spec.CheckParams("size", "algo"); err != nil {
+		return nil, err
+	}
+	size, err := spec.Size("size", 4<<10)
+	if err != nil {
+		return nil, err
+	}
+	algo, hasher, err := spec.Hash("algo", sha256Key)
+	if err != nil {
+		return nil, err
+	}
+	return newHeadTailIndicator(size, algo, hasher), nil
+}
+
+func newHeadTailIndicator(size int64, algo string, hasher func() hash.Hash) Indicator {
+	suffix := sizeSuffix(size)
+	if algo != sha256Key {
+		suffix += "." + algo
+	}
+	return headTailIndicator{
+		size:    size,
+		hasher:  hasher,
+		headKey: "head" + suffix,
+		tailKey: "tail" + suffix,
+	}
+}
+
+// sizeSuffix formats size with a "k" or "m" suffix if it is a whole
+// number of KiB or MiB.
+func sizeSuffix(size int64) string {
+	switch {
+	case size >= 1<<20 && size%(1<<20) == 0:
+		return strconv.FormatInt(size>>20, 10) + "m"
+	case size >= 1<<10 && size%(1<<10) == 0:
+		return strconv.FormatInt(size>>10, 10) + "k"
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+type headTailIndicator struct {
+	size    int64
+	hasher  func() hash.Hash
+	headKey string
+	tailKey string
+}
+
+func (ir headTailIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	head := make([]byte, ir.size)
+	n, err := io.ReadFull(readerContext{ctx, r}, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+	var tail []byte
+	if int64(n) < ir.size {
+		tail = head
+	} else if sk, ok := r.(io.Seeker); ok {
+		if tail, err = ir.seekTail(ctx, sk, int64(n)); err != nil {
+			return err
+		}
+	} else {
+		tw := tailWriter{buf: make([]byte, ir.size)}
+		tw.Write(head)
+		if _, err := copyContext(ctx, &tw, r, nil); err != nil {
+			return err
+		}
+		tail = tw.Bytes()
+	}
+	var buf [64]byte
+	h := ir.hasher()
+	h.Write(head)
+	ind.Write([]byte(ir.headKey), h.Sum(buf[:0]))
+	h.Reset()
+	h.Write(tail)
+	ind.Write([]byte(ir.tailKey), h.Sum(buf[:0]))
+	return nil
+}
+
+// seekTail reads the tail of sk after read bytes were already read
+// from it and then seeks back to where the indicator started reading.
+func (ir headTailIndicator) seekTail(ctx context.Context, sk io.Seeker, read int64) ([]byte, error) {
+	offset, err := sk.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	start := offset - read
+	end, err := sk.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	tailStart := end - ir.size
+	if tailStart < start {
+		tailStart = start
+	}
+	if _, err = sk.Seek(tailStart, io.SeekStart); err != nil {
+		return nil, err
+	}
+	tail := make([]byte, end-tailStart)
+	if _, err = io.ReadFull(readerContext{ctx, sk.(io.Reader)}, tail); err != nil {
+		return nil, err
+	}
+	if _, err = sk.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return tail, nil
+}
+
+// tailWriter keeps the last len(buf) bytes written to it.
+type tailWriter struct {
+	buf  []byte
+	pos  int
+	full bool
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= len(w.buf) {
+		copy(w.buf, p[n-len(w.buf):])
+		w.pos, w.full = 0, true
+		return n, nil
+	}
+	c := copy(w.buf[w.pos:], p)
+	if c < n {
+		copy(w.buf, p[c:])
+		w.full = true
+	}
+	w.pos += n
+	if w.pos >= len(w.buf) {
+		w.pos -= len(w.buf)
+		w.full = true
+	}
+	return n, nil
+}
+
+// Bytes returns the bytes kept by the writer in the order they were
+// written.
+func (w *tailWriter) Bytes() []byte {
+	if !w.full {
+		return w.buf[:w.pos]
+	}
+	bs := make([]byte, 0, len(w.buf))
+	bs = append(bs, w.buf[w.pos:]...)
+	return append(bs, w.buf[:w.pos]...)
+}
+
 // LengthIndicator determines the length of the Reader in bytes.
 // The resulting length is written out in big endian ("network") byte
 // order.
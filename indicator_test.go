@@ -3,10 +3,13 @@ package uniquefile_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
 	"io"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/skillian/uniquefile"
 )
@@ -60,9 +63,108 @@ var (
 			})
 			return
 		}(),
+		func() (t indicatorTest) {
+			var buf [8]byte
+			t.name = "helloWorldMultiHash"
+			t.ir = uniquefile.NewMultiHashIndicator(
+				uniquefile.CRC32Indicator,
+				uniquefile.SHA256Indicator,
+				uniquefile.CRC32Indicator,
+			)
+			t.source = "hello, world!"
+			byteOrder.PutUint64(buf[:], uint64(len(t.source)))
+			t.expect.Write([]byte("length"), buf[:])
+			t.expect.Write([]byte("crc32"), []byte{88, 152, 141, 19})
+			t.expect.Write([]byte("sha256"), []byte{
+				104, 230, 86, 178, 81, 230, 126, 131,
+				88, 190, 248, 72, 58, 176, 213, 28, 102,
+				25, 243, 231, 161, 169, 240, 231, 88,
+				56, 212, 31, 243, 104, 247, 40,
+			})
+			return
+		}(),
 	}
 )
 
+type hashIndicatorTest struct {
+	ir  uniquefile.Indicator
+	key string
+	hex string
+}
+
+// hashIndicatorTests are the digests of "abc" published with each
+// algorithm (RFC 1321, FIPS 180-2, RFC 7693 and the xxHash test
+// vectors).
+var hashIndicatorTests = []hashIndicatorTest{
+	{uniquefile.CRC32Indicator, "crc32", "352441c2"},
+	{uniquefile.MD5Indicator, "md5", "900150983cd24fb0d6963f7d28e17f72"},
+	{uniquefile.SHA1Indicator, "sha1", "a9993e364706816aba3e25717850c26c9cd0d89d"},
+	{uniquefile.SHA256Indicator, "sha256", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+	{uniquefile.SHA512Indicator, "sha512", "" +
+		"ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a" +
+		"2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f"},
+	{uniquefile.XXH64Indicator, "xxh64", "44bc2cf5ad770999"},
+	{uniquefile.BLAKE2bIndicator, "blake2b", "" +
+		"ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d1" +
+		"7d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923"},
+}
+
+func TestHashIndicators(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range hashIndicatorTests {
+		tc := tc
+		t.Run(tc.key, func(t *testing.T) {
+			for _, ir := range []uniquefile.Indicator{
+				tc.ir, func() uniquefile.Indicator {
+					ir, ok := uniquefile.ParseIndicator(tc.key)
+					if !ok {
+						t.Fatalf("failed to parse %q", tc.key)
+					}
+					return ir
+				}(),
+			} {
+				var buf [8]byte
+				expect := uniquefile.Indication{}
+				byteOrder.PutUint64(buf[:], 3)
+				expect.Write([]byte("length"), buf[:])
+				sum, err := hex.DecodeString(tc.hex)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expect.Write([]byte(tc.key), sum)
+				res := uniquefile.NewIndication()
+				if err := ir.Indicate(ctx, strings.NewReader("abc"), res); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(res.Bytes(), expect.Bytes()) {
+					t.Fatalf(
+						"result does not match expected:\n\t%v\n\t%v",
+						res.Bytes(), expect.Bytes(),
+					)
+				}
+				uniquefile.PutIndication(&res)
+			}
+		})
+	}
+}
+
+func TestHashIndicatorCmp(t *testing.T) {
+	ctx := context.Background()
+	ir := uniquefile.SHA256Indicator
+	if c, err := ir.Cmp(ctx, []byte("sha256"), []byte{1, 2}, []byte{1, 3}); err != nil || c != -1 {
+		t.Fatalf("expected -1, got %v (err: %v)", c, err)
+	}
+	var a, b [8]byte
+	byteOrder.PutUint64(a[:], 10)
+	byteOrder.PutUint64(b[:], 2)
+	if c, err := ir.Cmp(ctx, []byte("length"), a[:], b[:]); err != nil || c != 1 {
+		t.Fatalf("expected 1, got %v (err: %v)", c, err)
+	}
+	if _, err := ir.Cmp(ctx, []byte("crc32"), a[:], b[:]); err != uniquefile.ErrCannotCmp {
+		t.Fatalf("expected ErrCannotCmp, got %v", err)
+	}
+}
+
 func TestIndicator(t *testing.T) {
 	for _, tc := range indicatorTests {
 		tc := tc
@@ -87,3 +189,329 @@ func TestIndicator(t *testing.T) {
 		})
 	}
 }
+
+func TestHeadTailIndicator(t *testing.T) {
+	ctx := context.Background()
+	source := strings.Repeat("0123456789abcdef", 100) + "tail!"
+	for _, size := range []int64{4, 16, 100, 1000, 4096} {
+		ir := uniquefile.NewHeadTailIndicator(size)
+		for _, length := range []int{0, 3, 16, 17, 200, len(source)} {
+			data := source[:length]
+			head, tail := data, data
+			if int64(length) > size {
+				head = data[:size]
+				tail = data[int64(length)-size:]
+			}
+			headSum := sha256.Sum256([]byte(head))
+			tailSum := sha256.Sum256([]byte(tail))
+			for _, r := range []io.Reader{
+				strings.NewReader(data),
+				struct{ io.Reader }{strings.NewReader(data)},
+				iotest.OneByteReader(strings.NewReader(data)),
+			} {
+				res := uniquefile.NewIndication()
+				if err := ir.Indicate(ctx, r, res); err != nil {
+					t.Fatal(err)
+				}
+				lookup, err := res.Lookup()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(lookup) != 2 {
+					t.Fatalf("expected head and tail keys, got %v", lookup)
+				}
+				for k, v := range lookup {
+					expect := tailSum[:]
+					if strings.HasPrefix(string(k), "head") {
+						expect = headSum[:]
+					}
+					if !bytes.Equal(v, expect) {
+						t.Fatalf(
+							"size %d, length %d (%T): %s does not match:\n\t%v\n\t%v",
+							size, length, r, k, v, expect,
+						)
+					}
+				}
+				uniquefile.PutIndication(&res)
+			}
+		}
+	}
+}
+
+func TestTextHashIndicator(t *testing.T) {
+	ctx := context.Background()
+	const normal = "hello,\n\tworld!\n\nbye\n"
+	normalSum := sha256.Sum256([]byte(normal))
+	for _, tc := range []struct {
+		name   string
+		source string
+		same   bool
+	}{
+		{"normal", normal, true},
+		{"crlf", "hello,\r\n\tworld!\r\n\r\nbye\r\n", true},
+		{"cr", "hello,\r\tworld!\r\rbye\r", true},
+		{"trailing", "hello, \t\n\tworld!  \r\n \nbye\n", true},
+		{"bom", "\xEF\xBB\xBFhello,\n\tworld!\n\nbye\n", true},
+		{"all", "\xEF\xBB\xBFhello,  \r\n\tworld!\t\r\n \r\nbye \r", true},
+		{"leading", " hello,\n\tworld!\n\nbye\n", false},
+		{"inner", "hello, \n\t world!\n\nbye\n", false},
+		{"bomInside", "hello,\n\xEF\xBB\xBF\tworld!\n\nbye\n", false},
+		{"noEOL", "hello,\n\tworld!\n\nbye", false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			for _, r := range []io.Reader{
+				strings.NewReader(tc.source),
+				iotest.OneByteReader(strings.NewReader(tc.source)),
+			} {
+				ind := uniquefile.NewIndication()
+				if err := uniquefile.TextHashIndicator.Indicate(ctx, r, ind); err != nil {
+					t.Fatal(err)
+				}
+				lookup, err := ind.Lookup()
+				if err != nil {
+					t.Fatal(err)
+				}
+				uniquefile.PutIndication(&ind)
+				if same := bytes.Equal(lookup["text"], normalSum[:]); same != tc.same {
+					t.Fatalf(
+						"%q (%T): expected same: %v, actual: %v",
+						tc.source, r, tc.same, same,
+					)
+				}
+			}
+		})
+	}
+	t.Run("options", func(t *testing.T) {
+		const source = "\xEF\xBB\xBFa  \r\nb"
+		for _, tc := range []struct {
+			norm   uniquefile.TextNormalization
+			key    string
+			expect string
+		}{
+			{uniquefile.TextNormalization{}, "text-", source},
+			{uniquefile.TextNormalization{LineEndings: true}, "text-l", "\xEF\xBB\xBFa  \nb"},
+			{uniquefile.TextNormalization{TrailingSpace: true}, "text-s", "\xEF\xBB\xBFa\r\nb"},
+			{uniquefile.TextNormalization{BOM: true}, "text-b", "a  \r\nb"},
+		} {
+			ir, err := uniquefile.NewTextHashIndicator(tc.norm, "sha256")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ind := uniquefile.NewIndication()
+			if err := ir.Indicate(ctx, strings.NewReader(source), ind); err != nil {
+				t.Fatal(err)
+			}
+			lookup, err := ind.Lookup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			uniquefile.PutIndication(&ind)
+			sum := sha256.Sum256([]byte(tc.expect))
+			if !bytes.Equal(lookup[uniquefile.Bytes(tc.key)], sum[:]) {
+				t.Fatalf("%s: expected hash of %q", tc.key, tc.expect)
+			}
+		}
+	})
+	t.Run("binary", func(t *testing.T) {
+		ind := uniquefile.NewIndication()
+		defer uniquefile.PutIndication(&ind)
+		if err := uniquefile.TextHashIndicator.Indicate(
+			ctx, strings.NewReader("text\x00binary"), ind,
+		); err != nil {
+			t.Fatal(err)
+		}
+		if len(ind.Bytes()) != 0 {
+			t.Fatalf("expected no indications, actual: %q", ind.Bytes())
+		}
+	})
+}
+
+type indicatorSpecTest struct {
+	source string
+	spec   string
+	keys   []string
+	err    bool
+}
+
+var indicatorSpecTests = []indicatorSpecTest{
+	{source: "SHA256", spec: "sha256", keys: []string{"length", "sha256"}},
+	{source: "headtail", spec: "headtail", keys: []string{"head4k", "tail4k"}},
+	{
+		source: " headtail : size=64KiB, algo=crc32 ",
+		spec:   "headtail:algo=crc32,size=64kib",
+		keys:   []string{"head64k.crc32", "tail64k.crc32"},
+	},
+	{
+		source: "headtail:algo=sha256,size=1m",
+		spec:   "headtail:algo=sha256,size=1m",
+		keys:   []string{"head1m", "tail1m"},
+	},
+	{source: "headtail:size=100", spec: "headtail:size=100", keys: []string{"head100", "tail100"}},
+	{source: "text", spec: "text", keys: []string{"text"}},
+	{
+		source: "text:eol=true,space=false,bom=0,algo=xxh64",
+		spec:   "text:algo=xxh64,bom=0,eol=true,space=false",
+		keys:   []string{"text-l.xxh64"},
+	},
+	{source: "text:space=maybe", err: true},
+	{source: "nope", err: true},
+	{source: "sha256:algo=crc32", err: true},
+	{source: "headtail:size", err: true},
+	{source: "headtail:size=0", err: true},
+	{source: "headtail:size=1x", err: true},
+	{source: "headtail:algo=nope", err: true},
+	{source: "headtail:bogus=1", err: true},
+	{source: "headtail:size=1,size=2", err: true},
+}
+
+func TestIndicatorSpec(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range indicatorSpecTests {
+		tc := tc
+		t.Run(tc.source, func(t *testing.T) {
+			spec, err := uniquefile.ParseIndicatorSpec(tc.source)
+			var ir uniquefile.Indicator
+			if err == nil {
+				ir, err = uniquefile.NewIndicator(spec)
+			}
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error parsing %q", tc.source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := spec.String(); s != tc.spec {
+				t.Fatalf(
+					"spec does not match expected:\n\t%v\n\t%v",
+					s, tc.spec,
+				)
+			}
+			res := uniquefile.NewIndication()
+			defer uniquefile.PutIndication(&res)
+			if err := ir.Indicate(ctx, strings.NewReader("hello, world!"), res); err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			if err := res.Each(func(key, value []byte) error {
+				keys = append(keys, string(key))
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(keys, ",") != strings.Join(tc.keys, ",") {
+				t.Fatalf(
+					"keys do not match expected:\n\t%v\n\t%v",
+					keys, tc.keys,
+				)
+			}
+		})
+	}
+}
+
+type testIndicator struct{}
+
+func (testIndicator) Indicate(ctx context.Context, r io.Reader, ind *uniquefile.Indication) error {
+	ind.Write([]byte("test"), []byte("test"))
+	return nil
+}
+
+func TestRegisterIndicator(t *testing.T) {
+	uniquefile.RegisterIndicator("Test", testIndicator{}, "test: always test")
+	t.Cleanup(func() { uniquefile.UnregisterIndicator("test") })
+	ir, ok := uniquefile.ParseIndicator("test")
+	if !ok {
+		t.Fatal("registered indicator not found")
+	}
+	if _, ok := ir.(testIndicator); !ok {
+		t.Fatalf("expected testIndicator, got %T", ir)
+	}
+	if _, ok := uniquefile.ParseIndicator("test:x=1"); ok {
+		t.Fatal("expected indicator without a factory to reject parameters")
+	}
+	found := false
+	for _, info := range uniquefile.ListIndicators() {
+		if info.Name == "test" {
+			found = true
+			if info.Description != "test: always test" || info.Parameterized {
+				t.Fatalf("unexpected info: %#v", info)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("registered indicator not listed")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected duplicate registration to panic")
+			}
+		}()
+		uniquefile.RegisterIndicator("test", testIndicator{}, "")
+	}()
+}
+
+// zeroReader reads an endless stream of zeros without allocating.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+var benchmarkSizes = []struct {
+	name string
+	size int64
+}{
+	{"16MiB", 16 << 20},
+	{"2GiB", 2 << 30},
+}
+
+func benchmarkIndicator(b *testing.B, newIndicator func() uniquefile.Indicator) {
+	ctx := context.Background()
+	for _, bs := range benchmarkSizes {
+		bs := bs
+		b.Run(bs.name, func(b *testing.B) {
+			ir := newIndicator()
+			if cr, ok := ir.(io.Closer); ok {
+				defer cr.Close()
+			}
+			ind := uniquefile.NewIndication()
+			defer uniquefile.PutIndication(&ind)
+			b.SetBytes(bs.size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ind.Reset()
+				r := io.LimitReader(zeroReader{}, bs.size)
+				if err := ir.Indicate(ctx, r, ind); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndicatorsPipe(b *testing.B) {
+	benchmarkIndicator(b, func() uniquefile.Indicator {
+		return uniquefile.NewIndicators(
+			uniquefile.CRC32Indicator,
+			uniquefile.SHA256Indicator,
+			uniquefile.XXH64Indicator,
+		)
+	})
+}
+
+func BenchmarkMultiHashIndicator(b *testing.B) {
+	benchmarkIndicator(b, func() uniquefile.Indicator {
+		return uniquefile.NewMultiHashIndicator(
+			uniquefile.CRC32Indicator,
+			uniquefile.SHA256Indicator,
+			uniquefile.XXH64Indicator,
+		)
+	})
+}
@@ -0,0 +1,123 @@
+package uniquefile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+)
+
+const lineSetIndicatorKey = "lineset"
+
+// LineSetIndicator hashes the multiset of the lines of text data so
+// that files with the same lines in a different order, such as sorted
+// and unsorted copies of a CSV export or word list, have the same
+// hash.  Each line is hashed with SHA-256 and the hashes are added
+// together, which doesn't depend on the order of the lines and only
+// needs memory for the longest line's buffer.  The sum and the number
+// of lines are then hashed again with SHA-256 and written under the
+// "lineset" key.
+//
+// Lines are separated by LF and a CR before the LF is ignored, as is
+// a final LF at the end of the data.  Lines that are repeated count
+// as many times as they are repeated.  Data that contains a NUL byte
+// is considered binary and nothing is written for it.
+var LineSetIndicator interface {
+	Indicator
+	IndicatorCmper
+} = lineSetIndicator{}
+
+type lineSetIndicator struct{}
+
+func (lineSetIndicator) Keys() []Bytes { return []Bytes{lineSetIndicatorKey} }
+
+// Cmp compares the hashes byte-by-byte.
+func (lineSetIndicator) Cmp(ctx context.Context, key, a, b []byte) (int, error) {
+	if string(key) != lineSetIndicatorKey {
+		return 0, ErrCannotCmp
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return bytes.Compare(a, b), nil
+}
+
+func (lineSetIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	var sum lineSetSum
+	var count uint64
+	h := sha256.New()
+	var lineHash [sha256.Size]byte
+	br := bufio.NewReader(readerContext{ctx, r})
+	// started is set when the current line has any bytes (even if
+	// they're just a held back CR).
+	started, cr := false, false
+	endLine := func() {
+		sum.add(h.Sum(lineHash[:0]))
+		count++
+		h.Reset()
+		started, cr = false, false
+	}
+	for {
+		piece, err := br.ReadSlice('\n')
+		if bytes.IndexByte(piece, 0) != -1 {
+			return nil
+		}
+		if len(piece) > 0 {
+			started = true
+			if cr && piece[0] != '\n' {
+				// the held back CR wasn't part of a CRLF:
+				h.Write([]byte{'\r'})
+			}
+			cr = false
+			line := piece
+			eol := line[len(line)-1] == '\n'
+			if eol {
+				line = line[:len(line)-1]
+			}
+			if len(line) > 0 && line[len(line)-1] == '\r' {
+				line = line[:len(line)-1]
+				cr = !eol
+			}
+			h.Write(line)
+			if eol {
+				endLine()
+			}
+		}
+		if err == nil || err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		break
+	}
+	if started {
+		// the last line didn't end with a LF:
+		if cr {
+			h.Write([]byte{'\r'})
+		}
+		endLine()
+	}
+	final := sha256.New()
+	final.Write(sum[:])
+	var buf [sha256.Size]byte
+	byteOrder.PutUint64(buf[:8], count)
+	final.Write(buf[:8])
+	ind.Write([]byte(lineSetIndicatorKey), final.Sum(buf[:0]))
+	return nil
+}
+
+// lineSetSum is a 256-bit sum of line hashes.
+type lineSetSum [sha256.Size]byte
+
+// add adds the hash to the sum as big endian 256-bit integers, modulo
+// 2^256.
+func (s *lineSetSum) add(hash []byte) {
+	carry := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		v := int(s[i]) + int(hash[i]) + carry
+		s[i] = byte(v)
+		carry = v >> 8
+	}
+}
@@ -0,0 +1,74 @@
+package uniquefile_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/skillian/uniquefile"
+)
+
+func lineSetOf(t *testing.T, r io.Reader) []byte {
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	if err := uniquefile.LineSetIndicator.Indicate(context.Background(), r, ind); err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := ind.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), lookup["lineset"]...)
+}
+
+func TestLineSetIndicator(t *testing.T) {
+	const original = "id,name\n1,alice\n2,bob\n\n3,carol\n"
+	expect := lineSetOf(t, strings.NewReader(original))
+	if len(expect) != 32 {
+		t.Fatalf("expected a 32 byte hash, got %x", expect)
+	}
+	// long lines don't fit into the default 4KiB buffer and the
+	// CR after this one is the last byte of a buffer.
+	long := strings.Repeat("x", 3*4096-1)
+	for _, tc := range []struct {
+		name   string
+		source string
+		same   bool
+	}{
+		{"reordered", "3,carol\n\n2,bob\nid,name\n1,alice", true},
+		{"crlf", "2,bob\r\n1,alice\r\nid,name\r\n3,carol\r\n\r\n", true},
+		{"duplicate", original + "2,bob\n", false},
+		{"missingEmpty", "id,name\n1,alice\n2,bob\n3,carol\n", false},
+		{"changed", "id,name\n1,alice\n2,bob\n\n3,carl\n", false},
+		{"joined", "id,name\n1,alice2,bob\n\n3,carol\n", false},
+		{"cr", "id,name\n1,alice\n2,bob\r\r\n\n3,carol\n", false},
+		{"long", long + "\n" + original, false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			for _, r := range []io.Reader{
+				strings.NewReader(tc.source),
+				iotest.OneByteReader(strings.NewReader(tc.source)),
+			} {
+				if same := bytes.Equal(lineSetOf(t, r), expect); same != tc.same {
+					t.Fatalf("%T: expected same: %v, actual: %v", r, tc.same, same)
+				}
+			}
+		})
+	}
+	t.Run("longReordered", func(t *testing.T) {
+		a := lineSetOf(t, strings.NewReader(long+"\r\nshort\n"))
+		b := lineSetOf(t, strings.NewReader("short\n"+long+"\n"))
+		if !bytes.Equal(a, b) {
+			t.Fatal("expected long lines to match")
+		}
+	})
+	t.Run("binary", func(t *testing.T) {
+		if v := lineSetOf(t, strings.NewReader("a\nb\x00\n")); v != nil {
+			t.Fatalf("expected nothing, got %x", v)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package uniquefile
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const mimeTypeIndicatorKey = "mimetype"
+
+// mimeTypeSniffLen is how much of the data the MIMETypeIndicator
+// reads.  It has to reach past the ISO 9660 signature that is checked
+// for the furthest into the data, at 0x9001.
+const mimeTypeSniffLen = 40 << 10
+
+// MIMETypeIndicator detects the media type of its data from its
+// leading bytes (see DetectContentType) and writes it under the
+// "mimetype" key.  Only the beginning of the data is read.
+var MIMETypeIndicator Indicator = mimeTypeIndicator{}
+
+type mimeTypeIndicator struct{}
+
+func (mimeTypeIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	buf := make([]byte, mimeTypeSniffLen)
+	n, err := io.ReadFull(readerContext{ctx, r}, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	ind.Write([]byte(mimeTypeIndicatorKey), []byte(DetectContentType(buf[:n])))
+	return nil
+}
+
+// magic is a signature of a file format at an offset into the data.
+type magic struct {
+	offset   int
+	sig      string
+	mimeType string
+}
+
+// magics are checked before http.DetectContentType, either because
+// it doesn't know them or because they're more specific than what it
+// would detect.
+var magics = []magic{
+	{0x8001, "CD001", "application/x-iso9660-image"},
+	{0x8801, "CD001", "application/x-iso9660-image"},
+	{0x9001, "CD001", "application/x-iso9660-image"},
+	{0, "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1", "application/x-ole-storage"},
+	{0, "7z\xBC\xAF\x27\x1C", "application/x-7z-compressed"},
+	{0, "Rar!\x1A\x07", "application/vnd.rar"},
+	{0, "BZh", "application/x-bzip2"},
+	{0, "\xFD7zXZ\x00", "application/x-xz"},
+	{0, "\x28\xB5\x2F\xFD", "application/zstd"},
+	{257, "ustar", "application/x-tar"},
+	{0, "fLaC", "audio/flac"},
+	{0, "SQLite format 3\x00", "application/vnd.sqlite3"},
+	{0, "\x7FELF", "application/x-executable"},
+	{0, "II*\x00", "image/tiff"},
+	{0, "MM\x00*", "image/tiff"},
+}
+
+// zipMagic identifies a format that is a ZIP archive by the name of
+// one of its entries.  A name that ends with a slash matches the
+// entries in that directory.  If contents isn't empty, the entry's
+// stored contents must start with it.
+type zipMagic struct {
+	name     string
+	contents string
+	mimeType string
+}
+
+func (m zipMagic) matches(e zipLocalEntry) bool {
+	if strings.HasSuffix(m.name, "/") {
+		if !bytes.HasPrefix(e.name, []byte(m.name)) {
+			return false
+		}
+	} else if string(e.name) != m.name {
+		return false
+	}
+	return bytes.HasPrefix(e.contents, []byte(m.contents))
+}
+
+// zipMagics identify formats that are ZIP archives by their first
+// entries.
+var zipMagics = []zipMagic{
+	{"mimetype", "application/epub+zip", "application/epub+zip"},
+	{"mimetype", "application/vnd.oasis.opendocument.text", "application/vnd.oasis.opendocument.text"},
+	{"mimetype", "application/vnd.oasis.opendocument.spreadsheet", "application/vnd.oasis.opendocument.spreadsheet"},
+	{"mimetype", "application/vnd.oasis.opendocument.presentation", "application/vnd.oasis.opendocument.presentation"},
+	{"word/", "", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	{"xl/", "", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	{"ppt/", "", "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	{"META-INF/MANIFEST.MF", "", "application/java-archive"},
+}
+
+const zipMIMEType = "application/zip"
+
+// DetectContentType detects the media type of data from its leading
+// bytes.  It extends http.DetectContentType with more formats such as
+// ISO images, Office documents, and other archive and compression
+// formats.  Parameters such as the charset are removed from the
+// result, so it is only ever a type and subtype like "text/plain".
+// If the format cannot be determined, "application/octet-stream" is
+// returned.
+func DetectContentType(data []byte) string {
+	for _, m := range magics {
+		if len(data) >= m.offset+len(m.sig) && string(data[m.offset:m.offset+len(m.sig)]) == m.sig {
+			return m.mimeType
+		}
+	}
+	mimeType := http.DetectContentType(data)
+	if i := strings.IndexByte(mimeType, ';'); i != -1 {
+		mimeType = strings.TrimSpace(mimeType[:i])
+	}
+	if mimeType == zipMIMEType {
+		entries := zipLocalEntries(data)
+		for _, m := range zipMagics {
+			for _, e := range entries {
+				if m.matches(e) {
+					return m.mimeType
+				}
+			}
+		}
+	}
+	return mimeType
+}
+
+// zipLocalEntry is the name and the beginning of the contents of an
+// entry found by its local file header.
+type zipLocalEntry struct {
+	name, contents []byte
+}
+
+// zipLocalHeaderLen is the length of a ZIP local file header up to
+// the entry's name.
+const zipLocalHeaderLen = 30
+
+// zipLocalEntries finds the entries in data by their local file
+// headers.  The end of data might cut the last entry short, so its
+// contents may be incomplete.
+func zipLocalEntries(data []byte) (entries []zipLocalEntry) {
+	const sig = "PK\x03\x04"
+	for i := 0; ; {
+		j := bytes.Index(data[i:], []byte(sig))
+		if j == -1 {
+			return
+		}
+		h := data[i+j:]
+		i += j + len(sig)
+		if len(h) < zipLocalHeaderLen {
+			return
+		}
+		nameLen := int(binary.LittleEndian.Uint16(h[26:28]))
+		extraLen := int(binary.LittleEndian.Uint16(h[28:30]))
+		if len(h) < zipLocalHeaderLen+nameLen {
+			return
+		}
+		e := zipLocalEntry{name: h[zipLocalHeaderLen : zipLocalHeaderLen+nameLen]}
+		if start := zipLocalHeaderLen + nameLen + extraLen; len(h) > start {
+			e.contents = h[start:]
+		}
+		entries = append(entries, e)
+	}
+}
+
+// MatchMIMEType checks if mimeType matches any of the patterns.  A
+// pattern is either a whole media type (e.g. "image/png") or a type
+// followed by a slash to match all of its subtypes (e.g. "image/").
+func MatchMIMEType(mimeType string, patterns ...string) bool {
+	for _, p := range patterns {
+		if p == mimeType || (strings.HasSuffix(p, "/") && strings.HasPrefix(mimeType, p)) {
+			return true
+		}
+	}
+	return false
+}
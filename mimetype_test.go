@@ -0,0 +1,136 @@
+package uniquefile_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/skillian/uniquefile"
+)
+
+func zipOf(t *testing.T, names ...string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("contents of " + name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// storedZipOf creates a ZIP archive with a stored (uncompressed) entry
+// for each name and its contents.
+func storedZipOf(t *testing.T, namesAndContents ...string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i < len(namesAndContents); i += 2 {
+		w, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   namesAndContents[i],
+			Method: zip.Store,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(namesAndContents[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarOf(t *testing.T, name string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectContentType(t *testing.T) {
+	iso := make([]byte, 0x8800)
+	copy(iso[0x8001:], "CD001")
+	// the third volume descriptor is the last place the
+	// signature can be:
+	iso3 := make([]byte, 0x9800)
+	copy(iso3[0x9001:], "CD001")
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		expect string
+	}{
+		{"text", []byte("hello, world!\n"), "text/plain"},
+		{"pdf", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{"iso", iso, "application/x-iso9660-image"},
+		{"iso3", iso3, "application/x-iso9660-image"},
+		{"ole", []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1\x00\x00"), "application/x-ole-storage"},
+		{"zip", zipOf(t, "a.txt", "b.txt"), "application/zip"},
+		{"docx", zipOf(t, "[Content_Types].xml", "word/document.xml"), "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"xlsx", zipOf(t, "[Content_Types].xml", "xl/workbook.xml"), "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		{"jar", zipOf(t, "META-INF/MANIFEST.MF"), "application/java-archive"},
+		{"epub", storedZipOf(t, "mimetype", "application/epub+zip", "OEBPS/content.opf", ""), "application/epub+zip"},
+		{"odt", storedZipOf(t, "mimetype", "application/vnd.oasis.opendocument.text"), "application/vnd.oasis.opendocument.text"},
+		{"zipLikeOffice", zipOf(t, "password/a.txt", "pixl/a.txt", "myppt/b.txt", "notes/word/c.txt"), "application/zip"},
+		{"zipWithMagicContents", storedZipOf(t, "a.txt", "word/ xl/ ppt/ mimetypeapplication/epub+zip"), "application/zip"},
+		{"tar", tarOf(t, "hello.txt"), "application/x-tar"},
+		{"empty", nil, "text/plain"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			actual := uniquefile.DetectContentType(tc.data)
+			if actual != tc.expect {
+				t.Fatalf(
+					"expected does not match actual:\n\t%v\n\t%v",
+					tc.expect, actual,
+				)
+			}
+			ind := uniquefile.NewIndication()
+			defer uniquefile.PutIndication(&ind)
+			if err := uniquefile.MIMETypeIndicator.Indicate(
+				context.Background(), bytes.NewReader(tc.data), ind,
+			); err != nil {
+				t.Fatal(err)
+			}
+			expect := uniquefile.Indication{}
+			expect.Write([]byte("mimetype"), []byte(tc.expect))
+			if !bytes.Equal(ind.Bytes(), expect.Bytes()) {
+				t.Fatalf(
+					"result does not match expected:\n\t%q\n\t%q",
+					ind.Bytes(), expect.Bytes(),
+				)
+			}
+		})
+	}
+}
+
+func TestMatchMIMEType(t *testing.T) {
+	if !uniquefile.MatchMIMEType("image/png", "text/plain", "image/") {
+		t.Fatal("expected image/png to match image/")
+	}
+	if !uniquefile.MatchMIMEType("image/png", "image/png") {
+		t.Fatal("expected image/png to match itself")
+	}
+	if uniquefile.MatchMIMEType("image/png", "image") {
+		t.Fatal("expected image/png not to match image")
+	}
+}
@@ -0,0 +1,268 @@
+package uniquefile
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/skillian/errors"
+)
+
+const (
+	minHashIndicatorKey = "minhash"
+
+	// minHashSize is the number of hashes in a MinHash signature.
+	minHashSize = 64
+
+	// MinHashBands is the number of bands that a MinHash signature
+	// is split into for locality-sensitive hashing.
+	MinHashBands = 16
+
+	// minHashRows is the number of hashes in each band.
+	minHashRows = minHashSize / MinHashBands
+
+	// minHashShingle is the number of words in each shingle.
+	minHashShingle = 3
+)
+
+// MinHashIndicator tokenizes text into overlapping shingles of three
+// words and writes a MinHash signature of the set of shingles under
+// the "minhash" key.  The fraction of the hashes that two signatures
+// have in common estimates the Jaccard similarity of the documents'
+// shingles, so documents that were lightly edited have signatures that
+// are mostly the same.
+//
+// Words are runs of letters and digits and are compared
+// case-insensitively, so changes to punctuation, whitespace and
+// capitalization don't affect the signature at all.  Data that contains
+// a NUL byte is considered binary, and nothing is written for it or for
+// text without any words.
+//
+// The signature is 64 big endian 32-bit hashes.  See MinHashBandsOf to
+// find candidate near-duplicates with locality-sensitive hashing
+// instead of comparing every pair of signatures.
+var MinHashIndicator interface {
+	Indicator
+	IndicatorSimilarer
+	IndicatorBander
+} = minHashIndicator{}
+
+type minHashIndicator struct{}
+
+func (minHashIndicator) Keys() []Bytes { return []Bytes{minHashIndicatorKey} }
+
+// Similarity returns the fraction of the hashes that are the same in
+// both signatures.
+func (minHashIndicator) Similarity(ctx context.Context, key, a, b []byte) (float64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if !IsMinHashKey(key) {
+		return 0, ErrCannotCmp
+	}
+	if err := checkMinHash(a); err != nil {
+		return 0, err
+	}
+	if err := checkMinHash(b); err != nil {
+		return 0, err
+	}
+	same := 0
+	for i := 0; i < len(a); i += 4 {
+		if byteOrder.Uint32(a[i:]) == byteOrder.Uint32(b[i:]) {
+			same++
+		}
+	}
+	return float64(same) / minHashSize, nil
+}
+
+// Threshold considers documents that share about 70% of their
+// shingles to be near-duplicates.
+func (minHashIndicator) Threshold(key []byte) float64 { return 0.7 }
+
+// Bands returns the signature's MinHashBands.  Like with
+// MinHashCandidates, near-duplicates at the default threshold are
+// very likely, but not certain, to have a band in common.
+func (minHashIndicator) Bands(ctx context.Context, key, value []byte, threshold float64) ([][]byte, error) {
+	if !IsMinHashKey(key) {
+		return nil, ErrCannotCmp
+	}
+	mbs, err := MinHashBandsOf(value)
+	if err != nil {
+		return nil, err
+	}
+	bands := make([][]byte, len(mbs))
+	for i, mb := range mbs {
+		band := make([]byte, 9)
+		band[0] = byte(mb.Index)
+		byteOrder.PutUint64(band[1:], mb.Hash)
+		bands[i] = band
+	}
+	return bands, nil
+}
+
+func (minHashIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	var mins [minHashSize]uint32
+	for i := range mins {
+		mins[i] = math.MaxUint32
+	}
+	add := func(shingle string) {
+		h := xxhash.Sum64String(shingle)
+		for i, seed := range minHashSeeds {
+			if v := uint32(mix64(h^seed) >> 32); v < mins[i] {
+				mins[i] = v
+			}
+		}
+	}
+	var words [minHashShingle]string
+	n := 0
+	addWord := func(w string) {
+		copy(words[:], words[1:])
+		words[len(words)-1] = w
+		n++
+		if n >= minHashShingle {
+			add(strings.Join(words[:], " "))
+		}
+	}
+	br := bufio.NewReader(readerContext{ctx, r})
+	var sb strings.Builder
+	for {
+		c, _, err := br.ReadRune()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		if c == 0 {
+			return nil
+		}
+		if unicode.IsLetter(c) || unicode.IsDigit(c) {
+			sb.WriteRune(unicode.ToLower(c))
+			continue
+		}
+		if sb.Len() > 0 {
+			addWord(sb.String())
+			sb.Reset()
+		}
+	}
+	if sb.Len() > 0 {
+		addWord(sb.String())
+	}
+	switch {
+	case n == 0:
+		return nil
+	case n < minHashShingle:
+		// too short for even one shingle, so the few words
+		// are the only shingle:
+		add(strings.Join(words[minHashShingle-n:], " "))
+	}
+	sig := make([]byte, 4*minHashSize)
+	for i, v := range mins {
+		byteOrder.PutUint32(sig[i*4:], v)
+	}
+	ind.Write([]byte(minHashIndicatorKey), sig)
+	return nil
+}
+
+// minHashSeeds are mixed into each shingle's hash to get the
+// independent hash functions of the signature.  Like the gearTable,
+// they must never change.
+var minHashSeeds = func() (t [minHashSize]uint64) {
+	x := uint64(0x6d696e68617368)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		t[i] = mix64(x)
+	}
+	return
+}()
+
+// mix64 is the splitmix64 finalizer.
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func checkMinHash(sig []byte) error {
+	if len(sig) != 4*minHashSize {
+		return errors.Errorf(
+			"MinHash signature must be %d bytes, not %d",
+			4*minHashSize, len(sig),
+		)
+	}
+	return nil
+}
+
+// IsMinHashKey returns true if key is a key written by the
+// MinHashIndicator.
+func IsMinHashKey(key []byte) bool {
+	return strings.HasPrefix(string(key), minHashIndicatorKey)
+}
+
+// MinHashBand is a band of a MinHash signature.  Documents whose
+// signatures have any band in common are candidate near-duplicates:
+// Documents that share a fraction s of their shingles have at least
+// one band in common with a probability of 1 - (1 - s^4)^16, which is
+// about 99% when s is 0.7 but only about 12% when s is 0.3.
+type MinHashBand struct {
+	// Index of the band in the signature.
+	Index int
+
+	// Hash of the band's hashes.
+	Hash uint64
+}
+
+// MinHashBandsOf splits a MinHash signature into its bands.
+func MinHashBandsOf(sig []byte) ([]MinHashBand, error) {
+	if err := checkMinHash(sig); err != nil {
+		return nil, err
+	}
+	bands := make([]MinHashBand, MinHashBands)
+	const bandSize = 4 * minHashRows
+	for i := range bands {
+		bands[i] = MinHashBand{
+			Index: i,
+			Hash:  xxhash.Sum64(sig[i*bandSize : (i+1)*bandSize]),
+		}
+	}
+	return bands, nil
+}
+
+// LSHRepo is a Repo that can find resources by the bands of their
+// MinHash signatures.
+type LSHRepo interface {
+	Repo
+
+	// BandURIs returns the URIs of the resources whose MinHash
+	// signature indicated under key has the band.
+	BandURIs(ctx context.Context, key Bytes, band MinHashBand) ([]URI, error)
+}
+
+// MinHashCandidates finds the other resources in the Repo whose MinHash
+// signatures under key have at least one band in common with sig.
+func MinHashCandidates(ctx context.Context, r LSHRepo, u URI, key Bytes, sig []byte) ([]URI, error) {
+	bands, err := MinHashBandsOf(sig)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []URI
+	seen := make(map[URI]struct{})
+	for _, b := range bands {
+		uris, err := r.BandURIs(ctx, key, b)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range uris {
+			if _, ok := seen[v]; ok || v == u {
+				continue
+			}
+			seen[v] = struct{}{}
+			candidates = append(candidates, v)
+		}
+	}
+	return candidates, nil
+}
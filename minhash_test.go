@@ -0,0 +1,117 @@
+package uniquefile_test
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/skillian/uniquefile"
+)
+
+// testDocument generates n words of text from a small vocabulary.
+func testDocument(seed int64, n int) []string {
+	vocab := strings.Fields(
+		"the quick brown fox jumps over lazy dog and then " +
+			"runs into forest where it finds a river with " +
+			"many fish swimming under bridge near old mill " +
+			"while birds sing songs about summer rain",
+	)
+	rng := rand.New(rand.NewSource(seed))
+	words := make([]string, n)
+	for i := range words {
+		words[i] = vocab[rng.Intn(len(vocab))]
+	}
+	return words
+}
+
+func minHashOf(t *testing.T, text string) []byte {
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	if err := uniquefile.MinHashIndicator.Indicate(
+		context.Background(), strings.NewReader(text), ind,
+	); err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := ind.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), lookup["minhash"]...)
+}
+
+func TestMinHashIndicator(t *testing.T) {
+	ctx := context.Background()
+	words := testDocument(1, 500)
+	original := strings.Join(words, " ")
+	edited := make([]string, len(words))
+	copy(edited, words)
+	edited[100], edited[250], edited[400] = "fixed", "typos", "here"
+	reformatted := strings.ToUpper(strings.Join(words, ",\r\n\t"))
+	other := strings.Join(testDocument(2, 500), " ")
+	sig := minHashOf(t, original)
+	for _, tc := range []struct {
+		name     string
+		text     string
+		min, max float64
+	}{
+		{"reformatted", reformatted, 1, 1},
+		{"edited", strings.Join(edited, " "), 0.8, 1},
+		{"other", other, 0, 0.3},
+	} {
+		sim, err := uniquefile.MinHashIndicator.Similarity(
+			ctx, []byte("minhash"), sig, minHashOf(t, tc.text),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sim < tc.min || sim > tc.max {
+			t.Fatalf(
+				"%s: similarity %v is not between %v and %v",
+				tc.name, sim, tc.min, tc.max,
+			)
+		}
+	}
+	for _, text := range []string{"", " ... ", "binary\x00data"} {
+		if sig := minHashOf(t, text); sig != nil {
+			t.Fatalf("expected no signature for %q", text)
+		}
+	}
+	if sig := minHashOf(t, "Two words"); sig == nil {
+		t.Fatal("expected a signature of a short text")
+	}
+}
+
+func TestFindLSHNearDuplicates(t *testing.T) {
+	ctx := context.Background()
+	uri := func(p string) uniquefile.URI {
+		return uniquefile.URI{Scheme: uniquefile.FileScheme, Path: p}
+	}
+	words := testDocument(1, 500)
+	edited := make([]string, len(words))
+	copy(edited, words)
+	edited[200] = "edited"
+	r := memRepo{
+		uri("/a.txt"): {"minhash": minHashOf(t, strings.Join(words, " "))},
+		uri("/b.txt"): {"minhash": minHashOf(t, strings.Join(edited, " "))},
+		uri("/c.txt"): {"minhash": minHashOf(t, strings.Join(testDocument(2, 500), " "))},
+		uri("/d.txt"): {"sha256": []byte{1}},
+	}
+	candidates, err := uniquefile.MinHashCandidates(ctx, r, uri("/a.txt"), "minhash", r[uri("/a.txt")]["minhash"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0] != uri("/b.txt") {
+		t.Fatalf("expected only /b.txt as a candidate, got %v", candidates)
+	}
+	nds, err := uniquefile.FindLSHNearDuplicates(
+		ctx, r, uniquefile.MinHashIndicator,
+		[]uniquefile.URI{uri("/a.txt"), uri("/b.txt"), uri("/c.txt"), uri("/d.txt")}, 0,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nds) != 1 || nds[0].A != uri("/a.txt") || nds[0].B != uri("/b.txt") {
+		t.Fatalf("expected /a.txt and /b.txt, got %v", nds)
+	}
+}
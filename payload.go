@@ -0,0 +1,361 @@
+package uniquefile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"hash"
+	"io"
+
+	"github.com/skillian/errors"
+)
+
+const (
+	payloadIndicatorName = "payload"
+	payloadKeyPrefix     = "payload-"
+
+	// id3v1Size is the size of the ID3v1 tag at the end of an MP3
+	// file.
+	id3v1Size = 128
+)
+
+// PayloadIndicator hashes the contents of JPEG, PNG and MP3 files
+// without their metadata with SHA-256 under the "payload-sha256" key
+// (see NewPayloadIndicator).
+var PayloadIndicator Indicator = payloadIndicator{
+	key:    payloadKeyPrefix + sha256Key,
+	hasher: hashers[sha256Key],
+}
+
+// NewPayloadIndicator creates an Indicator that hashes the contents of
+// media files without the metadata that is commonly edited, so that
+// copies of a photo or song that only differ in their tags have the
+// same hash.  The hash is written under "payload-" followed by the
+// algorithm (e.g. "payload-sha256").  What is left out depends on the
+// format:
+//
+//	JPEG:	APP1 segments (EXIF and XMP), APP13 segments (IPTC) and
+//		comments.
+//	PNG:	Text chunks (tEXt, zTXt and iTXt), EXIF chunks (eXIf)
+//		and the modification time (tIME).
+//	MP3:	ID3v2 tags at the beginning and an ID3v1 tag at the
+//		end.
+//
+// Everything else, including color profiles, is hashed as it is.  If
+// the data isn't in one of those formats or if it is corrupt, nothing
+// is written.
+func NewPayloadIndicator(algo string) (Indicator, error) {
+	hasher, ok := hashers[algo]
+	if !ok {
+		return nil, errors.Errorf("unknown hash algorithm: %q", algo)
+	}
+	return payloadIndicator{key: payloadKeyPrefix + algo, hasher: hasher}, nil
+}
+
+func newPayloadIndicatorFromSpec(spec IndicatorSpec) (Indicator, error) {
+	if err := spec.CheckParams("algo"); err != nil {
+		return nil, err
+	}
+	algo, _, err := spec.Hash("algo", sha256Key)
+	if err != nil {
+		return nil, err
+	}
+	return NewPayloadIndicator(algo)
+}
+
+type payloadIndicator struct {
+	key    string
+	hasher func() hash.Hash
+}
+
+// errMediaFormat means that a media file is corrupt.
+var errMediaFormat = errors.New("invalid media file")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func (ir payloadIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	br := bufio.NewReader(readerContext{ctx, r})
+	head, err := br.Peek(len(pngSignature))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	mp3, err := isMP3(br)
+	if err != nil {
+		return err
+	}
+	h := ir.hasher()
+	switch {
+	case bytes.HasPrefix(head, pngSignature):
+		err = pngPayload(ctx, h, br)
+	case len(head) >= 3 && head[0] == 0xff && head[1] == 0xd8 && head[2] == 0xff:
+		err = jpegPayload(ctx, h, br)
+	case mp3:
+		err = mp3Payload(h, br)
+	default:
+		return nil
+	}
+	if err != nil {
+		if err == errMediaFormat || err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		return err
+	}
+	var buf [64]byte
+	ind.Write([]byte(ir.key), h.Sum(buf[:0]))
+	return nil
+}
+
+// isMP3 checks if the data starts with an ID3v2 tag or with two MPEG
+// audio frames.  A single frame header is too easy to match by
+// accident (the UTF-16LE byte order mark is a valid one), so the next
+// frame's header must follow right where the first frame ends.
+func isMP3(br *bufio.Reader) (bool, error) {
+	head, err := br.Peek(4)
+	if err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	if bytes.HasPrefix(head, []byte("ID3")) {
+		return true, nil
+	}
+	n, ok := mpegFrameLen(head)
+	if !ok {
+		return false, nil
+	}
+	frames, err := br.Peek(n + 4)
+	if err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	next := frames[n:]
+	if _, ok := mpegFrameLen(next); !ok {
+		return false, nil
+	}
+	// the version, layer and sample rate can't change between
+	// frames:
+	return head[1]&0x1e == next[1]&0x1e && head[2]&0x0c == next[2]&0x0c, nil
+}
+
+// mpegBitRates are the bit rates in kbit/s of each bit rate index by
+// the version (MPEG-1 or MPEG-2 and 2.5) and layer (I, II or III).
+var mpegBitRates = [2][3][15]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// mpegSampleRates are the sample rates of each sample rate index by
+// the version's bits (MPEG-2.5, reserved, MPEG-2 and MPEG-1).
+var mpegSampleRates = [4][3]int{
+	{11025, 12000, 8000},
+	{},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+// mpegFrameLen gets the length of the MPEG audio frame whose header
+// starts head.  ok is false if head isn't a frame header or if the
+// frame's length can't be known from it.
+func mpegFrameLen(head []byte) (n int, ok bool) {
+	if len(head) < 4 || head[0] != 0xff || head[1]&0xe0 != 0xe0 {
+		return 0, false
+	}
+	version := head[1] >> 3 & 3
+	// layer 0 is reserved (and used by ADTS AAC headers), and the
+	// layers are numbered backwards:
+	layer := 3 - int(head[1]>>1&3)
+	bitRateIndex := head[2] >> 4
+	sampleRateIndex := head[2] >> 2 & 3
+	// bit rate index 0 is "free format" which doesn't have a
+	// fixed frame length and 0xF is invalid:
+	if version == 1 || layer == 3 || bitRateIndex == 0 || bitRateIndex == 0xf || sampleRateIndex == 3 {
+		return 0, false
+	}
+	v := 0
+	if version != 3 {
+		v = 1
+	}
+	bitRate := mpegBitRates[v][layer][bitRateIndex] * 1000
+	sampleRate := mpegSampleRates[version][sampleRateIndex]
+	padding := int(head[2] >> 1 & 1)
+	switch {
+	case layer == 0:
+		return (12*bitRate/sampleRate + padding) * 4, true
+	case layer == 2 && v == 1:
+		// MPEG-2 and 2.5 layer III frames have half as many
+		// samples:
+		return 72*bitRate/sampleRate + padding, true
+	}
+	return 144*bitRate/sampleRate + padding, true
+}
+
+func mp3Payload(h hash.Hash, br *bufio.Reader) error {
+	for {
+		tag, err := br.Peek(10)
+		if err != nil || !bytes.HasPrefix(tag, []byte("ID3")) {
+			break
+		}
+		// the size is "syncsafe" with 7 bits per byte:
+		var size int64
+		for _, b := range tag[6:10] {
+			if b&0x80 != 0 {
+				return errMediaFormat
+			}
+			size = size<<7 | int64(b)
+		}
+		size += 10
+		if tag[5]&0x10 != 0 {
+			// footer
+			size += 10
+		}
+		if _, err = br.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+	// The ID3v1 tag is only known when the end is reached, so the
+	// last bytes are held back until then.
+	bp := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(bp)
+	buf := *bp
+	held := 0
+	for {
+		n, err := br.Read(buf[held:])
+		if n += held; n > id3v1Size {
+			h.Write(buf[:n-id3v1Size])
+			held = copy(buf, buf[n-id3v1Size:n])
+		} else {
+			held = n
+		}
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+	}
+	if held != id3v1Size || !bytes.HasPrefix(buf, []byte("TAG")) {
+		h.Write(buf[:held])
+	}
+	return nil
+}
+
+func jpegPayload(ctx context.Context, h hash.Hash, br *bufio.Reader) error {
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return err
+	}
+	h.Write(soi[:])
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c != 0xff {
+			return errMediaFormat
+		}
+		marker := byte(0xff)
+		// markers can be padded with any number of 0xFFs:
+		for marker == 0xff {
+			if marker, err = br.ReadByte(); err != nil {
+				return err
+			}
+		}
+		switch {
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			// markers without a segment
+			h.Write([]byte{0xff, marker})
+			continue
+		case marker == 0xd9:
+			// EOI, but keep anything that some cameras put
+			// after it.
+			h.Write([]byte{0xff, marker})
+			_, err = copyContext(ctx, h, br, nil)
+			return err
+		}
+		var length [2]byte
+		if _, err = io.ReadFull(br, length[:]); err != nil {
+			return err
+		}
+		size := int64(length[0])<<8 | int64(length[1])
+		if size < 2 {
+			return errMediaFormat
+		}
+		switch marker {
+		case 0xe1, 0xed, 0xfe:
+			// APP1 (EXIF and XMP), APP13 (IPTC) and COM
+			if _, err = br.Discard(int(size - 2)); err != nil {
+				return err
+			}
+			continue
+		}
+		h.Write([]byte{0xff, marker, length[0], length[1]})
+		if _, err = io.CopyN(h, br, size-2); err != nil {
+			return err
+		}
+		if marker == 0xda {
+			// SOS: The entropy coded image data that follows
+			// and the rest of the file are all hashed.
+			_, err = copyContext(ctx, h, br, nil)
+			return err
+		}
+	}
+}
+
+// pngMetadataChunks are the PNG chunks that are left out of the
+// payload.
+var pngMetadataChunks = map[string]struct{}{
+	"tEXt": {},
+	"zTXt": {},
+	"iTXt": {},
+	"eXIf": {},
+	"tIME": {},
+}
+
+func pngPayload(ctx context.Context, h hash.Hash, br *bufio.Reader) error {
+	if _, err := io.CopyN(h, br, int64(len(pngSignature))); err != nil {
+		return err
+	}
+	var header [8]byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			if err == io.EOF {
+				// no IEND
+				return errMediaFormat
+			}
+			return err
+		}
+		length := int64(byteOrder.Uint32(header[:4]))
+		if length > 1<<31-1 {
+			return errMediaFormat
+		}
+		typ := string(header[4:])
+		// the data is followed by a 4 byte CRC:
+		if _, ok := pngMetadataChunks[typ]; ok {
+			if _, err := io.CopyN(io.Discard, br, length+4); err != nil {
+				return err
+			}
+			continue
+		}
+		h.Write(header[:])
+		if _, err := io.CopyN(h, br, length+4); err != nil {
+			return err
+		}
+		if typ == "IEND" {
+			return nil
+		}
+	}
+}
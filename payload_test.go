@@ -0,0 +1,162 @@
+package uniquefile_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/skillian/uniquefile"
+)
+
+func payloadOf(t *testing.T, ir uniquefile.Indicator, data []byte) []byte {
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	if err := ir.Indicate(context.Background(), bytes.NewReader(data), ind); err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := ind.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range lookup {
+		if k != "payload-sha256" && k != "payload-md5" {
+			t.Fatalf("unexpected key: %q", k)
+		}
+		return append([]byte(nil), v...)
+	}
+	return nil
+}
+
+// insertAt inserts the segments into data after offset.
+func insertAt(data []byte, offset int, segments ...[]byte) []byte {
+	res := append([]byte(nil), data[:offset]...)
+	for _, s := range segments {
+		res = append(res, s...)
+	}
+	return append(res, data[offset:]...)
+}
+
+func jpegSegment(marker byte, data string) []byte {
+	seg := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(data)+2))
+	return append(seg, data...)
+}
+
+func pngChunk(typ, data string) []byte {
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	crc := crc32.ChecksumIEEE(chunk[4:])
+	return append(chunk, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+}
+
+func id3v2Tag(title string) []byte {
+	frame := []byte("TIT2\x00\x00\x00\x00\x00\x00\x03" + title)
+	frame[7] = byte(len(title) + 1)
+	tag := []byte("ID3\x04\x00\x00\x00\x00\x00\x00")
+	tag[9] = byte(len(frame))
+	return append(tag, frame...)
+}
+
+func id3v1Tag(title string) []byte {
+	tag := make([]byte, 128)
+	copy(tag, "TAG")
+	copy(tag[3:], title)
+	return tag
+}
+
+// mp3Frames creates n MPEG-1 layer III frames of random audio at
+// 128kbit/s and 44.1kHz, which are 417 bytes long.
+func mp3Frames(n int) []byte {
+	const frameLen = 417
+	data := make([]byte, n*frameLen)
+	rand.New(rand.NewSource(19)).Read(data)
+	for i := 0; i < len(data); i += frameLen {
+		copy(data[i:], "\xff\xfb\x90\x64")
+	}
+	return data
+}
+
+func TestPayloadIndicator(t *testing.T) {
+	jpg := encodeImage(t, testImage(64, 48, false), true)
+	otherJPEG := encodeImage(t, testImage(64, 48, true), true)
+	pngData := encodeImage(t, testImage(64, 48, false), false)
+	// the IHDR chunk is right after the signature:
+	const pngIHDREnd = 8 + 12 + 13
+	mp3 := mp3Frames(20)
+	exif := jpegSegment(0xe1, "Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00")
+	xmp := jpegSegment(0xe1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")
+	for _, tc := range []struct {
+		name     string
+		original []byte
+		edited   []byte
+		same     bool
+	}{
+		{"jpegEXIF", jpg, insertAt(jpg, 2, exif), true},
+		{"jpegXMPAndComment", insertAt(jpg, 2, exif), insertAt(jpg, 2, xmp, jpegSegment(0xfe, "edited")), true},
+		{"jpegPixels", jpg, otherJPEG, false},
+		{"jpegICC", jpg, insertAt(jpg, 2, jpegSegment(0xe2, "ICC_PROFILE\x00\x01\x01")), false},
+		{"pngText", pngData, insertAt(pngData, pngIHDREnd, pngChunk("tEXt", "Title\x00photo"), pngChunk("tIME", "\x07\xea\x0a\x10\x0c\x00\x00")), true},
+		{"pngGamma", pngData, insertAt(pngData, pngIHDREnd, pngChunk("gAMA", "\x00\x00\xb1\x8f")), false},
+		{"mp3ID3", mp3, append(append(id3v2Tag("Song"), mp3...), id3v1Tag("Song")...), true},
+		{"mp3ID3Edited", append(id3v2Tag("Old"), mp3...), append(id3v2Tag("New title"), append(mp3, id3v1Tag("New")...)...), true},
+		{"mp3Audio", mp3, append(append([]byte(nil), mp3[:4096]...), mp3[4100:]...), false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			a := payloadOf(t, uniquefile.PayloadIndicator, tc.original)
+			b := payloadOf(t, uniquefile.PayloadIndicator, tc.edited)
+			if len(a) != 32 || len(b) != 32 {
+				t.Fatalf("expected SHA-256 hashes, got %x and %x", a, b)
+			}
+			if same := bytes.Equal(a, b); same != tc.same {
+				t.Fatalf("expected same: %v, actual: %v", tc.same, same)
+			}
+		})
+	}
+}
+
+func TestPayloadIndicatorNotMedia(t *testing.T) {
+	jpg := encodeImage(t, testImage(64, 48, false), true)
+	for _, data := range [][]byte{
+		[]byte("not media"),
+		jpg[:100],
+		[]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"),
+		[]byte("ID3\x04\x00\x00\x00\x00\x00\x7f"),
+		// the UTF-16LE byte order mark looks like an MPEG-1
+		// layer I frame header:
+		[]byte("\xff\xfeh\x00i\x00,\x00 \x00t\x00h\x00e\x00r\x00e\x00!\x00\n\x00"),
+		[]byte("\xff\xfe" + strings.Repeat("h\x00i\x00 \x00", 100)),
+		// a single frame that isn't followed by another:
+		append(mp3Frames(1), "not another frame"...),
+		// bit rate index 0xF:
+		append([]byte("\xff\xfb\xf0\x64"), mp3Frames(2)[4:]...),
+	} {
+		if sig := payloadOf(t, uniquefile.PayloadIndicator, data); sig != nil {
+			t.Fatalf("expected nothing for %q, got %x", data, sig)
+		}
+	}
+}
+
+func TestPayloadIndicatorSpec(t *testing.T) {
+	spec, err := uniquefile.ParseIndicatorSpec("payload:algo=md5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ir, err := uniquefile.NewIndicator(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpg := encodeImage(t, testImage(64, 48, false), true)
+	if sig := payloadOf(t, ir, jpg); len(sig) != 16 {
+		t.Fatalf("expected an MD5 hash, got %x", sig)
+	}
+	if _, ok := uniquefile.ParseIndicator("payload:algo=nope"); ok {
+		t.Fatal("expected an error for an unknown algorithm")
+	}
+}
@@ -0,0 +1,838 @@
+package uniquefile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+)
+
+// The PDF objects are read into these types and into nil (null), bool,
+// int64 and float64.
+type (
+	pdfName   string
+	pdfString []byte
+	pdfArray  []interface{}
+	pdfDict   map[pdfName]interface{}
+
+	// pdfKeyword is a bare word that isn't one of the other types
+	// (e.g. "obj" or "stream").
+	pdfKeyword string
+
+	// pdfRef is a reference to an indirect object.
+	pdfRef struct{ num, gen int }
+
+	// pdfStream is a stream object with its data as it is in the
+	// file.
+	pdfStream struct {
+		dict pdfDict
+		data []byte
+	}
+)
+
+// pdfMaxDepth limits how deeply arrays and dictionaries, page trees and
+// forms can be nested.
+const pdfMaxDepth = 64
+
+// pdfLexer reads PDF objects from data.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		switch c := l.data[l.pos]; {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// regular reads the run of regular characters at the current position.
+func (l *pdfLexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.data) && isPDFRegular(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+// keyword skips whitespace and reads the next run of regular
+// characters.
+func (l *pdfLexer) keyword() string {
+	l.skipSpace()
+	return l.regular()
+}
+
+func (l *pdfLexer) hasPrefix(s string) bool {
+	return bytes.HasPrefix(l.data[l.pos:], []byte(s))
+}
+
+func (l *pdfLexer) readObject(depth int) (interface{}, error) {
+	if depth > pdfMaxDepth {
+		return nil, errDocumentFormat
+	}
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, errDocumentFormat
+	}
+	switch c := l.data[l.pos]; c {
+	case '/':
+		return l.readName(), nil
+	case '(':
+		s, n := pdfLiteralString(l.data[l.pos:])
+		l.pos += n
+		return pdfString(s), nil
+	case '<':
+		if !l.hasPrefix("<<") {
+			s, n := pdfHexString(l.data[l.pos:])
+			l.pos += n
+			return pdfString(s), nil
+		}
+		l.pos += 2
+		dict := pdfDict{}
+		for {
+			l.skipSpace()
+			if l.hasPrefix(">>") {
+				l.pos += 2
+				return dict, nil
+			}
+			key, err := l.readObject(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(pdfName)
+			if !ok {
+				return nil, errDocumentFormat
+			}
+			if dict[name], err = l.readObject(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+	case '[':
+		l.pos++
+		var arr pdfArray
+		for {
+			l.skipSpace()
+			if l.hasPrefix("]") {
+				l.pos++
+				return arr, nil
+			}
+			v, err := l.readObject(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+	}
+	tok := l.regular()
+	switch tok {
+	case "":
+		// a delimiter that can't start an object
+		return nil, errDocumentFormat
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if i, err := strconv.ParseInt(tok, 10, 64); err == nil {
+		// it might be the object number of a reference:
+		start := l.pos
+		if gen, err := strconv.Atoi(l.keyword()); err == nil && l.keyword() == "R" {
+			return pdfRef{num: int(i), gen: gen}, nil
+		}
+		l.pos = start
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return f, nil
+	}
+	return pdfKeyword(tok), nil
+}
+
+// readName reads a name without its leading slash and with its #xx
+// escapes decoded.
+func (l *pdfLexer) readName() pdfName {
+	l.pos++
+	var name []byte
+	for l.pos < len(l.data) && isPDFRegular(l.data[l.pos]) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '#' && l.pos+2 <= len(l.data) {
+			if b, err := strconv.ParseUint(string(l.data[l.pos:l.pos+2]), 16, 8); err == nil {
+				c = byte(b)
+				l.pos += 2
+			}
+		}
+		name = append(name, c)
+	}
+	return pdfName(name)
+}
+
+// pdfXRefEntry is where an object is in the file.
+type pdfXRefEntry struct {
+	free bool
+
+	// stream is the number of the object stream that the object is
+	// compressed into or 0 if it isn't compressed.
+	stream int
+
+	// offset of the object into the file or its index in the
+	// object stream.
+	offset int64
+}
+
+// pdfObjStm is a decoded object stream.
+type pdfObjStm struct {
+	data    []byte
+	nums    []int
+	offsets []int
+}
+
+// pdfDocument reads the objects of a PDF document that is completely
+// in memory.
+type pdfDocument struct {
+	ctx  context.Context
+	data []byte
+
+	// xref has the newest entry of each object.
+	xref    map[int]pdfXRefEntry
+	trailer pdfDict
+
+	objects   map[int]interface{}
+	objStms   map[int]*pdfObjStm
+	resolving map[int]bool
+}
+
+// newPDFDocument reads the cross-reference sections of a PDF document
+// from the last one back through the older ones that incremental
+// updates added to.  If they can't be read, the objects are found by
+// scanning the whole document instead.
+func newPDFDocument(ctx context.Context, data []byte) (*pdfDocument, error) {
+	doc := &pdfDocument{ctx: ctx, data: data}
+	doc.reset()
+	if err := doc.readXRefs(); err != nil {
+		if !isCorruptDocument(err) {
+			return nil, err
+		}
+		doc.reset()
+		if err := doc.scanObjects(); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func (doc *pdfDocument) reset() {
+	doc.xref = make(map[int]pdfXRefEntry)
+	doc.trailer = pdfDict{}
+	doc.objects = make(map[int]interface{})
+	doc.objStms = make(map[int]*pdfObjStm)
+	doc.resolving = make(map[int]bool)
+}
+
+// addXRef adds an entry unless a newer section already had the object.
+func (doc *pdfDocument) addXRef(num int, e pdfXRefEntry) {
+	if _, ok := doc.xref[num]; !ok {
+		doc.xref[num] = e
+	}
+}
+
+// addTrailer adds the entries of an older trailer that the newer ones
+// didn't have.
+func (doc *pdfDocument) addTrailer(trailer pdfDict) {
+	for k, v := range trailer {
+		if _, ok := doc.trailer[k]; !ok {
+			doc.trailer[k] = v
+		}
+	}
+}
+
+func (doc *pdfDocument) readXRefs() error {
+	i := bytes.LastIndex(doc.data, []byte("startxref"))
+	if i == -1 {
+		return errDocumentFormat
+	}
+	l := pdfLexer{data: doc.data, pos: i + len("startxref")}
+	offset, err := strconv.ParseInt(l.keyword(), 10, 64)
+	if err != nil {
+		return errDocumentFormat
+	}
+	seen := make(map[int64]bool)
+	for !seen[offset] {
+		seen[offset] = true
+		trailer, err := doc.readXRefSection(offset, seen)
+		if err != nil {
+			return err
+		}
+		doc.addTrailer(trailer)
+		prev, ok := trailer["Prev"].(int64)
+		if !ok {
+			break
+		}
+		offset = prev
+	}
+	if _, ok := doc.trailer["Root"]; !ok {
+		return errDocumentFormat
+	}
+	return nil
+}
+
+// readXRefSection reads a cross-reference table and its trailer or a
+// cross-reference stream at offset and returns the trailer or the
+// stream's dictionary.
+func (doc *pdfDocument) readXRefSection(offset int64, seen map[int64]bool) (pdfDict, error) {
+	if offset < 0 || offset >= int64(len(doc.data)) {
+		return nil, errDocumentFormat
+	}
+	l := pdfLexer{data: doc.data, pos: int(offset)}
+	if l.keyword() != "xref" {
+		obj, err := doc.readIndirect(offset, -1)
+		if err != nil {
+			return nil, err
+		}
+		stm, ok := obj.(*pdfStream)
+		if !ok || stm.dict["Type"] != pdfName("XRef") {
+			return nil, errDocumentFormat
+		}
+		return stm.dict, doc.addXRefStream(stm)
+	}
+	type entry struct {
+		num int
+		pdfXRefEntry
+	}
+	var entries []entry
+	for {
+		start := l.pos
+		if l.keyword() == "trailer" {
+			break
+		}
+		l.pos = start
+		first, err1 := strconv.Atoi(l.keyword())
+		count, err2 := strconv.Atoi(l.keyword())
+		// each entry is 20 bytes:
+		if err1 != nil || err2 != nil || first < 0 || count < 0 || count > (len(doc.data)-l.pos)/20+1 {
+			return nil, errDocumentFormat
+		}
+		for k := 0; k < count; k++ {
+			off, err := strconv.ParseInt(l.keyword(), 10, 64)
+			if err != nil {
+				return nil, errDocumentFormat
+			}
+			l.keyword() // generation
+			e := entry{num: first + k}
+			switch l.keyword() {
+			case "n":
+				e.offset = off
+			case "f":
+				e.free = true
+			default:
+				return nil, errDocumentFormat
+			}
+			entries = append(entries, e)
+		}
+	}
+	obj, err := l.readObject(0)
+	if err != nil {
+		return nil, err
+	}
+	trailer, ok := obj.(pdfDict)
+	if !ok {
+		return nil, errDocumentFormat
+	}
+	// The objects in the cross-reference stream of a hybrid file
+	// take precedence over the table's entries.
+	if stm, ok := trailer["XRefStm"].(int64); ok && !seen[stm] {
+		seen[stm] = true
+		if _, err := doc.readXRefSection(stm, seen); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range entries {
+		doc.addXRef(e.num, e.pdfXRefEntry)
+	}
+	return trailer, nil
+}
+
+func (doc *pdfDocument) addXRefStream(stm *pdfStream) error {
+	data, err := doc.decodeStream(stm)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return errDocumentFormat
+	}
+	w, ok := stm.dict["W"].(pdfArray)
+	if !ok || len(w) != 3 {
+		return errDocumentFormat
+	}
+	var widths [3]int
+	row := 0
+	for i, v := range w {
+		n, ok := v.(int64)
+		if !ok || n < 0 || n > 8 {
+			return errDocumentFormat
+		}
+		widths[i] = int(n)
+		row += int(n)
+	}
+	index, ok := stm.dict["Index"].(pdfArray)
+	if !ok {
+		index = pdfArray{int64(0), stm.dict["Size"]}
+	}
+	if len(index)%2 != 0 || row == 0 {
+		return errDocumentFormat
+	}
+	field := func(bs []byte, def int64) int64 {
+		if len(bs) == 0 {
+			return def
+		}
+		var n int64
+		for _, b := range bs {
+			n = n<<8 | int64(b)
+		}
+		return n
+	}
+	for i := 0; i < len(index); i += 2 {
+		first, ok1 := index[i].(int64)
+		count, ok2 := index[i+1].(int64)
+		if !ok1 || !ok2 || first < 0 || count < 0 || count > int64(len(data)/row) {
+			return errDocumentFormat
+		}
+		for k := int64(0); k < count; k++ {
+			if len(data) < row {
+				return errDocumentFormat
+			}
+			f1 := data[:widths[0]]
+			f2 := data[widths[0] : widths[0]+widths[1]]
+			f3 := data[widths[0]+widths[1] : row]
+			data = data[row:]
+			var e pdfXRefEntry
+			switch field(f1, 1) {
+			case 0:
+				e.free = true
+			case 1:
+				e.offset = field(f2, 0)
+			case 2:
+				e.stream = int(field(f2, 0))
+				e.offset = field(f3, 0)
+			default:
+				// unknown types are null objects
+				e.free = true
+			}
+			doc.addXRef(int(first+k), e)
+		}
+	}
+	return nil
+}
+
+// scanObjects finds the objects of a document whose cross-reference
+// sections can't be read by looking for their "obj" keywords.  When an
+// object was updated, the last one in the file wins.
+func (doc *pdfDocument) scanObjects() error {
+	data := doc.data
+	for i := 0; ; {
+		if err := doc.ctx.Err(); err != nil {
+			return err
+		}
+		j := bytes.Index(data[i:], []byte("obj"))
+		if j == -1 {
+			break
+		}
+		j += i
+		i = j + len("obj")
+		if i < len(data) && isPDFRegular(data[i]) {
+			continue
+		}
+		// walk back over "num gen ":
+		k := j
+		var nums [2]int
+		ok := true
+		for n := 1; n >= 0 && ok; n-- {
+			end := k
+			for k > 0 && isPDFSpace(data[k-1]) {
+				k--
+			}
+			if k == end {
+				ok = false
+				break
+			}
+			end = k
+			for k > 0 && data[k-1] >= '0' && data[k-1] <= '9' {
+				k--
+			}
+			var err error
+			if nums[n], err = strconv.Atoi(string(data[k:end])); err != nil {
+				ok = false
+			}
+		}
+		if ok && (k == 0 || !isPDFRegular(data[k-1])) {
+			doc.xref[nums[0]] = pdfXRefEntry{offset: int64(k)}
+		}
+	}
+	for i := len(data); ; {
+		i = bytes.LastIndex(data[:i], []byte("trailer"))
+		if i == -1 {
+			break
+		}
+		l := pdfLexer{data: data, pos: i + len("trailer")}
+		if obj, err := l.readObject(0); err == nil {
+			if trailer, ok := obj.(pdfDict); ok {
+				doc.addTrailer(trailer)
+			}
+		}
+	}
+	nums := make([]int, 0, len(doc.xref))
+	for num := range doc.xref {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	// The objects in object streams and the trailer entries of
+	// cross-reference streams can only be found by reading the
+	// objects.
+	for _, num := range nums {
+		obj, err := doc.resolve(pdfRef{num: num})
+		if err != nil {
+			if isCorruptDocument(err) {
+				continue
+			}
+			return err
+		}
+		stm, ok := obj.(*pdfStream)
+		if !ok {
+			continue
+		}
+		switch stm.dict["Type"] {
+		case pdfName("ObjStm"):
+			os, err := doc.objStm(num)
+			if err != nil {
+				if isCorruptDocument(err) {
+					continue
+				}
+				return err
+			}
+			for k, n := range os.nums {
+				doc.addXRef(n, pdfXRefEntry{stream: num, offset: int64(k)})
+			}
+		case pdfName("XRef"):
+			doc.addTrailer(stm.dict)
+		}
+	}
+	if _, ok := doc.trailer["Root"]; ok {
+		return nil
+	}
+	for _, num := range nums {
+		if obj, err := doc.resolve(pdfRef{num: num}); err == nil {
+			if dict, ok := obj.(pdfDict); ok && dict["Type"] == pdfName("Catalog") {
+				doc.trailer["Root"] = pdfRef{num: num}
+				return nil
+			}
+		}
+	}
+	return errDocumentFormat
+}
+
+// resolve gets the object that v refers to if it is a reference or v
+// itself if it isn't.  References to objects that don't exist are
+// null.
+func (doc *pdfDocument) resolve(v interface{}) (interface{}, error) {
+	ref, ok := v.(pdfRef)
+	if !ok {
+		return v, nil
+	}
+	if obj, ok := doc.objects[ref.num]; ok {
+		return obj, nil
+	}
+	if doc.resolving[ref.num] {
+		return nil, errDocumentFormat
+	}
+	if err := doc.ctx.Err(); err != nil {
+		return nil, err
+	}
+	e, ok := doc.xref[ref.num]
+	if !ok || e.free {
+		return nil, nil
+	}
+	doc.resolving[ref.num] = true
+	defer delete(doc.resolving, ref.num)
+	var obj interface{}
+	var err error
+	if e.stream != 0 {
+		obj, err = doc.readCompressed(e.stream, int(e.offset), ref.num)
+	} else {
+		obj, err = doc.readIndirect(e.offset, ref.num)
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc.objects[ref.num] = obj
+	return obj, nil
+}
+
+// resolveDict resolves v and returns it if it is a dictionary or a
+// stream's dictionary.
+func (doc *pdfDocument) resolveDict(v interface{}) (pdfDict, error) {
+	obj, err := doc.resolve(v)
+	if err != nil {
+		return nil, err
+	}
+	switch obj := obj.(type) {
+	case pdfDict:
+		return obj, nil
+	case *pdfStream:
+		return obj.dict, nil
+	}
+	return nil, nil
+}
+
+// readIndirect reads the indirect object at offset.  If num isn't
+// negative, the object must have that number.
+func (doc *pdfDocument) readIndirect(offset int64, num int) (interface{}, error) {
+	if offset < 0 || offset >= int64(len(doc.data)) {
+		return nil, errDocumentFormat
+	}
+	l := pdfLexer{data: doc.data, pos: int(offset)}
+	n, err := strconv.Atoi(l.keyword())
+	if err != nil || (num >= 0 && n != num) {
+		return nil, errDocumentFormat
+	}
+	if _, err = strconv.Atoi(l.keyword()); err != nil || l.keyword() != "obj" {
+		return nil, errDocumentFormat
+	}
+	obj, err := l.readObject(0)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := obj.(pdfDict)
+	if !ok {
+		return obj, nil
+	}
+	start := l.pos
+	if l.keyword() != "stream" {
+		l.pos = start
+		return dict, nil
+	}
+	// The stream keyword is followed by CRLF or LF (or, in files
+	// that get it wrong, CR).
+	if l.hasPrefix("\r") {
+		l.pos++
+	}
+	if l.hasPrefix("\n") {
+		l.pos++
+	}
+	start = l.pos
+	if v, err := doc.resolve(dict["Length"]); err == nil {
+		if n, ok := v.(int64); ok && n >= 0 && n <= int64(len(doc.data)-start) {
+			end := pdfLexer{data: doc.data, pos: start + int(n)}
+			if end.keyword() == "endstream" {
+				return &pdfStream{dict: dict, data: doc.data[start : start+int(n)]}, nil
+			}
+		}
+	} else if !isCorruptDocument(err) {
+		return nil, err
+	}
+	// The length is wrong, so the stream ends at its endstream
+	// keyword and the end of line before it.
+	end := bytes.Index(doc.data[start:], []byte("endstream"))
+	if end == -1 {
+		return nil, errDocumentFormat
+	}
+	data := doc.data[start : start+end]
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	data = bytes.TrimSuffix(data, []byte("\r"))
+	return &pdfStream{dict: dict, data: data}, nil
+}
+
+// readCompressed reads object num from the index'th position of an
+// object stream.
+func (doc *pdfDocument) readCompressed(stream, index, num int) (interface{}, error) {
+	os, err := doc.objStm(stream)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(os.nums) || os.nums[index] != num {
+		index = -1
+		for i, n := range os.nums {
+			if n == num {
+				index = i
+			}
+		}
+		if index == -1 {
+			return nil, nil
+		}
+	}
+	l := pdfLexer{data: os.data, pos: os.offsets[index]}
+	return l.readObject(0)
+}
+
+func (doc *pdfDocument) objStm(num int) (*pdfObjStm, error) {
+	if os, ok := doc.objStms[num]; ok {
+		return os, nil
+	}
+	obj, err := doc.resolve(pdfRef{num: num})
+	if err != nil {
+		return nil, err
+	}
+	stm, ok := obj.(*pdfStream)
+	if !ok {
+		return nil, errDocumentFormat
+	}
+	data, err := doc.decodeStream(stm)
+	if err != nil {
+		return nil, err
+	}
+	n, ok1 := stm.dict["N"].(int64)
+	first, ok2 := stm.dict["First"].(int64)
+	if data == nil || !ok1 || !ok2 || n < 0 || first < 0 || first > int64(len(data)) || n > int64(len(data)) {
+		return nil, errDocumentFormat
+	}
+	os := &pdfObjStm{data: data}
+	l := pdfLexer{data: data[:first]}
+	for i := int64(0); i < n; i++ {
+		num, err1 := strconv.Atoi(l.keyword())
+		off, err2 := strconv.Atoi(l.keyword())
+		if err1 != nil || err2 != nil || off < 0 || int64(off) > int64(len(data))-first {
+			return nil, errDocumentFormat
+		}
+		os.nums = append(os.nums, num)
+		os.offsets = append(os.offsets, int(first)+off)
+	}
+	doc.objStms[num] = os
+	return os, nil
+}
+
+// decodeStream decodes the data of a stream that is uncompressed or
+// compressed with FlateDecode.  If the stream has other filters, nil
+// is returned without an error.
+func (doc *pdfDocument) decodeStream(stm *pdfStream) ([]byte, error) {
+	filter, err := doc.resolve(stm.dict["Filter"])
+	if err != nil {
+		return nil, err
+	}
+	parms, err := doc.resolve(stm.dict["DecodeParms"])
+	if err != nil {
+		return nil, err
+	}
+	if arr, ok := filter.(pdfArray); ok {
+		switch len(arr) {
+		case 0:
+			filter = nil
+		case 1:
+			filter = arr[0]
+			if ps, ok := parms.(pdfArray); ok && len(ps) == 1 {
+				parms = ps[0]
+			}
+		default:
+			return nil, nil
+		}
+	}
+	switch filter {
+	case nil:
+		return stm.data, nil
+	case pdfName("FlateDecode"), pdfName("Fl"):
+	default:
+		return nil, nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(stm.data))
+	if err != nil {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(zr, pdfStreamLimit))
+	if err != nil && !isCorruptCompression(err) {
+		return nil, err
+	}
+	p, err := doc.resolveDict(parms)
+	if err != nil {
+		return nil, err
+	}
+	predictor, _ := p["Predictor"].(int64)
+	switch {
+	case predictor <= 1:
+		return data, nil
+	case predictor < 10:
+		// TIFF predictors aren't supported
+		return nil, nil
+	}
+	columns, colors, bpc := int64(1), int64(1), int64(8)
+	for _, v := range []struct {
+		name pdfName
+		n    *int64
+	}{{"Columns", &columns}, {"Colors", &colors}, {"BitsPerComponent", &bpc}} {
+		if n, ok := p[v.name].(int64); ok {
+			*v.n = n
+		}
+	}
+	if columns <= 0 || colors <= 0 || bpc <= 0 || columns*colors*bpc > 1<<20 {
+		return nil, errDocumentFormat
+	}
+	bpp := int(colors*bpc+7) / 8
+	return pngUnpredict(data, int(columns*colors*bpc+7)/8, bpp)
+}
+
+// pngUnpredict reverses the PNG predictors that FlateDecode streams
+// use with a Predictor of 10 or more.  Each row starts with the type of
+// its predictor.
+func pngUnpredict(data []byte, rowLen, bpp int) ([]byte, error) {
+	out := make([]byte, 0, len(data)/(rowLen+1)*rowLen)
+	prev := make([]byte, rowLen)
+	for len(data) > rowLen {
+		typ, row := data[0], data[1:rowLen+1]
+		data = data[rowLen+1:]
+		cur := make([]byte, rowLen)
+		for i, c := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = cur[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch typ {
+			case 0:
+			case 1:
+				c += left
+			case 2:
+				c += up
+			case 3:
+				c += byte((int(left) + int(up)) / 2)
+			case 4:
+				c += paeth(left, up, upLeft)
+			default:
+				return nil, errDocumentFormat
+			}
+			cur[i] = c
+		}
+		out = append(out, cur...)
+		prev = cur
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	switch {
+	case pa <= pb && pa <= pc:
+		return a
+	case pb <= pc:
+		return b
+	}
+	return c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
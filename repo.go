@@ -21,3 +21,60 @@ type Repo interface {
 	// are Indications.
 	URIs(ctx context.Context, query expr.Expr) ([]URI, error)
 }
+
+// ChunkRepo is a Repo that can find resources by the chunks that a
+// chunks indicator (see NewChunksIndicator) found in them.
+type ChunkRepo interface {
+	Repo
+
+	// ChunkURIs returns the URIs of the resources whose chunk list
+	// indicated under key includes chunk.
+	ChunkURIs(ctx context.Context, key Bytes, chunk Chunk) ([]URI, error)
+}
+
+// Collisions returns the URIs other than u whose indications in the
+// Repo match every key and value in ind.  Each key is queried
+// separately and the results are intersected so that an Indication
+// with more than one key still matches other resources that have
+// those keys alongside keys of their own.
+func Collisions(ctx context.Context, r Repo, u URI, ind *Indication) (uris []URI, err error) {
+	query := NewIndication()
+	defer PutIndication(&query)
+	first := true
+	err = ind.Each(func(key, value []byte) error {
+		query.Reset()
+		query.Write(key, value)
+		matches, err := r.URIs(ctx, query)
+		if err != nil {
+			return err
+		}
+		if first {
+			first = false
+			seen := make(map[URI]struct{}, len(matches))
+			for _, m := range matches {
+				if _, ok := seen[m]; ok || m == u {
+					continue
+				}
+				seen[m] = struct{}{}
+				uris = append(uris, m)
+			}
+			return nil
+		}
+		matched := make(map[URI]struct{}, len(matches))
+		for _, m := range matches {
+			matched[m] = struct{}{}
+		}
+		kept := uris[:0]
+		for _, m := range uris {
+			if _, ok := matched[m]; ok {
+				kept = append(kept, m)
+			}
+		}
+		uris = kept
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uris, nil
+}
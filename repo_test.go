@@ -0,0 +1,125 @@
+package uniquefile_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/skillian/expr"
+	"github.com/skillian/uniquefile"
+)
+
+// memRepo is a uniquefile.Repo that only supports querying by a single
+// *uniquefile.Indication.
+type memRepo map[uniquefile.URI]uniquefile.IndicationLookup
+
+func (r memRepo) Indications(ctx context.Context, u uniquefile.URI) (*uniquefile.Indication, error) {
+	ind := uniquefile.NewIndication()
+	r[u].WriteToIndication(ind)
+	return ind, nil
+}
+
+func (r memRepo) SetIndications(ctx context.Context, u uniquefile.URI, ind *uniquefile.Indication) error {
+	lookup, err := ind.Lookup()
+	if err != nil {
+		return err
+	}
+	for k, v := range lookup {
+		lookup[k] = append([]byte(nil), v...)
+	}
+	r[u] = lookup
+	return nil
+}
+
+func (r memRepo) URIs(ctx context.Context, query expr.Expr) (uris []uniquefile.URI, err error) {
+	q, ok := query.(*uniquefile.Indication)
+	if !ok {
+		return nil, fmt.Errorf("unsupported query: %v", query)
+	}
+	want, err := q.Lookup()
+	if err != nil {
+		return nil, err
+	}
+	for u, have := range r {
+		match := true
+		for k, v := range want {
+			if hv, ok := have[k]; !ok || !bytes.Equal(hv, v) {
+				match = false
+				break
+			}
+		}
+		if match {
+			uris = append(uris, u)
+		}
+	}
+	return
+}
+
+func (r memRepo) BandURIs(ctx context.Context, key uniquefile.Bytes, band uniquefile.MinHashBand) (uris []uniquefile.URI, err error) {
+	for u, have := range r {
+		v, ok := have[key]
+		if !ok {
+			continue
+		}
+		bands, err := uniquefile.MinHashBandsOf(v)
+		if err != nil {
+			return nil, err
+		}
+		if bands[band.Index] == band {
+			uris = append(uris, u)
+		}
+	}
+	return
+}
+
+func (r memRepo) ChunkURIs(ctx context.Context, key uniquefile.Bytes, chunk uniquefile.Chunk) (uris []uniquefile.URI, err error) {
+	for u, have := range r {
+		v, ok := have[key]
+		if !ok {
+			continue
+		}
+		chunks, err := uniquefile.ParseChunks(v)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range chunks {
+			if c == chunk {
+				uris = append(uris, u)
+				break
+			}
+		}
+	}
+	return
+}
+
+func TestCollisions(t *testing.T) {
+	ctx := context.Background()
+	uri := func(p string) uniquefile.URI {
+		return uniquefile.URI{Scheme: uniquefile.FileScheme, Path: p}
+	}
+	r := memRepo{
+		uri("/a"): {"length": []byte{1}, "sha256": []byte{1}},
+		uri("/b"): {"length": []byte{1}, "sha256": []byte{2}},
+		uri("/c"): {"length": []byte{1}},
+		uri("/d"): {"length": []byte{2}},
+	}
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	ind.Write([]byte("length"), []byte{1})
+	uris, err := uniquefile.Collisions(ctx, r, uri("/a"), ind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uris) != 2 {
+		t.Fatalf("expected /b and /c to collide with /a, got %v", uris)
+	}
+	ind.Write([]byte("sha256"), []byte{1})
+	uris, err = uniquefile.Collisions(ctx, r, uri("/a"), ind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uris) != 0 {
+		t.Fatalf("expected no collisions with /a, got %v", uris)
+	}
+}
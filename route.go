@@ -0,0 +1,170 @@
+package uniquefile
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/skillian/errors"
+)
+
+// Route selects the Indicators for data by its detected media type or
+// by its file name extension.
+type Route struct {
+	// MIMETypes are patterns matched against the data's media type
+	// with MatchMIMEType (e.g. "image/" or "application/zip").
+	MIMETypes []string
+
+	// Extensions are file name extensions including the leading
+	// dot (e.g. ".go").  They are compared case-insensitively.
+	Extensions []string
+
+	// Indicators indicate the data when the route matches.
+	Indicators []Indicator
+}
+
+// Match checks if the route matches data of the given media type and
+// file name extension.  A route without any MIMETypes or Extensions
+// matches everything.
+func (rt Route) Match(mimeType, ext string) bool {
+	if len(rt.MIMETypes) == 0 && len(rt.Extensions) == 0 {
+		return true
+	}
+	if ext != "" {
+		for _, e := range rt.Extensions {
+			if strings.EqualFold(e, ext) {
+				return true
+			}
+		}
+	}
+	return MatchMIMEType(mimeType, rt.MIMETypes...)
+}
+
+// DefaultRouteLimit is the default limit on the size of data that a
+// RoutedIndicator buffers when its reader cannot seek.
+const DefaultRouteLimit = 256 << 20
+
+// RoutedIndicator is an Indicator that chooses other Indicators to
+// indicate its data depending on the data's type.
+type RoutedIndicator struct {
+	limit  int64
+	routes []Route
+}
+
+var _ Indicator = (*RoutedIndicator)(nil)
+
+// NewRoutedIndicator creates an Indicator that detects its data's
+// media type with DetectContentType and runs the Indicators of the
+// first route that matches it.  If the reader passed to Indicate has a
+// Name method (like *os.File), the extension of that name is matched
+// against the routes' Extensions too.  If no route matches, nothing is
+// written, so the last route usually has no MIMETypes or Extensions to
+// catch everything else.
+//
+// Every Indicator of a route reads the data from the beginning, so when
+// the reader passed to Indicate isn't an io.ReadSeeker (like the pipes
+// that NewIndicators passes to its Indicators), data of up to limit
+// bytes is buffered in memory.  Larger data is only indicated by the
+// route's first Indicator and the rest are skipped.
+func NewRoutedIndicator(limit int64, routes ...Route) (*RoutedIndicator, error) {
+	if limit <= 0 {
+		return nil, errors.Errorf(
+			"route size limit must be positive, not %d", limit,
+		)
+	}
+	for i, rt := range routes {
+		if len(rt.Indicators) == 0 {
+			return nil, errors.Errorf(
+				"route %d has no indicators", i,
+			)
+		}
+		for _, ext := range rt.Extensions {
+			if !strings.HasPrefix(ext, ".") {
+				return nil, errors.Errorf(
+					"route %d's extension %q must "+
+						"start with a dot",
+					i, ext,
+				)
+			}
+		}
+	}
+	return &RoutedIndicator{limit: limit, routes: routes}, nil
+}
+
+// Indicators returns all of the Indicators that the routes can choose.
+func (ri *RoutedIndicator) Indicators() []Indicator {
+	var irs []Indicator
+	for _, rt := range ri.routes {
+		irs = append(irs, rt.Indicators...)
+	}
+	return irs
+}
+
+// Route finds the first route that matches the media type and file
+// name extension.
+func (ri *RoutedIndicator) Route(mimeType, ext string) (Route, bool) {
+	for _, rt := range ri.routes {
+		if rt.Match(mimeType, ext) {
+			return rt, true
+		}
+	}
+	return Route{}, false
+}
+
+func (ri *RoutedIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	var ext string
+	if n, ok := r.(interface{ Name() string }); ok {
+		ext = path.Ext(strings.ReplaceAll(n.Name(), "\\", "/"))
+	}
+	sk, seekable := r.(io.ReadSeeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = sk.Seek(0, io.SeekCurrent); err != nil {
+			return err
+		}
+	}
+	head := make([]byte, mimeTypeSniffLen)
+	n, err := io.ReadFull(readerContext{ctx, r}, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+	rt, ok := ri.Route(DetectContentType(head), ext)
+	if !ok {
+		return nil
+	}
+	if !seekable {
+		rest := io.MultiReader(bytes.NewReader(head), r)
+		if len(rt.Indicators) == 1 {
+			return rt.Indicators[0].Indicate(ctx, rest, ind)
+		}
+		// Every Indicator must read the data from the beginning,
+		// so data that cannot be rewound is buffered unless it
+		// is over the limit.
+		var buf bytes.Buffer
+		n, err := copyContext(ctx, &buf, io.LimitReader(rest, ri.limit+1), nil)
+		if err != nil {
+			return err
+		}
+		if n > ri.limit {
+			return rt.Indicators[0].Indicate(
+				ctx, io.MultiReader(&buf, r), ind,
+			)
+		}
+		sk = bytes.NewReader(buf.Bytes())
+	}
+	for i, ir := range rt.Indicators {
+		if i > 0 || seekable {
+			if _, err := sk.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		if err := ir.Indicate(ctx, sk, ind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
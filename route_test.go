@@ -0,0 +1,198 @@
+package uniquefile_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"testing/iotest"
+
+	"github.com/skillian/uniquefile"
+)
+
+// lengthKeyIndicator writes the length of all of its data under its
+// key so tests can tell which indicators ran and that they read all of
+// the data.
+type lengthKeyIndicator string
+
+func (ir lengthKeyIndicator) Indicate(ctx context.Context, r io.Reader, ind *uniquefile.Indication) error {
+	n, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return err
+	}
+	ind.Write([]byte(ir), []byte(strconv.FormatInt(n, 10)))
+	return nil
+}
+
+func TestRoutedIndicator(t *testing.T) {
+	ri, err := uniquefile.NewRoutedIndicator(
+		uniquefile.DefaultRouteLimit,
+		uniquefile.Route{
+			MIMETypes:  []string{"image/"},
+			Indicators: []uniquefile.Indicator{lengthKeyIndicator("image"), lengthKeyIndicator("image2")},
+		},
+		uniquefile.Route{
+			Extensions: []string{".go"},
+			Indicators: []uniquefile.Indicator{lengthKeyIndicator("source")},
+		},
+		uniquefile.Route{
+			Indicators: []uniquefile.Indicator{lengthKeyIndicator("other")},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png := encodeImage(t, testImage(64, 64, false), false)
+	big := bytes.Repeat([]byte("not an image. "), 10000)
+	dir, err := ioutil.TempDir("", "uniquefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "main.GO")
+	if err := ioutil.WriteFile(source, []byte("package main\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		open   func() (io.Reader, error)
+		expect map[string]string
+	}{
+		{
+			"image",
+			func() (io.Reader, error) { return bytes.NewReader(png), nil },
+			map[string]string{
+				"image":  strconv.Itoa(len(png)),
+				"image2": strconv.Itoa(len(png)),
+			},
+		},
+		{
+			"imageNotSeekable",
+			func() (io.Reader, error) {
+				return iotest.OneByteReader(bytes.NewReader(png)), nil
+			},
+			map[string]string{
+				"image":  strconv.Itoa(len(png)),
+				"image2": strconv.Itoa(len(png)),
+			},
+		},
+		{
+			"extension",
+			func() (io.Reader, error) { return os.Open(source) },
+			map[string]string{"source": "13"},
+		},
+		{
+			"fallback",
+			func() (io.Reader, error) {
+				return iotest.HalfReader(bytes.NewReader(big)), nil
+			},
+			map[string]string{"other": strconv.Itoa(len(big))},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := tc.open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c, ok := r.(io.Closer); ok {
+				defer c.Close()
+			}
+			ind := uniquefile.NewIndication()
+			defer uniquefile.PutIndication(&ind)
+			if err := ri.Indicate(context.Background(), r, ind); err != nil {
+				t.Fatal(err)
+			}
+			lookup, err := ind.Lookup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lookup) != len(tc.expect) {
+				t.Fatalf("expected %v, actual %q", tc.expect, lookup)
+			}
+			for k, v := range tc.expect {
+				if string(lookup[uniquefile.Bytes(k)]) != v {
+					t.Fatalf("expected %v, actual %q", tc.expect, lookup)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRoutedIndicatorInvalid(t *testing.T) {
+	for _, routes := range [][]uniquefile.Route{
+		{{MIMETypes: []string{"image/"}}},
+		{{Extensions: []string{"go"}, Indicators: []uniquefile.Indicator{uniquefile.SHA256Indicator}}},
+	} {
+		if _, err := uniquefile.NewRoutedIndicator(uniquefile.DefaultRouteLimit, routes...); err == nil {
+			t.Fatalf("expected error from routes %v", routes)
+		}
+	}
+	routes := []uniquefile.Route{{Indicators: []uniquefile.Indicator{uniquefile.SHA256Indicator}}}
+	if _, err := uniquefile.NewRoutedIndicator(0, routes...); err == nil {
+		t.Fatal("expected error from limit 0")
+	}
+}
+
+func TestRoutedIndicatorLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("not an image. "), 100)
+	ri, err := uniquefile.NewRoutedIndicator(
+		int64(len(data)),
+		uniquefile.Route{
+			Indicators: []uniquefile.Indicator{lengthKeyIndicator("first"), lengthKeyIndicator("second")},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		r      func([]byte) io.Reader
+		expect []string
+	}{
+		{
+			"atLimit",
+			data,
+			func(p []byte) io.Reader { return iotest.OneByteReader(bytes.NewReader(p)) },
+			[]string{"first", "second"},
+		},
+		{
+			"overLimit",
+			append(data, '!'),
+			func(p []byte) io.Reader { return iotest.OneByteReader(bytes.NewReader(p)) },
+			[]string{"first"},
+		},
+		{
+			"overLimitSeekable",
+			append(data, '!'),
+			func(p []byte) io.Reader { return bytes.NewReader(p) },
+			[]string{"first", "second"},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ind := uniquefile.NewIndication()
+			defer uniquefile.PutIndication(&ind)
+			if err := ri.Indicate(context.Background(), tc.r(tc.data), ind); err != nil {
+				t.Fatal(err)
+			}
+			lookup, err := ind.Lookup()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lookup) != len(tc.expect) {
+				t.Fatalf("expected %v, actual %q", tc.expect, lookup)
+			}
+			for _, k := range tc.expect {
+				if string(lookup[uniquefile.Bytes(k)]) != strconv.Itoa(len(tc.data)) {
+					t.Fatalf("expected %v of %d bytes, actual %q", tc.expect, len(tc.data), lookup)
+				}
+			}
+		})
+	}
+}
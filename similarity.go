@@ -0,0 +1,327 @@
+package uniquefile
+
+import (
+	"context"
+	"sort"
+)
+
+// IndicatorSimilarer can be implemented by Indicators whose values can
+// be similar without being equal (e.g. perceptual hashes or lists of
+// chunks).  It is a companion to IndicatorCmper which only orders
+// values.
+type IndicatorSimilarer interface {
+	// Keys returns the keys that this IndicatorSimilarer can
+	// compare.
+	Keys() []Bytes
+
+	// Similarity compares two values of the same key and returns
+	// a score from 0 (nothing in common) to 1 (the same).
+	Similarity(ctx context.Context, key, a, b []byte) (float64, error)
+
+	// Threshold is the default similarity at or above which two
+	// values of key are considered near-duplicates.
+	Threshold(key []byte) float64
+}
+
+// NearDuplicate is a pair of resources whose indications are similar.
+type NearDuplicate struct {
+	A, B       URI
+	Key        Bytes
+	Similarity float64
+}
+
+// IndicatorBander can be implemented by IndicatorSimilarers so that
+// FindNearDuplicates doesn't have to compare every pair of values.
+type IndicatorBander interface {
+	// Bands returns the bands of a value of key.  Two values whose
+	// similarity is at or above threshold have at least one band in
+	// common (or, if the bands are locality-sensitive hashes like
+	// MinHash bands, very likely do), so only values that have a
+	// band in common have to be compared.
+	Bands(ctx context.Context, key, value []byte, threshold float64) ([][]byte, error)
+}
+
+// FindNearDuplicates compares the indications with each other by the
+// keys of s and returns the pairs whose similarity is at or above the
+// threshold, most similar first.  If threshold is 0, the
+// IndicatorSimilarer's own threshold for each key is used.
+//
+// If s is an IndicatorBander, the values are bucketed by their bands
+// and only the values in the same bucket are compared, so the time it
+// takes grows about linearly with the number of indications unless
+// many values share bands.  Otherwise, every pair of values is
+// compared, which takes time that grows quadratically.
+func FindNearDuplicates(ctx context.Context, s IndicatorSimilarer, inds map[URI]*Indication, threshold float64) ([]NearDuplicate, error) {
+	uris := make([]URI, 0, len(inds))
+	for u := range inds {
+		uris = append(uris, u)
+	}
+	sort.Slice(uris, func(i, j int) bool {
+		return uris[i].String() < uris[j].String()
+	})
+	lookups := make([]IndicationLookup, len(uris))
+	for i, u := range uris {
+		var err error
+		if lookups[i], err = inds[u].Lookup(); err != nil {
+			return nil, err
+		}
+	}
+	bander, _ := s.(IndicatorBander)
+	var nds []NearDuplicate
+	for _, key := range s.Keys() {
+		min := threshold
+		if min == 0 {
+			min = s.Threshold([]byte(key))
+		}
+		values := make([][]byte, len(uris))
+		for i, lookup := range lookups {
+			values[i] = lookup[key]
+		}
+		var pairs [][2]int
+		if bander != nil {
+			var err error
+			if pairs, err = bandedPairs(ctx, bander, []byte(key), values, min); err != nil {
+				return nil, err
+			}
+		} else {
+			for i, a := range values {
+				if a == nil {
+					continue
+				}
+				for j := i + 1; j < len(values); j++ {
+					if values[j] != nil {
+						pairs = append(pairs, [2]int{i, j})
+					}
+				}
+			}
+		}
+		for _, p := range pairs {
+			sim, err := s.Similarity(ctx, []byte(key), values[p[0]], values[p[1]])
+			if err != nil {
+				return nil, err
+			}
+			if sim >= min {
+				nds = append(nds, NearDuplicate{
+					A:          uris[p[0]],
+					B:          uris[p[1]],
+					Key:        key,
+					Similarity: sim,
+				})
+			}
+		}
+	}
+	sort.SliceStable(nds, func(i, j int) bool {
+		return nds[i].Similarity > nds[j].Similarity
+	})
+	return nds, nil
+}
+
+// bandedPairs buckets the values by their bands and returns the pairs
+// of indexes of the values that are in the same bucket, sorted.
+func bandedPairs(ctx context.Context, b IndicatorBander, key []byte, values [][]byte, threshold float64) ([][2]int, error) {
+	buckets := make(map[string][]int)
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		bands, err := b.Bands(ctx, key, v, threshold)
+		if err != nil {
+			return nil, err
+		}
+		for _, band := range bands {
+			bucket := buckets[string(band)]
+			// a value can have the same band more than once:
+			if len(bucket) > 0 && bucket[len(bucket)-1] == i {
+				continue
+			}
+			buckets[string(band)] = append(bucket, i)
+		}
+	}
+	seen := make(map[[2]int]struct{})
+	var pairs [][2]int
+	for _, bucket := range buckets {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for x, i := range bucket {
+			for _, j := range bucket[x+1:] {
+				p := [2]int{i, j}
+				if _, ok := seen[p]; ok {
+					continue
+				}
+				seen[p] = struct{}{}
+				pairs = append(pairs, p)
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	return pairs, nil
+}
+
+// FindLSHNearDuplicates finds the near-duplicates of each of the
+// resources among the resources in the Repo by the MinHash signatures
+// of s's keys.  Instead of comparing every pair, each signature is only
+// compared to the candidates that MinHashCandidates finds.  Like
+// FindNearDuplicates, pairs at or above the threshold are returned,
+// most similar first.
+func FindLSHNearDuplicates(ctx context.Context, r LSHRepo, s IndicatorSimilarer, uris []URI, threshold float64) ([]NearDuplicate, error) {
+	var nds []NearDuplicate
+	seen := make(map[[2]URI]struct{})
+	values := make(map[URI]IndicationLookup)
+	lookup := func(u URI) (IndicationLookup, error) {
+		if lu, ok := values[u]; ok {
+			return lu, nil
+		}
+		ind, err := r.Indications(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		defer PutIndication(&ind)
+		lu, err := ind.Lookup()
+		if err != nil {
+			return nil, err
+		}
+		// The lookup's values alias the Indication's buffer which
+		// is about to be put back:
+		for k, v := range lu {
+			lu[k] = append([]byte(nil), v...)
+		}
+		values[u] = lu
+		return lu, nil
+	}
+	for _, key := range s.Keys() {
+		if !IsMinHashKey([]byte(key)) {
+			continue
+		}
+		min := threshold
+		if min == 0 {
+			min = s.Threshold([]byte(key))
+		}
+		for _, u := range uris {
+			lu, err := lookup(u)
+			if err != nil {
+				return nil, err
+			}
+			a, ok := lu[key]
+			if !ok {
+				continue
+			}
+			candidates, err := MinHashCandidates(ctx, r, u, key, a)
+			if err != nil {
+				return nil, err
+			}
+			for _, v := range candidates {
+				pair := [2]URI{u, v}
+				if v.String() < u.String() {
+					pair = [2]URI{v, u}
+				}
+				if _, ok := seen[pair]; ok {
+					continue
+				}
+				seen[pair] = struct{}{}
+				lv, err := lookup(v)
+				if err != nil {
+					return nil, err
+				}
+				b, ok := lv[key]
+				if !ok {
+					continue
+				}
+				sim, err := s.Similarity(ctx, []byte(key), a, b)
+				if err != nil {
+					return nil, err
+				}
+				if sim >= min {
+					nds = append(nds, NearDuplicate{
+						A:          pair[0],
+						B:          pair[1],
+						Key:        key,
+						Similarity: sim,
+					})
+				}
+			}
+		}
+	}
+	sort.SliceStable(nds, func(i, j int) bool {
+		return nds[i].Similarity > nds[j].Similarity
+	})
+	return nds, nil
+}
+
+// NearDuplicateCluster is a group of resources that are connected to
+// each other by near-duplicate pairs.
+type NearDuplicateCluster struct {
+	Key  Bytes
+	URIs []URI
+
+	// Similarity is the lowest similarity of the pairs that
+	// connect the cluster.
+	Similarity float64
+}
+
+// ClusterNearDuplicates groups near-duplicate pairs of the same key
+// into clusters where every resource is a near-duplicate of at least
+// one other resource in the cluster.  Clusters are sorted by their
+// similarity, highest first, and their URIs are sorted.
+func ClusterNearDuplicates(nds []NearDuplicate) []NearDuplicateCluster {
+	type node struct {
+		key Bytes
+		u   URI
+	}
+	parents := make(map[node]node)
+	var find func(n node) node
+	find = func(n node) node {
+		p, ok := parents[n]
+		if !ok || p == n {
+			return n
+		}
+		root := find(p)
+		parents[n] = root
+		return root
+	}
+	for _, nd := range nds {
+		a, b := find(node{nd.Key, nd.A}), find(node{nd.Key, nd.B})
+		parents[a] = a
+		if a != b {
+			parents[b] = a
+		}
+	}
+	clusters := make(map[node]*NearDuplicateCluster)
+	for _, nd := range nds {
+		root := find(node{nd.Key, nd.A})
+		c, ok := clusters[root]
+		if !ok {
+			c = &NearDuplicateCluster{Key: nd.Key, Similarity: nd.Similarity}
+			clusters[root] = c
+		}
+		if nd.Similarity < c.Similarity {
+			c.Similarity = nd.Similarity
+		}
+	}
+	for n := range parents {
+		c := clusters[find(n)]
+		c.URIs = append(c.URIs, n.u)
+	}
+	result := make([]NearDuplicateCluster, 0, len(clusters))
+	for _, c := range clusters {
+		sort.Slice(c.URIs, func(i, j int) bool {
+			return c.URIs[i].String() < c.URIs[j].String()
+		})
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Similarity != result[j].Similarity {
+			return result[i].Similarity > result[j].Similarity
+		}
+		if result[i].Key != result[j].Key {
+			return result[i].Key < result[j].Key
+		}
+		return result[i].URIs[0].String() < result[j].URIs[0].String()
+	})
+	return result
+}
@@ -0,0 +1,242 @@
+package uniquefile_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/skillian/uniquefile"
+)
+
+func TestFindNearDuplicates(t *testing.T) {
+	ctx := context.Background()
+	uri := func(p string) uniquefile.URI {
+		return uniquefile.URI{Scheme: uniquefile.FileScheme, Path: p}
+	}
+	images := map[uniquefile.URI][]byte{
+		uri("/original.png"): encodeImage(t, testImage(300, 200, false), false),
+		uri("/resized.jpg"):  encodeImage(t, testImage(150, 100, false), true),
+		uri("/inverted.png"): encodeImage(t, testImage(300, 200, true), false),
+	}
+	inds := make(map[uniquefile.URI]*uniquefile.Indication, len(images))
+	for u, data := range images {
+		ind := uniquefile.NewIndication()
+		if err := uniquefile.ImageHashIndicator.Indicate(ctx, bytes.NewReader(data), ind); err != nil {
+			t.Fatal(err)
+		}
+		inds[u] = ind
+	}
+	nds, err := uniquefile.FindNearDuplicates(ctx, uniquefile.ImageHashIndicator, inds, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nds) != 1 {
+		t.Fatalf("expected one near-duplicate, got %v", nds)
+	}
+	nd := nds[0]
+	if nd.A != uri("/original.png") || nd.B != uri("/resized.jpg") || nd.Key != "phash" {
+		t.Fatalf("unexpected near-duplicate: %#v", nd)
+	}
+	if nd.Similarity < 0.9 || nd.Similarity > 1 {
+		t.Fatalf("unexpected similarity: %v", nd.Similarity)
+	}
+	nds, err = uniquefile.FindNearDuplicates(ctx, uniquefile.ImageHashIndicator, inds, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nds) != 3 {
+		t.Fatalf("expected every pair with a low threshold, got %v", nds)
+	}
+}
+
+// countingSimilarer counts the values that it compares.  It hides the
+// Bands method of the IndicatorSimilarer it wraps.
+type countingSimilarer struct {
+	uniquefile.IndicatorSimilarer
+	n *int
+}
+
+func (s countingSimilarer) Similarity(ctx context.Context, key, a, b []byte) (float64, error) {
+	*s.n++
+	return s.IndicatorSimilarer.Similarity(ctx, key, a, b)
+}
+
+type countingBander struct {
+	countingSimilarer
+	uniquefile.IndicatorBander
+}
+
+// indicateAll indicates each of the data with ir.
+func indicateAll(t *testing.T, ir uniquefile.Indicator, data [][]byte) map[uniquefile.URI]*uniquefile.Indication {
+	inds := make(map[uniquefile.URI]*uniquefile.Indication, len(data))
+	for i, d := range data {
+		ind := uniquefile.NewIndication()
+		if err := ir.Indicate(context.Background(), bytes.NewReader(d), ind); err != nil {
+			t.Fatal(err)
+		}
+		inds[uniquefile.URI{Scheme: uniquefile.FileScheme, Path: fmt.Sprintf("/%03d", i)}] = ind
+	}
+	return inds
+}
+
+func TestFindNearDuplicatesBands(t *testing.T) {
+	ctx := context.Background()
+	rnd := rand.New(rand.NewSource(4))
+	text := func() []byte {
+		var sb strings.Builder
+		for i := 0; i < 2000; i++ {
+			fmt.Fprintf(&sb, "word%d ", rnd.Intn(500))
+		}
+		return []byte(sb.String())
+	}
+	// edit changes a few places in a copy of data.
+	edit := func(data []byte, n int) []byte {
+		data = append([]byte(nil), data...)
+		for i := 0; i < n; i++ {
+			data[rnd.Intn(len(data))] = 'X'
+		}
+		return data
+	}
+	var texts, blobs [][]byte
+	for i := 0; i < 40; i++ {
+		d := text()
+		texts = append(texts, d, edit(d, 3), edit(d, 40))
+		b := make([]byte, 64<<10)
+		rnd.Read(b)
+		blobs = append(blobs, b, edit(b, 2))
+	}
+	hashes := make(map[uniquefile.URI]*uniquefile.Indication)
+	for i := 0; i < 300; i++ {
+		h := rnd.Uint64()
+		for j, flips := range []int{0, 3, 12} {
+			v := h
+			for k := 0; k < flips; k++ {
+				v ^= 1 << uint(rnd.Intn(64))
+			}
+			ind := uniquefile.NewIndication()
+			var buf [8]byte
+			binary.BigEndian.PutUint64(buf[:], v)
+			ind.Write([]byte("phash"), buf[:])
+			hashes[uniquefile.URI{Scheme: uniquefile.FileScheme, Path: fmt.Sprintf("/%03d-%d", i, j)}] = ind
+		}
+	}
+	for _, tc := range []struct {
+		name      string
+		s         uniquefile.IndicatorSimilarer
+		inds      map[uniquefile.URI]*uniquefile.Indication
+		threshold float64
+
+		// fewer is set if the bands should rule out most pairs.
+		fewer bool
+	}{
+		{"phash", uniquefile.ImageHashIndicator, hashes, 0, true},
+		{"phashLow", uniquefile.ImageHashIndicator, hashes, 0.75, false},
+		{"ssdeep", uniquefile.SSDeepIndicator, indicateAll(t, uniquefile.SSDeepIndicator, texts), 0, true},
+		{"ssdeepLow", uniquefile.SSDeepIndicator, indicateAll(t, uniquefile.SSDeepIndicator, texts), 0.1, true},
+		{"chunks", uniquefile.NewChunksIndicator(4 << 10), indicateAll(t, uniquefile.NewChunksIndicator(4<<10), blobs), 0, true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var all, banded int
+			expect, err := uniquefile.FindNearDuplicates(ctx, countingSimilarer{tc.s, &all}, tc.inds, tc.threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := uniquefile.FindNearDuplicates(
+				ctx, countingBander{countingSimilarer{tc.s, &banded}, tc.s.(uniquefile.IndicatorBander)},
+				tc.inds, tc.threshold,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(expect) < len(tc.inds)/3 {
+				t.Fatalf("expected near-duplicates, got %v", expect)
+			}
+			if len(actual) != len(expect) {
+				t.Fatalf("expected %d near-duplicates, got %d", len(expect), len(actual))
+			}
+			for i := range expect {
+				if actual[i] != expect[i] {
+					t.Fatalf("expected %v, got %v", expect[i], actual[i])
+				}
+			}
+			if tc.fewer && banded*10 > all {
+				t.Fatalf("expected far fewer than %d comparisons, got %d", all, banded)
+			}
+		})
+	}
+}
+
+func TestChunksSimilarity(t *testing.T) {
+	ctx := context.Background()
+	ir := uniquefile.NewChunksIndicator(4 << 10)
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(2)).Read(data)
+	other := make([]byte, len(data))
+	copy(other, data)
+	rand.New(rand.NewSource(3)).Read(other[len(other)/4:])
+	value := func(data []byte) []byte {
+		ind := uniquefile.NewIndication()
+		if err := ir.Indicate(ctx, bytes.NewReader(data), ind); err != nil {
+			t.Fatal(err)
+		}
+		lookup, err := ind.Lookup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lookup["chunks4k"]
+	}
+	a, b := value(data), value(other)
+	same, err := ir.Similarity(ctx, []byte("chunks4k"), a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same != 1 {
+		t.Fatalf("expected identical chunks to be 1, got %v", same)
+	}
+	sim, err := ir.Similarity(ctx, []byte("chunks4k"), a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim < 0.2 || sim > 0.3 {
+		t.Fatalf("expected about a quarter in common, got %v", sim)
+	}
+	if _, err := ir.Similarity(ctx, []byte("chunks1m"), a, b); err != uniquefile.ErrCannotCmp {
+		t.Fatalf("expected ErrCannotCmp, got %v", err)
+	}
+}
+
+func TestClusterNearDuplicates(t *testing.T) {
+	uri := func(p string) uniquefile.URI {
+		return uniquefile.URI{Scheme: uniquefile.FileScheme, Path: p}
+	}
+	clusters := uniquefile.ClusterNearDuplicates([]uniquefile.NearDuplicate{
+		{A: uri("/a"), B: uri("/b"), Key: "minhash", Similarity: 0.9},
+		{A: uri("/x"), B: uri("/y"), Key: "minhash", Similarity: 0.95},
+		{A: uri("/c"), B: uri("/b"), Key: "minhash", Similarity: 0.8},
+		{A: uri("/a"), B: uri("/b"), Key: "phash", Similarity: 1},
+	})
+	expect := []uniquefile.NearDuplicateCluster{
+		{Key: "phash", URIs: []uniquefile.URI{uri("/a"), uri("/b")}, Similarity: 1},
+		{Key: "minhash", URIs: []uniquefile.URI{uri("/x"), uri("/y")}, Similarity: 0.95},
+		{Key: "minhash", URIs: []uniquefile.URI{uri("/a"), uri("/b"), uri("/c")}, Similarity: 0.8},
+	}
+	if len(clusters) != len(expect) {
+		t.Fatalf("expected %v, got %v", expect, clusters)
+	}
+	for i, c := range clusters {
+		e := expect[i]
+		if c.Key != e.Key || c.Similarity != e.Similarity || len(c.URIs) != len(e.URIs) {
+			t.Fatalf("expected %v, got %v", e, c)
+		}
+		for j, u := range c.URIs {
+			if u != e.URIs[j] {
+				t.Fatalf("expected %v, got %v", e, c)
+			}
+		}
+	}
+}
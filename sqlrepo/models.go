@@ -149,5 +149,173 @@ func (m Indication) AppendSQLTypes(ts []sqltypes.Type) []sqltypes.Type {
 
 func (m Indication) SQLTableName() string { return "Indication" }
 
+type ChunkID struct {
+	Value int64
+}
+
+func (id *ChunkID) AppendFields(fs []interface{}) []interface{} {
+	return append(fs, &id.Value)
+}
+
+func (id ChunkID) AppendValues(vs []interface{}) []interface{} {
+	return append(vs, id.Value)
+}
+
+func (id ChunkID) AppendSQLTypes(ts []sqltypes.Type) []sqltypes.Type {
+	return append(ts, sqltypes.IntType{Bits: 64})
+}
+
+type Chunk struct {
+	ChunkID ChunkID
+	ResourceID ResourceID
+	Key string
+	Hash []byte
+	Length int64
+}
+
+func (m *Chunk) ID() sqlstream.Model {
+	return sqlstream.ModelWithNames(&m.ChunkID, "ChunkID")
+}
+
+func (m *Chunk) AppendFields(fs []interface{}) []interface{} {
+	fs = m.ChunkID.AppendFields(fs)
+	fs = m.ResourceID.AppendFields(fs)
+	fs = append(fs, &m.Key)
+	fs = append(fs, &m.Hash)
+	fs = append(fs, &m.Length)
+	return fs
+}
+
+var namesOfChunkFields = []string{
+	"ChunkID",
+	"ResourceID",
+	"Key",
+	"Hash",
+	"Length",
+}
+
+func (m Chunk) AppendNames(ns []string) []string {
+	return append(ns, namesOfChunkFields...)
+}
+
+func (m Chunk) AppendValues(vs []interface{}) []interface{} {
+	vs = m.ChunkID.AppendValues(vs)
+	vs = m.ResourceID.AppendValues(vs)
+	vs = append(vs, m.Key)
+	vs = append(vs, m.Hash)
+	vs = append(vs, m.Length)
+	return vs
+}
+
+var sqlNamesOfChunkFields = []string{
+	"ChunkID",
+	"ResourceID",
+	"Key",
+	"Hash",
+	"Length",
+}
+
+func (m Chunk) AppendSQLNames(ns []string) []string {
+	return append(ns, sqlNamesOfChunkFields...)
+}
+
+var typesOfChunkFields = []sqltypes.Type{
+	sqltypes.IntType{Bits: 64},
+	sqltypes.IntType{Bits: 64},
+	sqltypes.StringType{Var: false, Length: 16},
+	sqltypes.BytesType{Var: false, Length: 16},
+	sqltypes.IntType{Bits: 64},
+}
+
+func (m Chunk) AppendSQLTypes(ts []sqltypes.Type) []sqltypes.Type {
+	return append(ts, typesOfChunkFields...)
+}
+
+func (m Chunk) SQLTableName() string { return "Chunk" }
+
 
 
+
+type BandID struct {
+	Value int64
+}
+
+func (id *BandID) AppendFields(fs []interface{}) []interface{} {
+	return append(fs, &id.Value)
+}
+
+func (id BandID) AppendValues(vs []interface{}) []interface{} {
+	return append(vs, id.Value)
+}
+
+func (id BandID) AppendSQLTypes(ts []sqltypes.Type) []sqltypes.Type {
+	return append(ts, sqltypes.IntType{Bits: 64})
+}
+
+type Band struct {
+	BandID BandID
+	ResourceID ResourceID
+	Key string
+	Index int64
+	Hash []byte
+}
+
+func (m *Band) ID() sqlstream.Model {
+	return sqlstream.ModelWithNames(&m.BandID, "BandID")
+}
+
+func (m *Band) AppendFields(fs []interface{}) []interface{} {
+	fs = m.BandID.AppendFields(fs)
+	fs = m.ResourceID.AppendFields(fs)
+	fs = append(fs, &m.Key)
+	fs = append(fs, &m.Index)
+	fs = append(fs, &m.Hash)
+	return fs
+}
+
+var namesOfBandFields = []string{
+	"BandID",
+	"ResourceID",
+	"Key",
+	"Index",
+	"Hash",
+}
+
+func (m Band) AppendNames(ns []string) []string {
+	return append(ns, namesOfBandFields...)
+}
+
+func (m Band) AppendValues(vs []interface{}) []interface{} {
+	vs = m.BandID.AppendValues(vs)
+	vs = m.ResourceID.AppendValues(vs)
+	vs = append(vs, m.Key)
+	vs = append(vs, m.Index)
+	vs = append(vs, m.Hash)
+	return vs
+}
+
+var sqlNamesOfBandFields = []string{
+	"BandID",
+	"ResourceID",
+	"Key",
+	"Index",
+	"Hash",
+}
+
+func (m Band) AppendSQLNames(ns []string) []string {
+	return append(ns, sqlNamesOfBandFields...)
+}
+
+var typesOfBandFields = []sqltypes.Type{
+	sqltypes.IntType{Bits: 64},
+	sqltypes.IntType{Bits: 64},
+	sqltypes.StringType{Var: false, Length: 16},
+	sqltypes.IntType{Bits: 64},
+	sqltypes.BytesType{Var: false, Length: 8},
+}
+
+func (m Band) AppendSQLTypes(ts []sqltypes.Type) []sqltypes.Type {
+	return append(ts, typesOfBandFields...)
+}
+
+func (m Band) SQLTableName() string { return "Band" }
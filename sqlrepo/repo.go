@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"encoding/binary"
 	"fmt"
 	"strings"
 
@@ -19,7 +20,10 @@ type Repo struct {
 	db *sqlstream.DB
 }
 
-var _ uniquefile.Repo = (*Repo)(nil)
+var (
+	_ uniquefile.ChunkRepo = (*Repo)(nil)
+	_ uniquefile.LSHRepo   = (*Repo)(nil)
+)
 
 func OpenRepo(ctx context.Context, driverName, dataSourceName string, options ...sqlstream.DBOption) (*Repo, error) {
 	sqlDB, err := sql.Open(driverName, dataSourceName)
@@ -96,10 +100,13 @@ func (r *Repo) Indications(ctx context.Context, u uniquefile.URI) (ui *uniquefil
 }
 
 func (r *Repo) SetIndications(ctx context.Context, u uniquefile.URI, ui *uniquefile.Indication) (Err error) {
-	lu, err := ui.Lookup()
-	if err != nil {
+	if err := ui.Validate(); err != nil {
 		return err
 	}
+	// creating starts with all of the indications and the ones that
+	// are already stored are removed from it.
+	creating := ui.Clone()
+	defer uniquefile.PutIndication(&creating)
 	ctx, _, catcher, err := r.db.WithTx(ctx)
 	if err != nil {
 		return errors.Errorf0From(
@@ -144,10 +151,10 @@ func (r *Repo) SetIndications(ctx context.Context, u uniquefile.URI, ui *uniquef
 		)
 		deletingIndication := make([]Indication, 0, 8)
 		if err := stream.Each(ctx, indQry, func(c context.Context, s stream.Stream) error {
-			k := uniquefile.Bytes(ind.Key)
-			if v, ok := lu[k]; ok && bytes.Equal(ind.Value, v) {
+			k := []byte(ind.Key)
+			if v, ok := creating.Get(k); ok && bytes.Equal(ind.Value, v) {
 				// don't re-insert the same indication:
-				delete(lu, k)
+				creating.Delete(k)
 				return nil
 			}
 			deletingIndication = append(deletingIndication, ind)
@@ -163,6 +170,16 @@ func (r *Repo) SetIndications(ctx context.Context, u uniquefile.URI, ui *uniquef
 		deleting := make([]interface{}, len(deletingIndication))
 		for i := range deletingIndication {
 			deleting[i] = &deletingIndication[i]
+			if uniquefile.IsChunksKey([]byte(deletingIndication[i].Key)) {
+				if err := r.deleteChunks(ctx, res.ResourceID, deletingIndication[i].Key); err != nil {
+					return err
+				}
+			}
+			if uniquefile.IsMinHashKey([]byte(deletingIndication[i].Key)) {
+				if err := r.deleteBands(ctx, res.ResourceID, deletingIndication[i].Key); err != nil {
+					return err
+				}
+			}
 		}
 		if err := r.db.Delete(ctx, deleting...); err != nil {
 			return errors.Errorf0From(
@@ -170,13 +187,32 @@ func (r *Repo) SetIndications(ctx context.Context, u uniquefile.URI, ui *uniquef
 			)
 		}
 	}
-	creatingIndications := make([]interface{}, 0, len(lu))
-	for k, v := range lu {
+	var creatingIndications []interface{}
+	if err := creating.Each(func(k, v []byte) error {
 		creatingIndications = append(creatingIndications, &Indication{
 			ResourceID: res.ResourceID,
 			Key:        string(k),
-			Value:      v,
+			Value:      append([]byte(nil), v...),
 		})
+		if uniquefile.IsChunksKey(k) {
+			if err := r.saveChunks(ctx, res.ResourceID, string(k), v); err != nil {
+				return errors.Errorf1From(
+					err, "failed to save chunks of %v",
+					u,
+				)
+			}
+		}
+		if uniquefile.IsMinHashKey(k) {
+			if err := r.saveBands(ctx, res.ResourceID, string(k), v); err != nil {
+				return errors.Errorf1From(
+					err, "failed to save MinHash bands of %v",
+					u,
+				)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
 	}
 	if err := r.db.Save(ctx, creatingIndications...); err != nil {
 		return errors.Errorf2From(
@@ -282,3 +318,234 @@ func (r *Repo) URIs(ctx context.Context, query expr.Expr) (uris []uniquefile.URI
 	}
 	return
 }
+
+// ChunkURIs implements uniquefile.ChunkRepo by looking up the chunks
+// that SetIndications stored separately from chunk list indications.
+func (r *Repo) ChunkURIs(ctx context.Context, key uniquefile.Bytes, chunk uniquefile.Chunk) (uris []uniquefile.URI, Err error) {
+	var ch Chunk
+	chQry := stream.LineOf2(r.db.Query(ctx, &ch))(
+		func(q stream.Line) stream.Line {
+			return q.Filter(expr.And{
+				expr.Eq{
+					expr.MemOf(q.Var(), &ch, &ch.Key),
+					string(key),
+				},
+				expr.Eq{
+					expr.MemOf(q.Var(), &ch, &ch.Hash),
+					chunk.Hash[:],
+				},
+			})
+		},
+	)
+	var res Resource
+	resQry := stream.LineOf2(r.db.Query(ctx, &res))(
+		func(q stream.Line) stream.Line {
+			return chQry.Join(q, expr.Eq{
+				expr.MemOf(chQry.Var(), &ch, &ch.ResourceID),
+				expr.MemOf(q.Var(), &res, &res.ResourceID),
+			}, q.Var())
+		},
+	)
+	ctx, vs := expr.ValuesFromContextOrNew(ctx)
+	_ = vs.Set(resQry.Var(), &res)
+	seen := make(map[string]struct{})
+	if err := stream.Each(ctx, resQry, func(c context.Context, s stream.Stream) error {
+		if _, ok := seen[res.Uri]; ok {
+			return nil
+		}
+		seen[res.Uri] = struct{}{}
+		u := uniquefile.URI{}
+		if err := u.FromString(res.Uri); err != nil {
+			return err
+		}
+		uris = append(uris, u)
+		return nil
+	}); err != nil {
+		return nil, errors.Errorf1From(
+			err, "failed to find resources with chunk %x",
+			chunk.Hash,
+		)
+	}
+	return
+}
+
+// saveChunks stores each chunk in a chunk list indication as its own
+// Chunk so that ChunkURIs can find them.
+func (r *Repo) saveChunks(ctx context.Context, id ResourceID, key string, value []byte) error {
+	chunks, err := uniquefile.ParseChunks(value)
+	if err != nil {
+		return err
+	}
+	creating := make([]interface{}, len(chunks))
+	for i, c := range chunks {
+		creating[i] = &Chunk{
+			ResourceID: id,
+			Key:        key,
+			Hash:       append([]byte(nil), c.Hash[:]...),
+			Length:     int64(c.Length),
+		}
+	}
+	return r.db.Save(ctx, creating...)
+}
+
+// deleteChunks deletes the Chunks that saveChunks stored for a
+// resource's chunk list indication.
+func (r *Repo) deleteChunks(ctx context.Context, id ResourceID, key string) error {
+	var ch Chunk
+	chQry := stream.LineOf2(r.db.Query(ctx, &ch))(
+		func(q stream.Line) stream.Line {
+			return q.Filter(expr.And{
+				expr.Eq{
+					expr.MemOf(q.Var(), &ch, &ch.ResourceID),
+					id.Value,
+				},
+				expr.Eq{
+					expr.MemOf(q.Var(), &ch, &ch.Key),
+					key,
+				},
+			})
+		},
+	)
+	ctx, vs := expr.ValuesFromContextOrNew(ctx)
+	_ = vs.Set(chQry.Var(), &ch)
+	deletingChunks := make([]Chunk, 0, 64)
+	if err := stream.Each(ctx, chQry, func(c context.Context, s stream.Stream) error {
+		deletingChunks = append(deletingChunks, ch)
+		return nil
+	}); err != nil {
+		return errors.Errorf2From(
+			err, "failed to determine existing %v chunks "+
+				"for resource %v",
+			key, id.Value,
+		)
+	}
+	deleting := make([]interface{}, len(deletingChunks))
+	for i := range deletingChunks {
+		deleting[i] = &deletingChunks[i]
+	}
+	if err := r.db.Delete(ctx, deleting...); err != nil {
+		return errors.Errorf0From(
+			err, "failed to delete existing chunks",
+		)
+	}
+	return nil
+}
+
+// BandURIs implements uniquefile.LSHRepo by looking up the bands that
+// SetIndications stored separately from MinHash indications.
+func (r *Repo) BandURIs(ctx context.Context, key uniquefile.Bytes, band uniquefile.MinHashBand) (uris []uniquefile.URI, Err error) {
+	var b Band
+	bQry := stream.LineOf2(r.db.Query(ctx, &b))(
+		func(q stream.Line) stream.Line {
+			return q.Filter(expr.And{
+				expr.And{
+					expr.Eq{
+						expr.MemOf(q.Var(), &b, &b.Key),
+						string(key),
+					},
+					expr.Eq{
+						expr.MemOf(q.Var(), &b, &b.Index),
+						int64(band.Index),
+					},
+				},
+				expr.Eq{
+					expr.MemOf(q.Var(), &b, &b.Hash),
+					bandHash(band),
+				},
+			})
+		},
+	)
+	var res Resource
+	resQry := stream.LineOf2(r.db.Query(ctx, &res))(
+		func(q stream.Line) stream.Line {
+			return bQry.Join(q, expr.Eq{
+				expr.MemOf(bQry.Var(), &b, &b.ResourceID),
+				expr.MemOf(q.Var(), &res, &res.ResourceID),
+			}, q.Var())
+		},
+	)
+	ctx, vs := expr.ValuesFromContextOrNew(ctx)
+	_ = vs.Set(resQry.Var(), &res)
+	if err := stream.Each(ctx, resQry, func(c context.Context, s stream.Stream) error {
+		u := uniquefile.URI{}
+		if err := u.FromString(res.Uri); err != nil {
+			return err
+		}
+		uris = append(uris, u)
+		return nil
+	}); err != nil {
+		return nil, errors.Errorf2From(
+			err, "failed to find resources with band %d: %x",
+			band.Index, band.Hash,
+		)
+	}
+	return
+}
+
+func bandHash(band uniquefile.MinHashBand) []byte {
+	var bs [8]byte
+	binary.BigEndian.PutUint64(bs[:], band.Hash)
+	return bs[:]
+}
+
+// saveBands stores each band of a MinHash indication as its own Band so
+// that BandURIs can find them.
+func (r *Repo) saveBands(ctx context.Context, id ResourceID, key string, value []byte) error {
+	bands, err := uniquefile.MinHashBandsOf(value)
+	if err != nil {
+		return err
+	}
+	creating := make([]interface{}, len(bands))
+	for i, band := range bands {
+		creating[i] = &Band{
+			ResourceID: id,
+			Key:        key,
+			Index:      int64(band.Index),
+			Hash:       bandHash(band),
+		}
+	}
+	return r.db.Save(ctx, creating...)
+}
+
+// deleteBands deletes the Bands that saveBands stored for a resource's
+// MinHash indication.
+func (r *Repo) deleteBands(ctx context.Context, id ResourceID, key string) error {
+	var b Band
+	bQry := stream.LineOf2(r.db.Query(ctx, &b))(
+		func(q stream.Line) stream.Line {
+			return q.Filter(expr.And{
+				expr.Eq{
+					expr.MemOf(q.Var(), &b, &b.ResourceID),
+					id.Value,
+				},
+				expr.Eq{
+					expr.MemOf(q.Var(), &b, &b.Key),
+					key,
+				},
+			})
+		},
+	)
+	ctx, vs := expr.ValuesFromContextOrNew(ctx)
+	_ = vs.Set(bQry.Var(), &b)
+	deletingBands := make([]Band, 0, uniquefile.MinHashBands)
+	if err := stream.Each(ctx, bQry, func(c context.Context, s stream.Stream) error {
+		deletingBands = append(deletingBands, b)
+		return nil
+	}); err != nil {
+		return errors.Errorf2From(
+			err, "failed to determine existing %v bands "+
+				"for resource %v",
+			key, id.Value,
+		)
+	}
+	deleting := make([]interface{}, len(deletingBands))
+	for i := range deletingBands {
+		deleting[i] = &deletingBands[i]
+	}
+	if err := r.db.Delete(ctx, deleting...); err != nil {
+		return errors.Errorf0From(
+			err, "failed to delete existing bands",
+		)
+	}
+	return nil
+}
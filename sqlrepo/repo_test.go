@@ -0,0 +1,218 @@
+package sqlrepo_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/skillian/expr/stream/sqlstream"
+	"github.com/skillian/uniquefile"
+	"github.com/skillian/uniquefile/sqlrepo"
+)
+
+// newTestRepo creates a Repo in a new SQLite database with the
+// uniquefile schema.
+func newTestRepo(t *testing.T) *sqlrepo.Repo {
+	ctx := context.Background()
+	di, err := sqlstream.ParseDialect("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := sqlrepo.OpenRepo(
+		ctx, "sqlite3", filepath.Join(t.TempDir(), "uniquefile.db"),
+		sqlstream.WithDialect(di),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []interface{}{
+		&sqlrepo.Resource{},
+		&sqlrepo.Indication{},
+		&sqlrepo.Chunk{},
+		&sqlrepo.Band{},
+	} {
+		if err := r.DB().CreateCollection(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func testURI(p string) uniquefile.URI {
+	return uniquefile.URI{Scheme: uniquefile.FileScheme, Path: p}
+}
+
+// setIndications indicates data with ir and stores the indications
+// under u.
+func setIndications(t *testing.T, r uniquefile.Repo, u uniquefile.URI, ir uniquefile.Indicator, data []byte) {
+	ctx := context.Background()
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	if err := ir.Indicate(ctx, bytes.NewReader(data), ind); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetIndications(ctx, u, ind); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sortedPaths(uris []uniquefile.URI) []string {
+	paths := make([]string, len(uris))
+	for i, u := range uris {
+		paths[i] = u.Path
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestRepoChunks(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	ir, ok := uniquefile.ParseIndicator("chunks:avg=1k")
+	if !ok {
+		t.Fatal("failed to parse chunks indicator")
+	}
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 64<<10)
+	rng.Read(data)
+	other := make([]byte, 64<<10)
+	rng.Read(other)
+	edited := append(append([]byte(nil), data...), "appended"...)
+	setIndications(t, r, testURI("/a"), ir, data)
+	setIndications(t, r, testURI("/b"), ir, edited)
+	setIndications(t, r, testURI("/c"), ir, other)
+	ind, err := r.Indications(ctx, testURI("/a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, ok := ind.Get([]byte("chunks1k"))
+	if !ok {
+		t.Fatal("expected chunks1k indication")
+	}
+	chunks, err := uniquefile.ParseChunks(value)
+	uniquefile.PutIndication(&ind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uris, err := r.ChunkURIs(ctx, "chunks1k", chunks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths := sortedPaths(uris); len(paths) != 2 || paths[0] != "/a" || paths[1] != "/b" {
+		t.Fatalf("expected /a and /b to have the first chunk, got %v", paths)
+	}
+	shared, err := uniquefile.SharedChunks(ctx, r, testURI("/a"), "chunks1k", chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 1 || shared[testURI("/b")] < int64(len(data))*9/10 {
+		t.Fatalf("expected most of /a to be shared with /b, got %v", shared)
+	}
+	// re-indicating /b must delete its old chunks:
+	setIndications(t, r, testURI("/b"), ir, other)
+	uris, err = r.ChunkURIs(ctx, "chunks1k", chunks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths := sortedPaths(uris); len(paths) != 1 || paths[0] != "/a" {
+		t.Fatalf("expected only /a to have the first chunk, got %v", paths)
+	}
+	if shared, err = uniquefile.SharedChunks(ctx, r, testURI("/a"), "chunks1k", chunks); err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 0 {
+		t.Fatalf("expected no chunks shared with /a, got %v", shared)
+	}
+}
+
+// testText generates n words of text from a small vocabulary and
+// replaces the words at the edits' indexes.
+func testText(seed int64, n int, edits ...int) []byte {
+	vocab := strings.Fields(
+		"the quick brown fox jumps over lazy dog and then " +
+			"runs into forest where it finds a river with " +
+			"many fish swimming under bridge near old mill",
+	)
+	rng := rand.New(rand.NewSource(seed))
+	words := make([]string, n)
+	for i := range words {
+		words[i] = vocab[rng.Intn(len(vocab))]
+	}
+	for _, i := range edits {
+		words[i] = "edited"
+	}
+	return []byte(strings.Join(words, " "))
+}
+
+// sharedBand finds a band that all of the texts' MinHash signatures
+// have in common.
+func sharedBand(t *testing.T, texts ...[]byte) uniquefile.MinHashBand {
+	counts := make(map[uniquefile.MinHashBand]int)
+	for _, text := range texts {
+		ind := uniquefile.NewIndication()
+		if err := uniquefile.MinHashIndicator.Indicate(context.Background(), bytes.NewReader(text), ind); err != nil {
+			t.Fatal(err)
+		}
+		sig, _ := ind.Get([]byte("minhash"))
+		bands, err := uniquefile.MinHashBandsOf(sig)
+		uniquefile.PutIndication(&ind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range bands {
+			if counts[b]++; counts[b] == len(texts) {
+				return b
+			}
+		}
+	}
+	t.Fatal("the texts have no band in common")
+	return uniquefile.MinHashBand{}
+}
+
+func TestRepoBands(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	ir := uniquefile.MinHashIndicator
+	a, b := testText(1, 500), testText(1, 500, 200)
+	setIndications(t, r, testURI("/a"), ir, a)
+	setIndications(t, r, testURI("/b"), ir, b)
+	setIndications(t, r, testURI("/c"), ir, testText(2, 500))
+	uris := []uniquefile.URI{testURI("/a"), testURI("/b"), testURI("/c")}
+	check := func(band uniquefile.MinHashBand, expect ...string) {
+		t.Helper()
+		found, err := r.BandURIs(ctx, "minhash", band)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if paths := sortedPaths(found); strings.Join(paths, " ") != strings.Join(expect, " ") {
+			t.Fatalf("expected %v to have the band, got %v", expect, paths)
+		}
+		nds, err := uniquefile.FindLSHNearDuplicates(ctx, r, ir, uris, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(expect) == 1 {
+			if len(nds) != 0 {
+				t.Fatalf("expected no near-duplicates, got %v", nds)
+			}
+			return
+		}
+		if len(nds) != 1 || nds[0].A != testURI("/a") || nds[0].B != testURI("/b") {
+			t.Fatalf("expected /a and /b to be near-duplicates, got %v", nds)
+		}
+	}
+	// re-indicating /b with another edit must replace its bands
+	// instead of adding to them:
+	edited := testText(1, 500, 300)
+	band := sharedBand(t, a, b, edited)
+	check(band, "/a", "/b")
+	setIndications(t, r, testURI("/b"), ir, edited)
+	check(band, "/a", "/b")
+	setIndications(t, r, testURI("/b"), ir, testText(3, 500))
+	check(band, "/a")
+}
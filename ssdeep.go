@@ -0,0 +1,384 @@
+package uniquefile
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/skillian/errors"
+)
+
+const (
+	ssdeepIndicatorKey = "ssdeep"
+
+	// ssdeepWindow is the size of the rolling hash's window and the
+	// length of the substring that two signatures must have in
+	// common to be compared.
+	ssdeepWindow = 7
+
+	ssdeepMinBlockSize = 3
+	ssdeepHashPrime    = 0x01000193
+	ssdeepHashInit     = 0x28021967
+	ssdeepBlockHashes  = 31
+	ssdeepLength       = 64
+
+	ssdeepB64 = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+)
+
+// SSDeepIndicator computes a context triggered piecewise hash of its
+// data and writes it under the "ssdeep" key as a signature in the
+// same format as the ssdeep program (e.g. "3:AXGBicFlgVNhBGcL6wCrFQEv:
+// AXGHsNhxLsr2C").  Unlike the other hashes, data that was only
+// partly changed, such as a patched binary or an edited document, has
+// a signature that is similar to the original's.
+//
+// The Indicator's Cmp method returns ssdeep's match score from 0 (no
+// match) to 100 (a very strong match) and its Similarity method scales
+// the score to between 0 and 1.  See CompareSSDeep.
+var SSDeepIndicator interface {
+	Indicator
+	IndicatorCmper
+	IndicatorSimilarer
+	IndicatorBander
+} = ssdeepIndicator{}
+
+type ssdeepIndicator struct{}
+
+func (ssdeepIndicator) Keys() []Bytes { return []Bytes{ssdeepIndicatorKey} }
+
+// Cmp returns the ssdeep match score of two signatures.
+func (ssdeepIndicator) Cmp(ctx context.Context, key, a, b []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if string(key) != ssdeepIndicatorKey {
+		return 0, ErrCannotCmp
+	}
+	return CompareSSDeep(string(a), string(b))
+}
+
+// Similarity returns the ssdeep match score scaled to between 0 and 1.
+func (ir ssdeepIndicator) Similarity(ctx context.Context, key, a, b []byte) (float64, error) {
+	score, err := ir.Cmp(ctx, key, a, b)
+	if err != nil {
+		return 0, err
+	}
+	return float64(score) / 100, nil
+}
+
+// Threshold considers signatures with a match score of at least 50 to
+// be near-duplicates.
+func (ssdeepIndicator) Threshold(key []byte) float64 { return 0.5 }
+
+// Bands returns each substring of the signature's digests that is as
+// long as the rolling hash's window together with the digest's block
+// size because signatures only get a score above 0 if their digests of
+// the same block size have such a substring in common.  Identical
+// signatures with digests that are too short for that score 100, so
+// the whole signature is a band, too.
+func (ssdeepIndicator) Bands(ctx context.Context, key, value []byte, threshold float64) ([][]byte, error) {
+	if string(key) != ssdeepIndicatorKey {
+		return nil, ErrCannotCmp
+	}
+	blockSize, d1, d2, err := parseSSDeep(string(value))
+	if err != nil {
+		return nil, err
+	}
+	if threshold <= 0 {
+		return [][]byte{nil}, nil
+	}
+	bs := strconv.FormatUint(blockSize, 10)
+	bands := [][]byte{[]byte(bs + ":" + d1 + ":" + d2)}
+	for _, d := range []struct {
+		blockSize uint64
+		digest    string
+	}{{blockSize, d1}, {blockSize * 2, d2}} {
+		for i := 0; i+ssdeepWindow <= len(d.digest); i++ {
+			bands = append(bands, []byte(
+				strconv.FormatUint(d.blockSize, 10)+"/"+
+					d.digest[i:i+ssdeepWindow],
+			))
+		}
+	}
+	return bands, nil
+}
+
+func (ssdeepIndicator) Indicate(ctx context.Context, r io.Reader, ind *Indication) error {
+	var st ssdeepState
+	st.init()
+	br := bufio.NewReaderSize(readerContext{ctx, r}, copyBufferSize)
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		st.step(c)
+	}
+	ind.Write([]byte(ssdeepIndicatorKey), []byte(st.digest()))
+	return nil
+}
+
+// ssdeepBlockSize is the block size of the i'th block hash.
+func ssdeepBlockSize(i int) uint64 { return ssdeepMinBlockSize << uint(i) }
+
+// ssdeepSumHash adds c into the FNV-like hash of a piece.
+func ssdeepSumHash(c byte, h uint32) uint32 { return h*ssdeepHashPrime ^ uint32(c) }
+
+// ssdeepBlockHash is the signature of the data at one block size.
+type ssdeepBlockHash struct {
+	digest     [ssdeepLength]byte
+	dindex     int
+	halfdigest byte
+	h, halfh   uint32
+}
+
+// ssdeepState computes the signatures at every block size at the same
+// time so that the data only has to be read once.  This is the same as
+// how ssdeep itself computes signatures since version 2.13, except that
+// ssdeep only computes the block sizes that could still be chosen for
+// the result.  The results are the same.
+type ssdeepState struct {
+	total uint64
+
+	// rolling hash:
+	window     [ssdeepWindow]byte
+	h1, h2, h3 uint32
+	n          uint32
+
+	bh [ssdeepBlockHashes]ssdeepBlockHash
+
+	// lasth is the hash of all of the data which is needed if the
+	// largest block size is chosen.
+	lasth uint32
+}
+
+func (st *ssdeepState) init() {
+	for i := range st.bh {
+		st.bh[i].h = ssdeepHashInit
+		st.bh[i].halfh = ssdeepHashInit
+	}
+	st.lasth = ssdeepHashInit
+}
+
+func (st *ssdeepState) rollSum() uint32 { return st.h1 + st.h2 + st.h3 }
+
+func (st *ssdeepState) step(c byte) {
+	st.total++
+	st.h2 -= st.h1
+	st.h2 += ssdeepWindow * uint32(c)
+	st.h1 += uint32(c)
+	st.h1 -= uint32(st.window[st.n%ssdeepWindow])
+	st.window[st.n%ssdeepWindow] = c
+	st.n++
+	st.h3 <<= 5
+	st.h3 ^= uint32(c)
+	h := uint64(st.rollSum())
+	for i := range st.bh {
+		st.bh[i].h = ssdeepSumHash(c, st.bh[i].h)
+		st.bh[i].halfh = ssdeepSumHash(c, st.bh[i].halfh)
+	}
+	st.lasth = ssdeepSumHash(c, st.lasth)
+	for i := range st.bh {
+		bs := ssdeepBlockSize(i)
+		if h%bs != bs-1 {
+			// if h isn't -1 mod bs, it can't be -1 mod
+			// 2 * bs either.
+			break
+		}
+		bh := &st.bh[i]
+		bh.digest[bh.dindex] = ssdeepB64[bh.h%64]
+		bh.halfdigest = ssdeepB64[bh.halfh%64]
+		if bh.dindex < ssdeepLength-1 {
+			bh.dindex++
+			bh.digest[bh.dindex] = 0
+			bh.h = ssdeepHashInit
+			if bh.dindex < ssdeepLength/2 {
+				bh.halfh = ssdeepHashInit
+				bh.halfdigest = 0
+			}
+		}
+	}
+}
+
+func (st *ssdeepState) digest() string {
+	// ssdeep only starts a block size after the previous one is
+	// triggered the first time.
+	end := 1
+	for end < ssdeepBlockHashes && st.bh[end-1].dindex > 0 {
+		end++
+	}
+	bi := 0
+	for ssdeepBlockSize(bi)*ssdeepLength < st.total {
+		bi++
+	}
+	if bi >= end {
+		bi = end - 1
+	}
+	for bi > 0 && st.bh[bi].dindex < ssdeepLength/2 {
+		bi--
+	}
+	h := st.rollSum()
+	var sb strings.Builder
+	sb.WriteString(strconv.FormatUint(ssdeepBlockSize(bi), 10))
+	sb.WriteByte(':')
+	bh := &st.bh[bi]
+	sb.Write(bh.digest[:bh.dindex])
+	if h != 0 {
+		sb.WriteByte(ssdeepB64[bh.h%64])
+	} else if bh.digest[bh.dindex] != 0 {
+		sb.WriteByte(bh.digest[bh.dindex])
+	}
+	sb.WriteByte(':')
+	if bi < end-1 {
+		bh = &st.bh[bi+1]
+		n := bh.dindex
+		if n > ssdeepLength/2-1 {
+			n = ssdeepLength/2 - 1
+		}
+		sb.Write(bh.digest[:n])
+		if h != 0 {
+			sb.WriteByte(ssdeepB64[bh.halfh%64])
+		} else if bh.halfdigest != 0 {
+			sb.WriteByte(bh.halfdigest)
+		}
+	} else if h != 0 {
+		if bi == 0 {
+			sb.WriteByte(ssdeepB64[bh.h%64])
+		} else {
+			sb.WriteByte(ssdeepB64[st.lasth%64])
+		}
+	}
+	return sb.String()
+}
+
+// CompareSSDeep compares two ssdeep signatures and returns their match
+// score from 0 to 100 the same way that ssdeep does.  Only signatures
+// whose block sizes are the same or differ by a factor of two can be
+// compared; others score 0.  Anything after a comma in a signature
+// (such as the file name in ssdeep's output) is ignored.
+func CompareSSDeep(a, b string) (int, error) {
+	bsA, a1, a2, err := parseSSDeep(a)
+	if err != nil {
+		return 0, err
+	}
+	bsB, b1, b2, err := parseSSDeep(b)
+	if err != nil {
+		return 0, err
+	}
+	if bsA != bsB && bsA != bsB*2 && bsB != bsA*2 {
+		return 0, nil
+	}
+	if bsA == bsB && a1 == b1 && a2 == b2 {
+		return 100, nil
+	}
+	switch {
+	case bsA == bsB:
+		score1 := ssdeepScore(a1, b1, bsA)
+		score2 := ssdeepScore(a2, b2, bsA*2)
+		if score1 > score2 {
+			return score1, nil
+		}
+		return score2, nil
+	case bsA*2 == bsB:
+		return ssdeepScore(b1, a2, bsB), nil
+	default:
+		return ssdeepScore(a1, b2, bsA), nil
+	}
+}
+
+// parseSSDeep parses a signature into its block size and its two
+// digests with runs of more than three of the same character
+// shortened to three.
+func parseSSDeep(sig string) (blockSize uint64, d1, d2 string, err error) {
+	parts := strings.SplitN(sig, ":", 3)
+	if len(parts) != 3 {
+		return 0, "", "", errors.Errorf("invalid ssdeep signature: %q", sig)
+	}
+	if blockSize, err = strconv.ParseUint(parts[0], 10, 64); err != nil || blockSize == 0 {
+		return 0, "", "", errors.Errorf("invalid ssdeep signature block size: %q", sig)
+	}
+	d2 = parts[2]
+	if i := strings.IndexByte(d2, ','); i != -1 {
+		d2 = d2[:i]
+	}
+	if len(parts[1]) > ssdeepLength || len(d2) > ssdeepLength {
+		return 0, "", "", errors.Errorf("ssdeep signature is too long: %q", sig)
+	}
+	return blockSize, eliminateSequences(parts[1]), eliminateSequences(d2), nil
+}
+
+func eliminateSequences(s string) string {
+	bs := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if i >= 3 && s[i] == s[i-1] && s[i] == s[i-2] && s[i] == s[i-3] {
+			continue
+		}
+		bs = append(bs, s[i])
+	}
+	return string(bs)
+}
+
+// ssdeepScore scores two digests of the same block size.
+func ssdeepScore(a, b string, blockSize uint64) int {
+	if !hasCommonSubstring(a, b, ssdeepWindow) {
+		return 0
+	}
+	score := uint64(editDistance(a, b))
+	score = score * ssdeepLength / uint64(len(a)+len(b))
+	score = 100 * score / ssdeepLength
+	score = 100 - score
+	// don't exaggerate matches of small block sizes:
+	if blockSize >= (99+ssdeepWindow)/ssdeepWindow*ssdeepMinBlockSize {
+		return int(score)
+	}
+	min := len(a)
+	if len(b) < min {
+		min = len(b)
+	}
+	if limit := blockSize / ssdeepMinBlockSize * uint64(min); score > limit {
+		score = limit
+	}
+	return int(score)
+}
+
+func hasCommonSubstring(a, b string, n int) bool {
+	for i := 0; i+n <= len(a); i++ {
+		if strings.Contains(b, a[i:i+n]) {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance is the number of insertions and deletions to change a
+// into b.  A replacement costs the same as a deletion and an insertion.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := prev[j-1]
+			if a[i-1] != b[j-1] {
+				cost += 2
+			}
+			if d := prev[j] + 1; d < cost {
+				cost = d
+			}
+			if d := cur[j-1] + 1; d < cost {
+				cost = d
+			}
+			cur[j] = cost
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,135 @@
+package uniquefile_test
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/skillian/uniquefile"
+)
+
+func ssdeepOf(t *testing.T, r io.Reader) string {
+	ind := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&ind)
+	if err := uniquefile.SSDeepIndicator.Indicate(context.Background(), r, ind); err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := ind.Lookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(lookup["ssdeep"])
+}
+
+func TestSSDeepIndicator(t *testing.T) {
+	rnd := rand.New(rand.NewSource(17))
+	original := make([]byte, 64<<10)
+	rnd.Read(original)
+	edited := append([]byte(nil), original...)
+	copy(edited[30000:], "a small edit in the middle of the data")
+	unrelated := make([]byte, len(original))
+	rnd.Read(unrelated)
+	expect := ssdeepOf(t, strings.NewReader(string(original)))
+	if !strings.HasPrefix(expect, "1536:") {
+		t.Fatalf("expected 64KiB of data to use block size 1536: %q", expect)
+	}
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		minScore int
+		maxScore int
+	}{
+		{"same", original, 100, 100},
+		{"edited", edited, 80, 99},
+		{"truncated", original[:len(original)-4096], 60, 99},
+		{"unrelated", unrelated, 0, 0},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			sig := ssdeepOf(t, strings.NewReader(string(tc.data)))
+			oneByte := ssdeepOf(t, iotest.OneByteReader(strings.NewReader(string(tc.data))))
+			if sig != oneByte {
+				t.Fatalf("signature depends on reads: %q != %q", sig, oneByte)
+			}
+			score, err := uniquefile.SSDeepIndicator.Cmp(
+				context.Background(), []byte("ssdeep"),
+				[]byte(expect), []byte(sig),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if score < tc.minScore || score > tc.maxScore {
+				t.Fatalf(
+					"expected score of %q and %q between %d and %d, not %d",
+					expect, sig, tc.minScore, tc.maxScore, score,
+				)
+			}
+		})
+	}
+}
+
+// The python-ssdeep README (https://github.com/DinoTools/python-ssdeep)
+// hashes ssdeepText1 and ssdeepText2 with libfuzzy and compares the
+// hashes with a score of 22.  The strings are copied verbatim.
+const (
+	ssdeepText1 = "Also called fuzzy hashes, Ctph can match inputs that have homologies."
+	ssdeepHash1 = "3:AXGBicFlgVNhBGcL6wCrFQEv:AXGHsNhxLsr2C"
+	ssdeepText2 = "Also called fuzzy hashes, CTPH can match inputs that have homologies."
+	ssdeepHash2 = "3:AXGBicFlIHBGcL6wCrFQEv:AXGH6xLsr2Cx"
+)
+
+// TestSSDeepIndicatorKnownAnswers checks digests that libfuzzy
+// produced.  The README's hash of ssdeepText2 isn't one of them
+// because it ends in an "x" that hash1 doesn't, although the texts
+// only differ near their beginnings and so must end with the same
+// pieces.  The two hashes can't have come from the same version of
+// libfuzzy, so only hash1 is used as a known answer here and hash2 is
+// only used to check the published score in TestCompareSSDeep.
+func TestSSDeepIndicatorKnownAnswers(t *testing.T) {
+	for _, tc := range []struct {
+		text, expect string
+	}{
+		{"", "3::"},
+		{ssdeepText1, ssdeepHash1},
+	} {
+		if sig := ssdeepOf(t, strings.NewReader(tc.text)); sig != tc.expect {
+			t.Fatalf("expected %q to hash to %q, not %q", tc.text, tc.expect, sig)
+		}
+	}
+}
+
+func TestCompareSSDeep(t *testing.T) {
+	const (
+		hash1 = ssdeepHash1
+		hash2 = ssdeepHash2
+	)
+	for _, tc := range []struct {
+		name  string
+		a, b  string
+		score int
+		err   bool
+	}{
+		{"published", hash1, hash2, 22, false},
+		{"reversed", hash2, hash1, 22, false},
+		{"identical", hash1, hash1, 100, false},
+		{"fileName", hash1 + `,"file.txt"`, hash2 + `,"other.txt"`, 22, false},
+		{"blockSizes", hash1, "12:AXGBicFlgVNhBGcL6wCrFQEv:AXGHsNhxLsr2C", 0, false},
+		{"noCommon", hash1, "3:abcdefghijkl:mnopqrst", 0, false},
+		{"missingPart", "3:AXGBicFlgVNhBGcL6wCrFQEv", hash2, 0, true},
+		{"blockSize", "x:AXGBicFlgVNhBGcL6wCrFQEv:AXGHsNhxLsr2C", hash2, 0, true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			score, err := uniquefile.CompareSSDeep(tc.a, tc.b)
+			if (err != nil) != tc.err {
+				t.Fatalf("expected error: %v, actual: %v", tc.err, err)
+			}
+			if score != tc.score {
+				t.Fatalf("expected score %d, actual: %d", tc.score, score)
+			}
+		})
+	}
+}
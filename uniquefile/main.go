@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"text/tabwriter"
 
 	_ "github.com/alexbrainman/odbc"
 	_ "github.com/denisenkom/go-mssqldb"
@@ -54,6 +56,84 @@ type Config struct {
 		DataSourceName string `json:"dataSourceName"`
 		Dialect        string `json:"dialect"`
 	} `json:"db"`
+
+	// Routes choose the indicators for each file by its type when
+	// --route is used.  If there are none, defaultRoutes are used.
+	Routes []RouteConfig `json:"routes"`
+
+	// RouteLimit limits the size of the files that are buffered so
+	// that every indicator of a route can read them.  If it is 0,
+	// uniquefile.DefaultRouteLimit is used.
+	RouteLimit int64 `json:"routeLimit"`
+}
+
+// RouteConfig configures a uniquefile.Route.  Its indicators are
+// parsed like the --indicator option.
+type RouteConfig struct {
+	MIMETypes  []string `json:"mimeTypes"`
+	Extensions []string `json:"extensions"`
+	Indicators []string `json:"indicators"`
+}
+
+// defaultRoutes are used by --route when the configuration file has no
+// routes.
+var defaultRoutes = []RouteConfig{
+	{
+		MIMETypes:  []string{"image/"},
+		Indicators: []string{"sha256", "imagehash", "payload", "exif"},
+	},
+	{
+		MIMETypes:  []string{"audio/wave", "audio/flac"},
+		Indicators: []string{"sha256", "audio"},
+	},
+	{
+		MIMETypes:  []string{"audio/mpeg"},
+		Indicators: []string{"sha256", "payload"},
+	},
+	{
+		MIMETypes: []string{"text/"},
+		Extensions: []string{
+			".c", ".cpp", ".cs", ".css", ".go", ".h", ".html",
+			".ini", ".java", ".js", ".json", ".md", ".py",
+			".rb", ".rs", ".sh", ".sql", ".toml", ".ts",
+			".txt", ".xml", ".yaml", ".yml",
+		},
+		Indicators: []string{"sha256", "text"},
+	},
+	{
+		MIMETypes: []string{
+			"application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+			"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			"application/vnd.openxmlformats-officedocument.presentationml.presentation",
+		},
+		Indicators: []string{"sha256", "archive", "doctext"},
+	},
+	{
+		MIMETypes:  []string{"application/pdf"},
+		Indicators: []string{"sha256", "doctext"},
+	},
+	{
+		MIMETypes: []string{
+			"application/zip",
+			"application/java-archive",
+			"application/epub+zip",
+			"application/vnd.oasis.opendocument.text",
+			"application/vnd.oasis.opendocument.spreadsheet",
+			"application/vnd.oasis.opendocument.presentation",
+			"application/x-tar",
+		},
+		Indicators: []string{"sha256", "archive"},
+	},
+	{
+		MIMETypes: []string{
+			"application/x-gzip",
+			"application/x-bzip2",
+		},
+		Indicators: []string{"sha256", "archive", "decompressed"},
+	},
+	{
+		Indicators: []string{"sha256"},
+	},
 }
 
 func main() {
@@ -153,7 +233,10 @@ func main() {
 		argparse.ActionFunc(argparse.Append),
 		argparse.Nargs(1),
 		argparse.Help(
-			"indicators to use to scan files",
+			"indicators to use to scan files.  "+
+				"Indicators can be configured with "+
+				"parameters (e.g. "+
+				"headtail:size=64k,algo=xxh64)",
 		),
 	).MustBind(&indicatorNames)
 	var createDB bool
@@ -164,29 +247,168 @@ func main() {
 			"initialize the database",
 		),
 	).MustBind(&createDB)
+	var staged bool
+	parser.MustAddArgument(
+		argparse.OptionStrings("-s", "--staged"),
+		argparse.ActionFunc(argparse.StoreTrue),
+		argparse.Help(
+			"run the indicators as stages where each "+
+				"stage only indicates the files that "+
+				"collide with another file after the "+
+				"previous stages (default stages: %s)",
+			strings.Join(defaultStages, ", "),
+		),
+	).MustBind(&staged)
+	var nearDuplicates bool
+	parser.MustAddArgument(
+		argparse.OptionStrings("-n", "--near-duplicates"),
+		argparse.ActionFunc(argparse.StoreTrue),
+		argparse.Help(
+			"after scanning, report the pairs of scanned "+
+				"files that the indicators consider "+
+				"similar",
+		),
+	).MustBind(&nearDuplicates)
+	var clusters bool
+	parser.MustAddArgument(
+		argparse.OptionStrings("-c", "--clusters"),
+		argparse.ActionFunc(argparse.StoreTrue),
+		argparse.Help(
+			"report --near-duplicates as clusters of "+
+				"similar files instead of pairs",
+		),
+	).MustBind(&clusters)
+	var threshold float64
+	parser.MustAddArgument(
+		argparse.OptionStrings("--similarity-threshold"),
+		argparse.MetaVar("THRESHOLD"),
+		argparse.ActionFunc(argparse.Store),
+		argparse.Type(argparse.Float64),
+		argparse.Default(0.0),
+		argparse.Help(
+			"minimum similarity from 0 to 1 of files "+
+				"reported by --near-duplicates "+
+				"(default: each indicator's own threshold)",
+		),
+	).MustBind(&threshold)
+	var mimeTypes []string
+	parser.MustAddArgument(
+		argparse.OptionStrings("-t", "--type"),
+		argparse.MetaVar("MIMETYPE"),
+		argparse.ActionFunc(argparse.Append),
+		argparse.Nargs(1),
+		argparse.Help(
+			"only indicate files whose content is of this "+
+				"media type (e.g. image/png) or any "+
+				"subtype of it (e.g. image/)",
+		),
+	).MustBind(&mimeTypes)
+	var routed bool
+	parser.MustAddArgument(
+		argparse.OptionStrings("-r", "--route"),
+		argparse.ActionFunc(argparse.StoreTrue),
+		argparse.Help(
+			"also indicate each file with the indicators "+
+				"that the routes in the configuration "+
+				"file choose for its type (default: "+
+				"sha256 and imagehash for images, "+
+				"sha256 and text for text and source "+
+				"files, sha256 and archive for ZIP and "+
+				"tar archives, sha256, archive and "+
+				"decompressed for compressed files and "+
+				"sha256 for everything else)",
+		),
+	).MustBind(&routed)
+	var format string
+	parser.MustAddArgument(
+		argparse.OptionStrings("-f", "--format"),
+		argparse.MetaVar("FORMAT"),
+		argparse.ActionFunc(argparse.Store),
+		argparse.Default(textFormat),
+		argparse.Help(
+			"output format: %s or %s.  With %s, the "+
+				"indications of each scanned file are "+
+				"written as a JSON object per line "+
+				"and so is the --near-duplicates "+
+				"report (default: %s)",
+			textFormat, jsonFormat, jsonFormat, textFormat,
+		),
+	).MustBind(&format)
+	var listIndicators bool
+	parser.MustAddArgument(
+		argparse.OptionStrings("-L", "--list-indicators"),
+		argparse.ActionFunc(argparse.StoreTrue),
+		argparse.Help(
+			"list the available indicators and exit",
+		),
+	).MustBind(&listIndicators)
 	_ = parser.MustParseArgs()
+	if listIndicators {
+		if err := printIndicators(os.Stdout); err != nil {
+			panic(err)
+		}
+		return
+	}
 	configFile := filepath.Join(me.HomeDir, ".config", "uniquefile.json")
 	if logFileCloser != nil {
 		defer logFileCloser()
 	}
 	if err := main2(
 		configFile, uriStrings, workers,
-		indicatorNames, createDB,
+		indicatorNames, mimeTypes, createDB, staged,
+		nearDuplicates, clusters, routed, threshold, format,
 	); err != nil {
 		panic(err)
 	}
 }
 
+// printIndicators writes the registered indicators and their
+// descriptions to w.
+func printIndicators(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, info := range uniquefile.ListIndicators() {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\n", info.Name, info.Description); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
 type scanner func(ctx context.Context, root uniquefile.URI, files chan indicationRequest)
 
 var scanners = map[string]scanner{
 	"file": scanLocalFiles,
 }
 
+type opener func(u uniquefile.URI) (io.ReadSeekCloser, error)
+
+// openers re-open resources that were indicated by a previous run so
+// that later stages can indicate them too.
+var openers = map[string]opener{
+	"file": openLocalFile,
+}
+
+// defaultStages are the indicators used by --staged when no
+// indicators are explicitly requested.
+var defaultStages = []string{"length", "headtail", "sha256"}
+
+// output formats selected by --format:
+const (
+	textFormat = "text"
+	jsonFormat = "json"
+)
+
 func main2(
 	configFile string, uriStrings []string, workers int,
-	indicatorNames []string, createDB bool,
+	indicatorNames, mimeTypes []string,
+	createDB, staged, nearDuplicates, clusters, routed bool,
+	threshold float64, format string,
 ) error {
+	if format != textFormat && format != jsonFormat {
+		return errors.Errorf1(
+			"unknown output format: %q", format,
+		)
+	}
 	type uriScanner struct {
 		uri     uniquefile.URI
 		scanner scanner
@@ -208,15 +430,17 @@ func main2(
 			)
 		}
 	}
-	indicators := make([]uniquefile.Indicator, len(indicatorNames))
-	for i, indStr := range indicatorNames {
-		var ok bool
-		indicators[i], ok = uniquefile.ParseIndicator(indStr)
-		if !ok {
-			return errors.Errorf1(
-				"no such indicator: %q", indStr,
-			)
-		}
+	if staged && routed {
+		return errors.Errorf0(
+			"--route cannot be combined with --staged",
+		)
+	}
+	if staged && len(indicatorNames) == 0 {
+		indicatorNames = defaultStages
+	}
+	indicators, err := parseIndicators(indicatorNames)
+	if err != nil {
+		return err
 	}
 	var cfg Config
 	{
@@ -234,6 +458,42 @@ func main2(
 			)
 		}
 	}
+	var router *uniquefile.RoutedIndicator
+	if routed {
+		routes := cfg.Routes
+		if len(routes) == 0 {
+			routes = defaultRoutes
+		}
+		limit := cfg.RouteLimit
+		if limit == 0 {
+			limit = uniquefile.DefaultRouteLimit
+		}
+		if router, err = newRoutedIndicator(limit, routes); err != nil {
+			return errors.Errorf1From(
+				err, "invalid routes in configuration "+
+					"file: %v",
+				configFile,
+			)
+		}
+	}
+	var similarers []uniquefile.IndicatorSimilarer
+	addSimilarers := func(irs []uniquefile.Indicator) {
+		for _, ir := range irs {
+			if s, ok := ir.(uniquefile.IndicatorSimilarer); ok {
+				similarers = append(similarers, s)
+			}
+		}
+	}
+	addSimilarers(indicators)
+	if router != nil {
+		addSimilarers(router.Indicators())
+	}
+	if nearDuplicates && len(similarers) == 0 {
+		return errors.Errorf0(
+			"none of the indicators can measure similarity " +
+				"to find near-duplicates",
+		)
+	}
 	di, err := sqlstream.ParseDialect(cfg.DB.Dialect)
 	if err != nil {
 		return errors.Errorf1From(
@@ -254,6 +514,12 @@ func main2(
 		if err := r.DB().CreateCollection(ctx, &sqlrepo.Indication{}); err != nil {
 			return err
 		}
+		if err := r.DB().CreateCollection(ctx, &sqlrepo.Chunk{}); err != nil {
+			return err
+		}
+		if err := r.DB().CreateCollection(ctx, &sqlrepo.Band{}); err != nil {
+			return err
+		}
 		logger.Verbose0("done creating database schema.")
 	}
 	if err != nil {
@@ -263,6 +529,331 @@ func main2(
 	}
 	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt)
 	defer cancel()
+	feedScanners := func(requests chan indicationRequest) {
+		var readerWg sync.WaitGroup
+		for _, uri := range uris {
+			readerWg.Add(1)
+			logger.Verbose0("starting reader goroutine...")
+			uri := uri
+			go func() {
+				defer readerWg.Done()
+				defer logger.Verbose0("stopping reader goroutine...")
+				uri.scanner(ctx, uri.uri, requests)
+			}()
+		}
+		readerWg.Wait()
+		logger.Verbose0("stopped reader goroutines.")
+	}
+	var scanned []uniquefile.URI
+	scan := func(req indicationRequest) {
+		scanned = append(scanned, req.uri)
+	}
+	if !nearDuplicates && format != jsonFormat {
+		scan = nil
+	}
+	// filters run before the indicators of the first (or only)
+	// stage:
+	var filters []uniquefile.Indicator
+	if len(mimeTypes) > 0 {
+		filters = append(filters, mimeTypeFilter(mimeTypes))
+	}
+	if !staged {
+		irs := append(filters, combineHashIndicators(indicators)...)
+		if router != nil {
+			irs = append(irs, router)
+		}
+		indicate(
+			ctx, cancel, r, workers, irs,
+			false, feedScanners, scan,
+		)
+	} else {
+		var indicated []indicationRequest
+		stored := func(req indicationRequest) {
+			indicated = append(indicated, req)
+		}
+		logger.Verbose1("starting stage 1: %v", indicatorNames[0])
+		indicate(
+			ctx, cancel, r, workers,
+			append(filters, indicators[0]), false,
+			feedScanners, func(req indicationRequest) {
+				stored(req)
+				if scan != nil {
+					scan(req)
+				}
+			},
+		)
+		for i, ir := range indicators[1:] {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			logger.Verbose2(
+				"starting stage %d: %v",
+				i+2, indicatorNames[i+1],
+			)
+			candidates := indicated
+			indicated = nil
+			feed := func(requests chan indicationRequest) {
+				feedCollisions(ctx, r, candidates, requests)
+			}
+			indicate(
+				ctx, cancel, r, workers,
+				[]uniquefile.Indicator{ir}, true,
+				feed, stored,
+			)
+		}
+	}
+	if format == jsonFormat {
+		if err := writeJSONIndications(ctx, r, os.Stdout, scanned); err != nil {
+			return err
+		}
+	}
+	if !nearDuplicates {
+		return nil
+	}
+	return reportNearDuplicates(ctx, r, os.Stdout, similarers, scanned, threshold, clusters, format)
+}
+
+// jsonIndications is the JSON object written by --format json for each
+// scanned resource.
+type jsonIndications struct {
+	URI         uniquefile.URI         `json:"uri"`
+	Indications *uniquefile.Indication `json:"indications"`
+}
+
+// writeJSONIndications writes the indications that the Repo has for
+// each of the URIs to w as a JSON object per line.
+func writeJSONIndications(ctx context.Context, r uniquefile.Repo, w io.Writer, uris []uniquefile.URI) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, u := range uris {
+		ind, err := r.Indications(ctx, u)
+		if err != nil {
+			return errors.Errorf1From(
+				err, "failed to get %v's indications", u,
+			)
+		}
+		err = enc.Encode(jsonIndications{URI: u, Indications: ind})
+		uniquefile.PutIndication(&ind)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonNearDuplicate is the JSON object written by --format json for
+// each pair of near-duplicates.
+type jsonNearDuplicate struct {
+	Similarity float64        `json:"similarity"`
+	Key        string         `json:"key"`
+	A          uniquefile.URI `json:"a"`
+	B          uniquefile.URI `json:"b"`
+}
+
+// jsonNearDuplicateCluster is the JSON object written by --format json
+// for each cluster of near-duplicates.
+type jsonNearDuplicateCluster struct {
+	Similarity float64          `json:"similarity"`
+	Key        string           `json:"key"`
+	URIs       []uniquefile.URI `json:"uris"`
+}
+
+// reportNearDuplicates writes the pairs (or clusters) of
+// near-duplicates among uris that each of the IndicatorSimilarers
+// finds to w, one per line, either as tab-separated text or as JSON
+// objects depending on format.  Near-duplicates by MinHash signatures
+// are found among all of the resources in the Repo if it is a
+// uniquefile.LSHRepo.
+func reportNearDuplicates(
+	ctx context.Context, r uniquefile.Repo, w io.Writer,
+	similarers []uniquefile.IndicatorSimilarer,
+	uris []uniquefile.URI, threshold float64, clusters bool,
+	format string,
+) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	lr, isLSHRepo := r.(uniquefile.LSHRepo)
+	// Only the IndicatorSimilarers that can't find their candidates
+	// in the Repo need the indications and only the values of their
+	// keys are kept.
+	var keys []uniquefile.Bytes
+	for _, s := range similarers {
+		if !isLSHRepo || !isMinHashSimilarer(s) {
+			keys = append(keys, s.Keys()...)
+		}
+	}
+	inds := make(map[uniquefile.URI]*uniquefile.Indication)
+	defer func() {
+		for _, ind := range inds {
+			uniquefile.PutIndication(&ind)
+		}
+	}()
+	for _, u := range uris {
+		if len(keys) == 0 {
+			break
+		}
+		ind, err := r.Indications(ctx, u)
+		if err != nil {
+			return errors.Errorf1From(
+				err, "failed to get %v's indications", u,
+			)
+		}
+		kept := uniquefile.NewIndication()
+		for _, key := range keys {
+			if v, ok := ind.Get([]byte(key)); ok {
+				kept.Write([]byte(key), v)
+			}
+		}
+		uniquefile.PutIndication(&ind)
+		if len(kept.Bytes()) == 0 {
+			uniquefile.PutIndication(&kept)
+			continue
+		}
+		inds[u] = kept
+	}
+	for _, s := range similarers {
+		var nds []uniquefile.NearDuplicate
+		var err error
+		if isLSHRepo && isMinHashSimilarer(s) {
+			nds, err = uniquefile.FindLSHNearDuplicates(ctx, lr, s, uris, threshold)
+		} else {
+			nds, err = uniquefile.FindNearDuplicates(ctx, s, inds, threshold)
+		}
+		if err != nil {
+			return err
+		}
+		if clusters {
+			for _, c := range uniquefile.ClusterNearDuplicates(nds) {
+				if format == jsonFormat {
+					if err := enc.Encode(jsonNearDuplicateCluster{
+						Similarity: c.Similarity,
+						Key:        string(c.Key),
+						URIs:       c.URIs,
+					}); err != nil {
+						return err
+					}
+					continue
+				}
+				if _, err := fmt.Fprintf(
+					w, "%.2f%%\t%s", c.Similarity*100, c.Key,
+				); err != nil {
+					return err
+				}
+				for _, u := range c.URIs {
+					if _, err := fmt.Fprintf(w, "\t%s", u.String()); err != nil {
+						return err
+					}
+				}
+				if _, err := fmt.Fprintln(w); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		for _, nd := range nds {
+			if format == jsonFormat {
+				if err := enc.Encode(jsonNearDuplicate{
+					Similarity: nd.Similarity,
+					Key:        string(nd.Key),
+					A:          nd.A,
+					B:          nd.B,
+				}); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := fmt.Fprintf(
+				w, "%.2f%%\t%s\t%s\t%s\n",
+				nd.Similarity*100, nd.Key,
+				nd.A.String(), nd.B.String(),
+			); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newRoutedIndicator creates a uniquefile.RoutedIndicator from the
+// route configurations.
+func newRoutedIndicator(limit int64, rcs []RouteConfig) (*uniquefile.RoutedIndicator, error) {
+	routes := make([]uniquefile.Route, len(rcs))
+	for i, rc := range rcs {
+		routes[i].MIMETypes = rc.MIMETypes
+		routes[i].Extensions = rc.Extensions
+		irs, err := parseIndicators(rc.Indicators)
+		if err != nil {
+			return nil, errors.Errorf1From(
+				err, "invalid route %d", i,
+			)
+		}
+		routes[i].Indicators = combineHashIndicators(irs)
+	}
+	return uniquefile.NewRoutedIndicator(limit, routes...)
+}
+
+// parseIndicators parses indicator specs into Indicators.
+func parseIndicators(indicatorNames []string) ([]uniquefile.Indicator, error) {
+	indicators := make([]uniquefile.Indicator, len(indicatorNames))
+	for i, indStr := range indicatorNames {
+		spec, err := uniquefile.ParseIndicatorSpec(indStr)
+		if err == nil {
+			indicators[i], err = uniquefile.NewIndicator(spec)
+		}
+		if err != nil {
+			return nil, errors.Errorf1From(
+				err, "invalid indicator: %q", indStr,
+			)
+		}
+	}
+	return indicators, nil
+}
+
+// isMinHashSimilarer checks if all of the keys that s compares are
+// MinHash signatures.
+func isMinHashSimilarer(s uniquefile.IndicatorSimilarer) bool {
+	for _, key := range s.Keys() {
+		if !uniquefile.IsMinHashKey([]byte(key)) {
+			return false
+		}
+	}
+	return true
+}
+
+// combineHashIndicators replaces the HashIndicators in irs with a
+// single Indicator that computes all of their hashes from one read of
+// each file.
+func combineHashIndicators(irs []uniquefile.Indicator) []uniquefile.Indicator {
+	var his []uniquefile.HashIndicator
+	combined := make([]uniquefile.Indicator, 0, len(irs))
+	for _, ir := range irs {
+		if hi, ok := ir.(uniquefile.HashIndicator); ok {
+			his = append(his, hi)
+			continue
+		}
+		combined = append(combined, ir)
+	}
+	switch len(his) {
+	case 0:
+	case 1:
+		combined = append(combined, his[0])
+	default:
+		combined = append(combined, uniquefile.NewMultiHashIndicator(his...))
+	}
+	return combined
+}
+
+// indicate runs the indicators over every request sent by feed and
+// stores the results into the Repo.  If merge is true, the results
+// are merged into the resources' existing indications instead of
+// replacing them.  If stored is not nil, it is called with each
+// request whose indications were successfully stored.
+func indicate(
+	ctx context.Context, cancel func(), r uniquefile.Repo, workers int,
+	indicators []uniquefile.Indicator, merge bool,
+	feed func(requests chan indicationRequest),
+	stored func(req indicationRequest),
+) {
 	requests := make(chan indicationRequest, 1024)
 	results := make(chan indictionResult, 1024)
 	repoCh := make(chan struct{})
@@ -279,6 +870,22 @@ func main2(
 					res.uri, res.err,
 				)
 			} else {
+				if merge {
+					ind, err := mergeIndications(ctx, r, res.uri, res.ind)
+					if err != nil {
+						logger.LogErr(
+							errors.Errorf1From(
+								err, "failed to merge "+
+									"%v's indications",
+								res.uri,
+							),
+						)
+						cancel()
+						return
+					}
+					uniquefile.PutIndication(&res.ind)
+					res.ind = ind
+				}
 				if err := r.SetIndications(ctx, res.uri, res.ind); err != nil {
 					logger.LogErr(
 						errors.Errorf2From(
@@ -290,6 +897,12 @@ func main2(
 					cancel()
 					return
 				}
+				if stored != nil {
+					stored(indicationRequest{
+						uri: res.uri,
+						rsc: res.rsc,
+					})
+				}
 			}
 			uniquefile.PutIndication(&res.ind)
 		}
@@ -304,26 +917,85 @@ func main2(
 			scanReadSeekClosers(ctx, indicators, requests, results)
 		}()
 	}
-	var readerWg sync.WaitGroup
-	for _, uri := range uris {
-		readerWg.Add(1)
-		logger.Verbose0("starting reader goroutine...")
-		uri := uri
-		go func() {
-			defer readerWg.Done()
-			defer logger.Verbose0("stopping reader goroutine...")
-			uri.scanner(ctx, uri.uri, requests)
-		}()
-	}
-	readerWg.Wait()
-	logger.Verbose0("stopped reader goroutines.")
+	feed(requests)
 	close(requests)
 	indicatorWg.Wait()
 	logger.Verbose0("stopped indicator goroutines.")
 	close(results)
 	<-repoCh
 	logger.Verbose0("stopped repository goroutine.")
-	return nil
+}
+
+// mergeIndications merges ind into the indications the Repo already
+// has for u.  Values in ind replace existing values with the same key.
+func mergeIndications(ctx context.Context, r uniquefile.Repo, u uniquefile.URI, ind *uniquefile.Indication) (*uniquefile.Indication, error) {
+	merged, err := r.Indications(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	if err := merged.Merge(ind, uniquefile.MergeReplace); err != nil {
+		uniquefile.PutIndication(&merged)
+		return nil, err
+	}
+	return merged, nil
+}
+
+// feedCollisions sends the candidates whose current indications
+// collide with another resource in the Repo into requests along with
+// the resources they collide with so the next stage can tell them
+// apart.
+func feedCollisions(ctx context.Context, r uniquefile.Repo, candidates []indicationRequest, requests chan indicationRequest) {
+	queued := make(map[uniquefile.URI]struct{}, len(candidates))
+	for _, req := range candidates {
+		if ctx.Err() != nil {
+			return
+		}
+		ind, err := r.Indications(ctx, req.uri)
+		if err != nil {
+			logger.LogErr(errors.Errorf1From(
+				err, "failed to get %v's indications",
+				req.uri,
+			))
+			continue
+		}
+		collisions, err := uniquefile.Collisions(ctx, r, req.uri, ind)
+		uniquefile.PutIndication(&ind)
+		if err != nil {
+			logger.LogErr(errors.Errorf1From(
+				err, "failed to find collisions with %v",
+				req.uri,
+			))
+			continue
+		}
+		if len(collisions) == 0 {
+			continue
+		}
+		if _, ok := queued[req.uri]; !ok {
+			queued[req.uri] = struct{}{}
+			requests <- req
+		}
+		for _, u := range collisions {
+			if _, ok := queued[u]; ok {
+				continue
+			}
+			queued[u] = struct{}{}
+			open, ok := openers[u.Scheme]
+			if !ok {
+				logger.Warn1(
+					"cannot re-open %v to indicate it",
+					u,
+				)
+				continue
+			}
+			u := u
+			requests <- indicationRequest{
+				uri: u,
+				rsc: func() (io.ReadSeekCloser, error) {
+					return open(u)
+				},
+			}
+		}
+	}
 }
 
 func scanLocalFiles(ctx context.Context, root uniquefile.URI, uris chan indicationRequest) {
@@ -376,6 +1048,38 @@ func scanLocalFiles(ctx context.Context, root uniquefile.URI, uris chan indicati
 	}
 }
 
+func openLocalFile(u uniquefile.URI) (io.ReadSeekCloser, error) {
+	f, err := os.Open(filePathOf(u))
+	if err != nil {
+		return nil, err // nil io.ReadSeekCloser
+	}
+	return f, nil
+}
+
+// errSkipped is returned by an Indicator in the scan pipeline to skip
+// the rest of the indicators and not store the file's indications.
+var errSkipped = errors.Errorf0("skipped")
+
+// mimeTypeFilter is an Indicator that detects its data's media type
+// and writes it like uniquefile.MIMETypeIndicator but returns
+// errSkipped if the type doesn't match any of the patterns.
+type mimeTypeFilter []string
+
+func (f mimeTypeFilter) Indicate(ctx context.Context, r io.Reader, ind *uniquefile.Indication) error {
+	detected := uniquefile.NewIndication()
+	defer uniquefile.PutIndication(&detected)
+	if err := uniquefile.MIMETypeIndicator.Indicate(ctx, r, detected); err != nil {
+		return err
+	}
+	return detected.Each(func(key, value []byte) error {
+		if !uniquefile.MatchMIMEType(string(value), f...) {
+			return errSkipped
+		}
+		ind.Write(key, value)
+		return nil
+	})
+}
+
 type indicationRequest struct {
 	uri uniquefile.URI
 	rsc func() (io.ReadSeekCloser, error)
@@ -383,6 +1087,7 @@ type indicationRequest struct {
 
 type indictionResult struct {
 	uri uniquefile.URI
+	rsc func() (io.ReadSeekCloser, error)
 	ind *uniquefile.Indication
 	err error
 }
@@ -405,6 +1110,9 @@ func scanReadSeekClosers(
 			logger.Info("scanReadSeekClosers goroutine shutting down")
 			return
 		}
+		// skipped is set before rsc is closed because the
+		// deferred Catch wraps errSkipped if Close fails too.
+		skipped := false
 		ind, err := func() (ind *uniquefile.Indication, Err error) {
 			ind = uniquefile.NewIndication()
 			rsc, err := req.rsc()
@@ -422,6 +1130,7 @@ func scanReadSeekClosers(
 			}
 			for _, ir := range indicators {
 				if err := ir.Indicate(ctx, rsc, ind); err != nil {
+					skipped = err == errSkipped
 					return nil, err
 				}
 				if _, err := rsc.Seek(start, io.SeekStart); err != nil {
@@ -434,8 +1143,13 @@ func scanReadSeekClosers(
 			}
 			return ind, nil
 		}()
+		if skipped {
+			logger.Verbose1("skipped %v", req.uri)
+			continue
+		}
 		res := indictionResult{
 			uri: req.uri,
+			rsc: req.rsc,
 			ind: ind,
 			err: err,
 		}
@@ -1,6 +1,7 @@
 package uniquefile
 
 import (
+	"encoding"
 	"net/url"
 	"strings"
 )
@@ -17,6 +18,11 @@ type URI struct {
 	Query    string
 }
 
+var (
+	_ encoding.TextMarshaler   = URI{}
+	_ encoding.TextUnmarshaler = (*URI)(nil)
+)
+
 func (u *URI) FromString(s string) error {
 	ur, err := url.Parse(s)
 	if err != nil {
@@ -62,3 +68,14 @@ func (u *URI) String() string {
 	}
 	return strings.Join(parts[:], "")
 }
+
+// MarshalText formats the URI like String so that it is encoded as a
+// string in JSON.
+func (u URI) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText parses the URI like FromString.
+func (u *URI) UnmarshalText(text []byte) error {
+	return u.FromString(string(text))
+}
@@ -1,6 +1,7 @@
 package uniquefile_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/skillian/uniquefile"
@@ -46,3 +47,31 @@ func TestURI(t *testing.T) {
 		})
 	}
 }
+
+func TestURIMarshalText(t *testing.T) {
+	for _, tc := range uriTests {
+		t.Run(tc.source, func(t *testing.T) {
+			bs, err := json.Marshal(map[string]uniquefile.URI{"uri": tc.uri})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect := `{"uri":"` + tc.source + `"}`
+			if string(bs) != expect {
+				t.Fatalf(
+					"expected does not match actual:\n\t%v\n\t%v",
+					expect, string(bs),
+				)
+			}
+			var m map[string]uniquefile.URI
+			if err := json.Unmarshal(bs, &m); err != nil {
+				t.Fatal(err)
+			}
+			if m["uri"] != tc.uri {
+				t.Fatalf(
+					"actual URI does not match expected:\n\t%v\n\t%v",
+					m["uri"], tc.uri,
+				)
+			}
+		})
+	}
+}